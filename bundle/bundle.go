@@ -0,0 +1,67 @@
+// Package bundle implements encrypted export bundles of a Mole instance,
+// used to transfer accounts between machines.
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/storage"
+	"github.com/frankbraun/mole/util"
+)
+
+// Version of bundle format.
+const Version = 1
+
+// A Bundle contains all exported data of a Mole instance.
+type Bundle struct {
+	Version int             // bundle format version
+	Hill    json.RawMessage // exported hill (accounts and contacts)
+}
+
+// Export hill as an encrypted bundle to backend, using a separate export
+// passphrase.
+func Export(backend storage.Backend, passphrase []byte, hill *config.Hill) error {
+	b := Bundle{
+		Version: Version,
+		Hill:    hill.Marshal(),
+	}
+	defer util.Wipe(b.Hill)
+	jsn, err := json.Marshal(&b)
+	if err != nil {
+		return err
+	}
+	defer util.Wipe(jsn)
+	s, err := storage.Create(backend, passphrase, jsn)
+	if err != nil {
+		return err
+	}
+	s.Wipe()
+	return nil
+}
+
+// Import the encrypted bundle stored in backend and return the contained
+// hill.
+func Import(backend storage.Backend, passphrase []byte) (*config.Hill, error) {
+	s, jsn, err := storage.Open(backend, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	s.Wipe()
+	defer util.Wipe(jsn)
+	var b Bundle
+	if err := json.Unmarshal(jsn, &b); err != nil {
+		return nil, err
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("bundle: read version %d incompatible with expected version %d",
+			b.Version, Version)
+	}
+	defer util.Wipe(b.Hill)
+	if b.Hill == nil {
+		return nil, fmt.Errorf("bundle: '%s' contains no hill", backend.Name())
+	}
+	hill, _, err := config.Unmarshal(b.Hill)
+	return hill, err
+}
@@ -0,0 +1,69 @@
+package bundle
+
+import (
+	"testing"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/storage"
+)
+
+var passphrase = []byte("Exportgeheimnis")
+
+func TestExportImportMerge(t *testing.T) {
+	hill, err := config.NewHill()
+	if err != nil {
+		t.Fatalf("config.NewHill() failed: %v", err)
+	}
+	hill.Accounts = []config.Account{
+		{Username: "alice@example.com", Password: config.Secret("a")},
+		{Username: "bob@example.com", Password: config.Secret("b")},
+	}
+	hill.Contacts = []config.Contact{
+		{Remote: "carol@example.com", Local: "alice@example.com"},
+		{Remote: "dave@example.com", Local: "bob@example.com"},
+	}
+	sel, err := hill.Select([]string{"bob@example.com"})
+	if err != nil {
+		t.Fatalf("Select() failed: %v", err)
+	}
+	backend := storage.NewMemoryBackend("test.bundle")
+	if err := Export(backend, passphrase, sel); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if _, err := Import(backend, []byte("wrong")); err != storage.ErrAuthentication {
+		t.Errorf("Import() with wrong passphrase returned %v", err)
+	}
+	imported, err := Import(backend, passphrase)
+	if err != nil {
+		t.Fatalf("Import() failed: %v", err)
+	}
+	if len(imported.Accounts) != 1 || len(imported.Contacts) != 1 {
+		t.Fatalf("Import() returned wrong selection: %v", imported)
+	}
+	// merge into hill with a conflicting account
+	target, err := config.NewHill()
+	if err != nil {
+		t.Fatalf("config.NewHill() failed: %v", err)
+	}
+	target.Accounts = []config.Account{
+		{Username: "bob@example.com", Password: config.Secret("other")},
+	}
+	conflicts := target.Merge(imported)
+	if len(conflicts) != 1 || conflicts[0].Account != "bob@example.com" {
+		t.Errorf("Merge() returned wrong conflicts: %v", conflicts)
+	}
+	if string(target.Account("bob@example.com").Password) != "other" {
+		t.Error("Merge() overwrote existing account")
+	}
+	// merge into empty hill
+	target, err = config.NewHill()
+	if err != nil {
+		t.Fatalf("config.NewHill() failed: %v", err)
+	}
+	if conflicts := target.Merge(imported); len(conflicts) != 0 {
+		t.Errorf("Merge() returned conflicts: %v", conflicts)
+	}
+	if len(target.Accounts) != 1 || len(target.Contacts) != 1 {
+		t.Errorf("Merge() did not merge: %v", target)
+	}
+}
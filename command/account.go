@@ -0,0 +1,71 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/frankbraun/mole/config"
+)
+
+func accountAdd(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<jid>")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	passwordFD := passphraseFlag(fs, "password-fd", "account password")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	password, err := readPassphrase(*passwordFD, "", "account password", true)
+	if err != nil {
+		return err
+	}
+	account := config.Account{
+		Username: fs.Arg(0),
+		Password: config.Secret(password),
+	}
+	if err := hill.AddAccount(account); err != nil {
+		account.Password.Wipe()
+		return err
+	}
+	return save(state, hill)
+}
+
+func accountList(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	state.Wipe()
+	defer hill.Wipe()
+	for _, account := range hill.Accounts {
+		fmt.Fprintln(opts.stdout(), account.Username)
+	}
+	return nil
+}
+
+func accountRemove(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<jid>")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	if err := hill.RemoveAccount(fs.Arg(0)); err != nil {
+		return err
+	}
+	return save(state, hill)
+}
@@ -0,0 +1,202 @@
+// Package command implements the non-interactive subcommands of Mole.
+package command
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/storage"
+	"github.com/frankbraun/mole/util"
+	"github.com/frankbraun/mole/util/terminal"
+)
+
+// PassphraseEnv is the environment variable the hill passphrase is read from,
+// if it is set.
+const PassphraseEnv = "MOLE_PASSPHRASE"
+
+// Options are the global options passed to all commands.
+type Options struct {
+	Backend   storage.Backend // storage backend of (locked) .hill file
+	Socket    string          // path of the control socket of the daemon
+	XMPPDebug bool            // enable XMPP debugging
+	Stdout    io.Writer       // output of commands (os.Stdout, if nil)
+}
+
+// stdout returns the writer the output of commands is written to.
+func (opts *Options) stdout() io.Writer {
+	if opts.Stdout == nil {
+		return os.Stdout
+	}
+	return opts.Stdout
+}
+
+type command func(argv0 string, opts *Options, args ...string) error
+
+var commands = map[string]command{
+	"account add":    accountAdd,
+	"account list":   accountList,
+	"account remove": accountRemove,
+	"contact add":    contactAdd,
+	"contact list":   contactList,
+	"contact remove": contactRemove,
+	"daemon":         runDaemon,
+	"export":         exportHill,
+	"hook add":       hookAdd,
+	"hook list":      hookList,
+	"hook remove":    hookRemove,
+	"import":         importHill,
+	"pipe":           runPipe,
+	"rekey":          rekey,
+	"room add":       roomAdd,
+	"room list":      roomList,
+	"room remove":    roomRemove,
+	"send":           send,
+}
+
+// Names returns the sorted names of all commands.
+func Names() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run the command given in args (e.g., "account", "add", "user@example.com").
+func Run(argv0 string, opts *Options, args []string) error {
+	for _, n := range []int{2, 1} {
+		if len(args) < n {
+			continue
+		}
+		name := strings.Join(args[:n], " ")
+		if cmd, ok := commands[name]; ok {
+			return cmd(argv0+" "+name, opts, args[n:]...)
+		}
+	}
+	return fmt.Errorf("unknown command '%s'", strings.Join(args, " "))
+}
+
+// newFlagSet returns a new flag set for the command argv0 with the given
+// usage string for the positional arguments.
+func newFlagSet(argv0, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(argv0, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] %s\n", argv0, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses args with fs and checks that the number of positional
+// arguments is at least minArgs and at most maxArgs (if maxArgs >= 0). On error the
+// usage is shown and flag.ErrHelp is returned.
+func parseArgs(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp // usage has been shown already
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	return nil
+}
+
+// passphraseFlag registers the option to read a passphrase from a file
+// descriptor in fs.
+func passphraseFlag(fs *flag.FlagSet, name, what string) *int {
+	return fs.Int(name, -1, "read "+what+" from file descriptor")
+}
+
+// readLine reads a single line from file descriptor fd.
+func readLine(fd int) ([]byte, error) {
+	fp := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if fp == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer fp.Close()
+	line, err := bufio.NewReader(fp).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	line = []byte(strings.TrimRight(string(line), "\r\n"))
+	if len(line) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+	return line, nil
+}
+
+// readPassphrase reads a passphrase from file descriptor fd (if fd >= 0),
+// from the environment variable env (if not empty and set), or from the
+// terminal showing prompt.
+func readPassphrase(fd int, env, prompt string, confirm bool) ([]byte, error) {
+	if fd >= 0 {
+		return readLine(fd)
+	}
+	if env != "" {
+		if pass := os.Getenv(env); pass != "" {
+			return []byte(pass), nil
+		}
+	}
+	return terminal.ReadPassphrase(int(os.Stdin.Fd()), prompt, confirm)
+}
+
+// openHill reads the hill passphrase (see readPassphrase) and opens the
+// .hill file in backend.
+func openHill(backend storage.Backend, fd int) (*storage.State, *config.Hill, error) {
+	pass, err := readPassphrase(fd, PassphraseEnv, "hill passphrase", false)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, data, err := storage.Open(backend, pass)
+	util.Wipe(pass)
+	if err != nil {
+		return nil, nil, err
+	}
+	hill, warnings, err := config.Unmarshal(data)
+	util.Wipe(data)
+	if err != nil {
+		state.Wipe()
+		return nil, nil, err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	return state, hill, nil
+}
+
+// save hill to state.
+func save(state *storage.State, hill *config.Hill) error {
+	data := hill.Marshal()
+	defer util.Wipe(data)
+	return state.Save(data)
+}
+
+// selectAccount returns the account with the given username, or the last
+// account if username is empty.
+func selectAccount(hill *config.Hill, username string) (*config.Account, error) {
+	if username == "" {
+		account := hill.LastAccount()
+		if account == nil {
+			return nil, errors.New("no account defined")
+		}
+		return account, nil
+	}
+	username, err := jid.Normalize(username)
+	if err != nil {
+		return nil, err
+	}
+	account := hill.Account(username)
+	if account == nil {
+		return nil, fmt.Errorf("account '%s' does not exist", username)
+	}
+	return account, nil
+}
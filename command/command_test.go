@@ -0,0 +1,212 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/storage"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+const passphrase = "Staatsgeheimnis"
+
+// pipe returns a file descriptor from which line can be read.
+func pipe(t *testing.T, line string) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() failed: %v", err)
+	}
+	defer r.Close()
+	if _, err := w.WriteString(line + "\n"); err != nil {
+		t.Fatalf("WriteString() failed: %v", err)
+	}
+	w.Close()
+	// the command takes ownership of the returned file descriptor
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatalf("syscall.Dup() failed: %v", err)
+	}
+	return strconv.Itoa(fd)
+}
+
+func openTestHill(t *testing.T, backend storage.Backend, pass string) *config.Hill {
+	_, data, err := storage.Open(backend, []byte(pass))
+	if err != nil {
+		t.Fatalf("storage.Open() failed: %v", err)
+	}
+	hill, _, err := config.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("config.Unmarshal() failed: %v", err)
+	}
+	return hill
+}
+
+func TestCommands(t *testing.T) {
+	os.Setenv(PassphraseEnv, passphrase)
+	defer os.Unsetenv(PassphraseEnv)
+	backend := storage.NewMemoryBackend("test.hill")
+	hill, err := config.NewHill()
+	if err != nil {
+		t.Fatalf("config.NewHill() failed: %v", err)
+	}
+	if _, err := storage.Create(backend, []byte(passphrase), hill.Marshal()); err != nil {
+		t.Fatalf("storage.Create() failed: %v", err)
+	}
+	var out bytes.Buffer
+	opts := &Options{Backend: backend, Stdout: &out}
+	run := func(args ...string) error {
+		out.Reset()
+		return Run("mole", opts, args)
+	}
+	if err := run("account", "add", "-password-fd", pipe(t, "secret"), "Alice@Example.com"); err != nil {
+		t.Fatalf("account add failed: %v", err)
+	}
+	if err := run("account", "add", "-password-fd", pipe(t, "secret"), "alice@example.com"); err == nil {
+		t.Error("adding duplicate account should fail")
+	}
+	if err := run("contact", "add", "bob@example.com"); err != nil {
+		t.Fatalf("contact add failed: %v", err)
+	}
+	if err := run("contact", "add", "-a", "carol@example.com", "bob@example.com"); err == nil {
+		t.Error("adding contact to unknown account should fail")
+	}
+	if err := run("account", "list"); err != nil {
+		t.Errorf("account list failed: %v", err)
+	} else if out.String() != "alice@example.com\n" {
+		t.Errorf("account list: %q", out.String())
+	}
+	if err := run("contact", "list"); err != nil {
+		t.Errorf("contact list failed: %v", err)
+	} else if out.String() != "alice@example.com bob@example.com\n" {
+		t.Errorf("contact list: %q", out.String())
+	}
+	hill = openTestHill(t, backend, passphrase)
+	account := hill.Account("alice@example.com")
+	if account == nil || string(account.Password) != "secret" {
+		t.Fatalf("account not added: %v", hill.Accounts)
+	}
+	if len(hill.AccountContacts("alice@example.com")) != 1 {
+		t.Fatalf("contact not added: %v", hill.Contacts)
+	}
+	if err := run("room", "add", "-password-fd", pipe(t, "pw"), "Team@Conference.Example.com"); err != nil {
+		t.Fatalf("room add failed: %v", err)
+	}
+	if err := run("room", "add", "team@conference.example.com"); err == nil {
+		t.Error("adding duplicate room should fail")
+	}
+	if err := run("room", "list"); err != nil {
+		t.Errorf("room list failed: %v", err)
+	} else if out.String() != "alice@example.com team@conference.example.com/alice (autojoin)\n" {
+		t.Errorf("room list: %q", out.String())
+	}
+	hill = openTestHill(t, backend, passphrase)
+	room := hill.Room("alice@example.com", "team@conference.example.com")
+	if room == nil || room.Nick != "alice" || string(room.Password) != "pw" || !room.AutoJoin {
+		t.Fatalf("room not added: %+v", hill.Rooms)
+	}
+	if err := run("room", "remove", "team@conference.example.com"); err != nil {
+		t.Fatalf("room remove failed: %v", err)
+	}
+	if err := run("contact", "remove", "bob@example.com"); err != nil {
+		t.Fatalf("contact remove failed: %v", err)
+	}
+	if err := run("account", "remove", "alice@example.com"); err != nil {
+		t.Fatalf("account remove failed: %v", err)
+	}
+	hill = openTestHill(t, backend, passphrase)
+	if len(hill.Accounts) != 0 || len(hill.Contacts) != 0 {
+		t.Errorf("account or contact not removed: %v", hill)
+	}
+	if err := run("hook", "add", "-events", "message,mention", "notify-send", "mole"); err != nil {
+		t.Fatalf("hook add failed: %v", err)
+	}
+	if err := run("hook", "add", "-events", "typing", "notify-send"); err == nil {
+		t.Error("adding hook with unknown event should fail")
+	}
+	if err := run("hook", "add", "-events", "disconnect", "-url", "https://example.com/hook"); err != nil {
+		t.Fatalf("hook add failed: %v", err)
+	}
+	if err := run("hook", "remove", "0"); err != nil {
+		t.Fatalf("hook remove failed: %v", err)
+	}
+	if err := run("hook", "list"); err != nil {
+		t.Errorf("hook list failed: %v", err)
+	} else if out.String() != "0 disconnect https://example.com/REDACTED\n" {
+		t.Errorf("hook list: %q", out.String())
+	}
+	hill = openTestHill(t, backend, passphrase)
+	if hooks := hill.Settings.Hooks; len(hooks) != 1 || hooks[0].URL != "https://example.com/hook" {
+		t.Errorf("hooks not added or removed: %+v", hooks)
+	}
+	if err := run("rekey", "-new-passphrase-fd", pipe(t, "new")); err != nil {
+		t.Fatalf("rekey failed: %v", err)
+	}
+	openTestHill(t, backend, "new")
+	if err := run("unknown"); err == nil {
+		t.Error("unknown command should fail")
+	}
+}
+
+func TestPipeLoop(t *testing.T) {
+	filter, err := parseFilter("Bob@Example.com, carol@example.org/phone")
+	if err != nil {
+		t.Fatalf("parseFilter() failed: %v", err)
+	}
+	r, w := io.Pipe()
+	var out bytes.Buffer
+	send := make(chan xmpp.Message)
+	recv := make(chan xmpp.Event)
+	done := make(chan error)
+	go func() {
+		done <- pipeLoop(r, &out, "bob@example.com", filter, send, recv)
+	}()
+	io.WriteString(w, "hello\n")
+	if msg := <-send; msg.To != "bob@example.com" || msg.Text != "hello" {
+		t.Errorf("sent %+v", msg)
+	}
+	for _, from := range []string{"bob@example.com", "dave@example.net", "carol@example.org"} {
+		recv <- xmpp.Event{
+			Kind:    xmpp.MessageEvent,
+			Message: &xmpp.Message{From: from, Text: "hi"},
+		}
+	}
+	io.WriteString(w, "\nbye\n")
+	if msg := <-send; msg.Text != "bye" {
+		t.Errorf("sent %+v", msg)
+	}
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("pipeLoop() failed: %v", err)
+	}
+	dec := json.NewDecoder(&out)
+	var froms []string
+	for dec.More() {
+		var msg xmpp.Message
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("Decode() failed: %v", err)
+		}
+		froms = append(froms, msg.From)
+	}
+	if strings.Join(froms, " ") != "bob@example.com carol@example.org" {
+		t.Errorf("printed messages from %v", froms)
+	}
+}
+
+func TestPipeLoopDisconnect(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	recv := make(chan xmpp.Event, 1)
+	recv <- xmpp.Event{Kind: xmpp.DisconnectEvent, Error: "EOF"}
+	err := pipeLoop(r, ioutil.Discard, "bob@example.com", nil, nil, recv)
+	if err == nil {
+		t.Error("pipeLoop() should fail on disconnect")
+	}
+}
@@ -0,0 +1,94 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/frankbraun/mole/config"
+)
+
+func contactAdd(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<jid>")
+	username := fs.String("a", "", "account to add contact to (default: last account)")
+	noChatStates := fs.Bool("no-chatstates", false, "do not send chat states (typing notifications) to contact")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	account, err := selectAccount(hill, *username)
+	if err != nil {
+		return err
+	}
+	err = hill.AddContact(config.Contact{
+		Remote:       fs.Arg(0),
+		Local:        account.Username,
+		NoChatStates: *noChatStates,
+	})
+	if err != nil {
+		return err
+	}
+	return save(state, hill)
+}
+
+func contactList(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "")
+	username := fs.String("a", "", "only list contacts of account")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	state.Wipe()
+	defer hill.Wipe()
+	contacts := hill.Contacts
+	if *username != "" {
+		account, err := selectAccount(hill, *username)
+		if err != nil {
+			return err
+		}
+		contacts = hill.AccountContacts(account.Username)
+	}
+	for _, contact := range contacts {
+		var noChatStates string
+		if contact.NoChatStates {
+			noChatStates = " (no chat states)"
+		}
+		fmt.Fprintf(opts.stdout(), "%s %s%s\n", contact.Local, contact.Remote, noChatStates)
+	}
+	return nil
+}
+
+func contactRemove(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<jid>")
+	username := fs.String("a", "", "account to remove contact from (default: last account)")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	account, err := selectAccount(hill, *username)
+	if err != nil {
+		return err
+	}
+	err = hill.RemoveContact(config.Contact{
+		Remote: fs.Arg(0),
+		Local:  account.Username,
+	})
+	if err != nil {
+		return err
+	}
+	return save(state, hill)
+}
@@ -0,0 +1,36 @@
+package command
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/daemon"
+)
+
+func runDaemon(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	srv := daemon.New(hill, state.Save, opts.XMPPDebug)
+	defer srv.Close()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if _, ok := <-sigs; ok {
+			log.Println("signal received, closing daemon")
+			srv.Close()
+		}
+	}()
+	srv.Start()
+	return srv.Serve(opts.Socket)
+}
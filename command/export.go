@@ -0,0 +1,111 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/frankbraun/mole/bundle"
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/storage"
+	"github.com/frankbraun/mole/util"
+)
+
+// exportHill exports accounts from the .hill file to an encrypted bundle.
+func exportHill(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<bundle>")
+	accounts := fs.String("a", "", "comma-separated list of accounts to export (default: all)")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	exportFD := passphraseFlag(fs, "export-passphrase-fd", "export passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	exportFile := fs.Arg(0)
+	var usernames []string
+	if *accounts != "" {
+		usernames = strings.Split(*accounts, ",")
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	state.Wipe()
+	defer hill.Wipe()
+	sel, err := hill.Select(usernames)
+	if err != nil {
+		return err
+	}
+	pass, err := readPassphrase(*exportFD, "", "export passphrase", true)
+	if err != nil {
+		return err
+	}
+	defer util.Wipe(pass)
+	if err := bundle.Export(storage.NewFileBackend(exportFile), pass, sel); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d account(s) to '%s'\n", len(sel.Accounts), exportFile)
+	return nil
+}
+
+// importHill imports an encrypted bundle into the .hill file. If the .hill
+// file does not exist it is created.
+func importHill(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<bundle>")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	exportFD := passphraseFlag(fs, "export-passphrase-fd", "export passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	importFile := fs.Arg(0)
+	pass, err := readPassphrase(*exportFD, "", "export passphrase", false)
+	if err != nil {
+		return err
+	}
+	imported, err := bundle.Import(storage.NewFileBackend(importFile), pass)
+	util.Wipe(pass)
+	if err != nil {
+		return err
+	}
+	defer imported.Wipe()
+	exists, err := opts.Backend.Exists()
+	if err != nil {
+		return err
+	}
+	var conflicts []config.Conflict
+	if exists {
+		state, hill, err := openHill(opts.Backend, *passFD)
+		if err != nil {
+			return err
+		}
+		defer state.Wipe()
+		defer hill.Wipe()
+		conflicts = hill.Merge(imported)
+		if err := save(state, hill); err != nil {
+			return err
+		}
+	} else {
+		hill, err := config.NewHill()
+		if err != nil {
+			return err
+		}
+		defer hill.Wipe()
+		conflicts = hill.Merge(imported)
+		pass, err := readPassphrase(*passFD, PassphraseEnv, "new hill passphrase", true)
+		if err != nil {
+			return err
+		}
+		defer util.Wipe(pass)
+		data := hill.Marshal()
+		defer util.Wipe(data)
+		state, err := storage.Create(opts.Backend, pass, data)
+		if err != nil {
+			return err
+		}
+		state.Wipe()
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "conflict: %s\n", conflict)
+	}
+	fmt.Fprintf(os.Stderr, "imported '%s' with %d conflict(s)\n", importFile, len(conflicts))
+	return nil
+}
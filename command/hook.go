@@ -0,0 +1,93 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/frankbraun/mole/config"
+)
+
+func hookAdd(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "[<command> [<arguments>]]")
+	events := fs.String("events", "", "comma-separated list of events ("+
+		strings.Join(config.HookEvents, ", ")+")")
+	url := fs.String("url", "", "post events to webhook URL instead of running a command")
+	timeout := fs.Int("timeout", 0, fmt.Sprintf("timeout in seconds (default %d)",
+		config.DefaultHookTimeout))
+	content := fs.Bool("content", false, "pass decrypted message texts to hook")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 0, -1); err != nil {
+		return err
+	}
+	hook := config.Hook{
+		URL:     *url,
+		Timeout: *timeout,
+		Content: *content,
+	}
+	if *events != "" {
+		hook.Events = strings.Split(*events, ",")
+	}
+	if fs.NArg() > 0 {
+		hook.Command = fs.Args()
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	if err := hill.AddHook(hook); err != nil {
+		return err
+	}
+	return save(state, hill)
+}
+
+func hookList(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	state.Wipe()
+	defer hill.Wipe()
+	for i, hook := range hill.Settings.Hooks {
+		target := hook.RedactedURL()
+		if target == "" {
+			target = strings.Join(hook.Command, " ")
+		}
+		var flags string
+		if hook.Content {
+			flags = " (content)"
+		}
+		fmt.Fprintf(opts.stdout(), "%d %s %s%s\n", i, strings.Join(hook.Events, ","),
+			target, flags)
+	}
+	return nil
+}
+
+func hookRemove(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<index>")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	i, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	if err := hill.RemoveHook(i); err != nil {
+		return err
+	}
+	return save(state, hill)
+}
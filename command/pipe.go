@@ -0,0 +1,122 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+func runPipe(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<jid>")
+	username := fs.String("a", "", "account to use (default: last account)")
+	from := fs.String("from", "", "comma-separated list of JIDs to print messages from (default: <jid>)")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	remote, err := jid.Parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	remote = remote.Bare()
+	filter := map[string]bool{remote.String(): true}
+	if *from != "" {
+		filter, err = parseFilter(*from)
+		if err != nil {
+			return err
+		}
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	state.Wipe()
+	defer hill.Wipe()
+	account, err := selectAccount(hill, *username)
+	if err != nil {
+		return err
+	}
+	send := make(chan xmpp.Message)
+	recv := make(chan xmpp.Event)
+	session, err := xmpp.Start(account, send, recv, opts.XMPPDebug)
+	if err != nil {
+		return err
+	}
+	err = pipeLoop(os.Stdin, opts.stdout(), remote.String(), filter, send, recv)
+	close(send)
+	session.Flush()
+	if cerr := session.Close(); cerr != nil && err == nil {
+		return cerr
+	}
+	return err
+}
+
+// parseFilter parses a comma-separated list of JIDs and returns the set of
+// their bare JIDs.
+func parseFilter(list string) (map[string]bool, error) {
+	filter := make(map[string]bool)
+	for _, s := range strings.Split(list, ",") {
+		j, err := jid.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		filter[j.Bare().String()] = true
+	}
+	return filter, nil
+}
+
+// pipeLoop sends every non-empty line read from r as a message to remote
+// and writes every message received from a JID in filter as a JSON object
+// line to w, until r reaches EOF or the session ends.
+func pipeLoop(
+	r io.Reader,
+	w io.Writer,
+	remote string,
+	filter map[string]bool,
+	send chan<- xmpp.Message,
+	recv <-chan xmpp.Event,
+) error {
+	lines := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		errc <- scanner.Err()
+	}()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return <-errc // EOF
+			}
+			if line != "" {
+				send <- xmpp.Message{To: remote, Text: line}
+			}
+		case ev, ok := <-recv:
+			if !ok {
+				return errors.New("XMPP session closed")
+			}
+			switch ev.Kind {
+			case xmpp.MessageEvent:
+				if filter[ev.Message.From] {
+					if err := enc.Encode(ev.Message); err != nil {
+						return err
+					}
+				}
+			case xmpp.DisconnectEvent:
+				return fmt.Errorf("connection lost: %s", ev.Error)
+			}
+		}
+	}
+}
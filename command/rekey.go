@@ -0,0 +1,26 @@
+package command
+
+import (
+	"github.com/frankbraun/mole/storage"
+	"github.com/frankbraun/mole/util"
+)
+
+func rekey(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "")
+	passFD := passphraseFlag(fs, "passphrase-fd", "old hill passphrase")
+	newPassFD := passphraseFlag(fs, "new-passphrase-fd", "new hill passphrase")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	oldPass, err := readPassphrase(*passFD, PassphraseEnv, "old hill passphrase", false)
+	if err != nil {
+		return err
+	}
+	defer util.Wipe(oldPass)
+	newPass, err := readPassphrase(*newPassFD, "", "new hill passphrase", true)
+	if err != nil {
+		return err
+	}
+	defer util.Wipe(newPass)
+	return storage.Rekey(opts.Backend, oldPass, newPass)
+}
@@ -0,0 +1,119 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+)
+
+func roomAdd(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<room>")
+	username := fs.String("a", "", "account to add room to (default: last account)")
+	nick := fs.String("nick", "", "nickname in room (default: localpart of account)")
+	name := fs.String("name", "", "name of room bookmark")
+	history := fs.Int("history", config.DefaultRoomHistory, "number of history messages requested on join")
+	noAutoJoin := fs.Bool("no-autojoin", false, "do not join room automatically")
+	passwordFD := passphraseFlag(fs, "password-fd", "room password")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	var password []byte
+	if *passwordFD >= 0 {
+		var err error
+		password, err = readLine(*passwordFD)
+		if err != nil {
+			return err
+		}
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	account, err := selectAccount(hill, *username)
+	if err != nil {
+		return err
+	}
+	if *nick == "" {
+		j, err := jid.Parse(account.Username)
+		if err != nil {
+			return err
+		}
+		*nick = j.Local
+	}
+	err = hill.AddRoom(config.Room{
+		Room:     fs.Arg(0),
+		Local:    account.Username,
+		Name:     *name,
+		Nick:     *nick,
+		Password: password,
+		History:  *history,
+		AutoJoin: !*noAutoJoin,
+	})
+	if err != nil {
+		config.Secret(password).Wipe()
+		return err
+	}
+	return save(state, hill)
+}
+
+func roomList(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "")
+	username := fs.String("a", "", "only list rooms of account")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	state.Wipe()
+	defer hill.Wipe()
+	rooms := hill.Rooms
+	if *username != "" {
+		account, err := selectAccount(hill, *username)
+		if err != nil {
+			return err
+		}
+		rooms = hill.AccountRooms(account.Username)
+	}
+	for _, room := range rooms {
+		var name, autoJoin string
+		if room.Name != "" {
+			name = fmt.Sprintf(" '%s'", room.Name)
+		}
+		if room.AutoJoin {
+			autoJoin = " (autojoin)"
+		}
+		fmt.Fprintf(opts.stdout(), "%s %s/%s%s%s\n", room.Local, room.Room, room.Nick,
+			name, autoJoin)
+	}
+	return nil
+}
+
+func roomRemove(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<room>")
+	username := fs.String("a", "", "account to remove room from (default: last account)")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	defer state.Wipe()
+	defer hill.Wipe()
+	account, err := selectAccount(hill, *username)
+	if err != nil {
+		return err
+	}
+	if err := hill.RemoveRoom(account.Username, fs.Arg(0)); err != nil {
+		return err
+	}
+	return save(state, hill)
+}
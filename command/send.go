@@ -0,0 +1,28 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/frankbraun/mole/xmpp"
+)
+
+func send(argv0 string, opts *Options, args ...string) error {
+	fs := newFlagSet(argv0, "<jid> <text>")
+	username := fs.String("a", "", "account to send from (default: last account)")
+	passFD := passphraseFlag(fs, "passphrase-fd", "hill passphrase")
+	if err := parseArgs(fs, args, 2, -1); err != nil {
+		return err
+	}
+	state, hill, err := openHill(opts.Backend, *passFD)
+	if err != nil {
+		return err
+	}
+	state.Wipe()
+	defer hill.Wipe()
+	account, err := selectAccount(hill, *username)
+	if err != nil {
+		return err
+	}
+	text := strings.Join(fs.Args()[1:], " ")
+	return xmpp.Send(account, fs.Arg(0), text, opts.XMPPDebug)
+}
@@ -8,31 +8,46 @@ import (
 	"io"
 )
 
+// DefaultIdleTimeout is the default idle timeout in minutes for new hills.
+const DefaultIdleTimeout = 15
+
 // Settings dfines the global settings of a Mole instance.
 type Settings struct {
-	Resource string // generated XMPP client resource (e.g., 'mole-VX9Nzrq_WV-iyI6SF7KskA')
+	Resource      string // generated XMPP client resource (e.g., 'mole-VX9Nzrq_WV-iyI6SF7KskA')
+	IdleTimeout   int    // lock UI after minutes of inactivity (0 disables auto-lock)
+	KeepConnected bool   // keep XMPP sessions connected while UI is locked
+	Hooks         []Hook `json:",omitempty"` // hooks run on events
+	MaxHooks      int    `json:",omitempty"` // max. concurrently running hooks (0: DefaultMaxHooks)
+	NoReceipts    bool   `json:",omitempty"` // do not send delivery receipts and displayed markers
 }
 
 // Account defines a XMPP account.
 type Account struct {
 	Username string // own JID
-	Password string
-	Contact  string // TODO: remove
+	Password Secret
 	// Hostname string // optional
 	// Port     int    // optional
 }
 
 // Contact defines a XMPP contact.
 type Contact struct {
-	Remote string // JID of contact
-	Local  string // own JID, corresponds to an account
+	Remote       string // JID of contact
+	Local        string // own JID, corresponds to an account
+	NoChatStates bool   `json:",omitempty"` // do not send chat states (typing notifications)
+}
+
+// Equal reports whether a and b are the same account.
+func (a *Account) Equal(b *Account) bool {
+	return a.Username == b.Username && a.Password.Equal(b.Password)
 }
 
 // A Hill contains all data of a Mole instance.
 type Hill struct {
+	Version  int // schema version
 	Settings Settings
 	Accounts []Account
 	Contacts []Contact
+	Rooms    []Room `json:",omitempty"`
 }
 
 // genResource generates a unique XMPP client resource string.
@@ -51,7 +66,9 @@ func NewHill() (*Hill, error) {
 	if err != nil {
 		return nil, err
 	}
+	h.Version = Version
 	h.Settings.Resource = resource
+	h.Settings.IdleTimeout = DefaultIdleTimeout
 	return &h, nil
 }
 
@@ -73,15 +90,6 @@ func (h *Hill) MarshalIndent() []byte {
 	return jsn
 }
 
-// Unmarshal data into a Hill.
-func Unmarshal(data []byte) (*Hill, error) {
-	var h Hill
-	if err := json.Unmarshal(data, &h); err != nil {
-		return nil, err
-	}
-	return &h, nil
-}
-
 // LastAccount returns the last added account or nil.
 func (h *Hill) LastAccount() *Account {
 	if h.Accounts == nil || len(h.Accounts) == 0 {
@@ -89,3 +97,46 @@ func (h *Hill) LastAccount() *Account {
 	}
 	return &h.Accounts[len(h.Accounts)-1]
 }
+
+// Wipe all secrets contained in h from memory. h cannot be used afterwards.
+func (h *Hill) Wipe() {
+	for _, account := range h.Accounts {
+		account.Password.Wipe()
+	}
+	for _, room := range h.Rooms {
+		room.Password.Wipe()
+	}
+	h.Accounts = nil
+	h.Contacts = nil
+	h.Rooms = nil
+}
+
+// AccountContacts returns the contacts of the account with the given
+// username.
+func (h *Hill) AccountContacts(username string) []Contact {
+	var contacts []Contact
+	for _, contact := range h.Contacts {
+		if contact.Local == username {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+// Contact returns the contact remote of the account local (nil if it does
+// not exist).
+func (h *Hill) Contact(local, remote string) *Contact {
+	for i := range h.Contacts {
+		if h.Contacts[i].Local == local && h.Contacts[i].Remote == remote {
+			return &h.Contacts[i]
+		}
+	}
+	return nil
+}
+
+// SendChatStates reports whether chat states are sent to the contact remote
+// of the account local. They are not sent to unknown contacts.
+func (h *Hill) SendChatStates(local, remote string) bool {
+	c := h.Contact(local, remote)
+	return c != nil && !c.NoChatStates
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Redacted is the placeholder for secrets in redacted dumps.
+const Redacted = "REDACTED"
+
+// Sections of a Hill which can be dumped separately.
+var Sections = []string{"settings", "accounts", "contacts", "rooms"}
+
+// Redact returns a copy of h with all secrets (including webhook URLs)
+// replaced by Redacted.
+func (h *Hill) Redact() *Hill {
+	cp := *h
+	cp.Settings.Hooks = make([]Hook, len(h.Settings.Hooks))
+	for i, hook := range h.Settings.Hooks {
+		if hook.URL != "" {
+			hook.URL = hook.RedactedURL()
+		}
+		cp.Settings.Hooks[i] = hook
+	}
+	cp.Accounts = make([]Account, len(h.Accounts))
+	for i, account := range h.Accounts {
+		account.Password = Secret(Redacted)
+		cp.Accounts[i] = account
+	}
+	cp.Contacts = append([]Contact(nil), h.Contacts...)
+	cp.Rooms = make([]Room, len(h.Rooms))
+	for i, room := range h.Rooms {
+		if len(room.Password) > 0 {
+			room.Password = Secret(Redacted)
+		}
+		cp.Rooms[i] = room
+	}
+	return &cp
+}
+
+// Dump returns h as indented JSON. If section is not empty, only the given
+// section of h (see Sections) is dumped. All secrets are redacted unless
+// unredacted is true.
+func (h *Hill) Dump(section string, unredacted bool) ([]byte, error) {
+	if !unredacted {
+		h = h.Redact()
+	}
+	var v interface{}
+	switch section {
+	case "":
+		v = h
+	case "settings":
+		v = h.Settings
+	case "accounts":
+		v = h.Accounts
+	case "contacts":
+		v = h.Contacts
+	case "rooms":
+		v = h.Rooms
+	default:
+		return nil, fmt.Errorf("config: unknown section '%s'", section)
+	}
+	return json.MarshalIndent(v, "", "    ")
+}
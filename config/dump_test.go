@@ -0,0 +1,45 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	h, err := NewHill()
+	if err != nil {
+		t.Fatalf("NewHill() failed: %v", err)
+	}
+	h.Accounts = []Account{{Username: "alice@example.com", Password: Secret("secret")}}
+	h.Contacts = []Contact{{Remote: "bob@example.com", Local: "alice@example.com"}}
+	h.Settings.Hooks = []Hook{{
+		Events: []string{HookMessage},
+		URL:    "https://example.com/hooks/secret-token?key=secret",
+	}}
+	jsn, err := h.Dump("", false)
+	if err != nil {
+		t.Fatalf("Dump() failed: %v", err)
+	}
+	if bytes.Contains(jsn, []byte("secret")) || !bytes.Contains(jsn, []byte(Redacted)) {
+		t.Errorf("Dump() did not redact secrets:\n%s", jsn)
+	}
+	if string(h.Accounts[0].Password) != "secret" || !strings.Contains(h.Settings.Hooks[0].URL, "secret") {
+		t.Error("Dump() modified hill")
+	}
+	jsn, err = h.Dump("accounts", true)
+	if err != nil {
+		t.Fatalf("Dump() failed: %v", err)
+	}
+	var accounts []Account
+	if err := json.Unmarshal(jsn, &accounts); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if len(accounts) != 1 || string(accounts[0].Password) != "secret" {
+		t.Errorf("Dump() of accounts section wrong:\n%s", jsn)
+	}
+	if _, err := h.Dump("keys", false); err == nil {
+		t.Error("Dump() of unknown section should fail")
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Hook events.
+const (
+	HookMessage      = "message"      // message received
+	HookMention      = "mention"      // message received which mentions us (account or room nickname)
+	HookSubscription = "subscription" // presence subscription request received
+	HookDisconnect   = "disconnect"   // connection to the XMPP server lost
+)
+
+// HookEvents lists all hook events.
+var HookEvents = []string{HookMessage, HookMention, HookSubscription, HookDisconnect}
+
+const (
+	// DefaultHookTimeout is the default timeout of hooks in seconds.
+	DefaultHookTimeout = 10
+	// DefaultMaxHooks is the default maximum number of concurrently running
+	// hooks.
+	DefaultMaxHooks = 4
+)
+
+// Hook is run on selected events. It either runs an external command with
+// the event as JSON on stdin or posts the event as JSON to a webhook URL.
+type Hook struct {
+	Events  []string // events the hook is run for (see HookEvents)
+	Command []string `json:",omitempty"` // command and its arguments
+	URL     string   `json:",omitempty"` // webhook URL (http or https)
+	Timeout int      `json:",omitempty"` // in seconds (0: DefaultHookTimeout)
+	Content bool     `json:",omitempty"` // pass decrypted message texts to hook
+}
+
+// RedactedURL returns the webhook URL of h with everything but its scheme
+// and host replaced by Redacted, because webhook URLs often contain tokens
+// (as user info, in the path, or in the query).
+func (h *Hook) RedactedURL() string {
+	u, err := url.Parse(h.URL)
+	if err != nil || u.Host == "" {
+		return Redacted
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" ||
+		u.Fragment != "" {
+		redacted += "/" + Redacted
+	}
+	return redacted
+}
+
+// Validate checks that hook h is well-formed.
+func (h *Hook) Validate() error {
+	if len(h.Events) == 0 {
+		return errors.New("no events")
+	}
+	for _, event := range h.Events {
+		if !containsString(HookEvents, event) {
+			return fmt.Errorf("unknown event '%s'", event)
+		}
+	}
+	switch {
+	case len(h.Command) > 0 && h.URL != "":
+		return errors.New("both command and URL defined")
+	case len(h.Command) > 0:
+		if h.Command[0] == "" {
+			return errors.New("empty command")
+		}
+	case h.URL != "":
+		u, err := url.Parse(h.URL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("URL scheme '%s' not supported", u.Scheme)
+		}
+	default:
+		return errors.New("neither command nor URL defined")
+	}
+	if h.Timeout < 0 {
+		return errors.New("negative timeout")
+	}
+	return nil
+}
+
+// Runs reports whether hook h is run for event.
+func (h *Hook) Runs(event string) bool {
+	return containsString(h.Events, event)
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AddHook adds hook to the settings of h.
+func (h *Hill) AddHook(hook Hook) error {
+	if err := hook.Validate(); err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	h.Settings.Hooks = append(h.Settings.Hooks, hook)
+	return nil
+}
+
+// RemoveHook removes the hook with index i from the settings of h.
+func (h *Hill) RemoveHook(i int) error {
+	if i < 0 || i >= len(h.Settings.Hooks) {
+		return fmt.Errorf("config: hook %d does not exist", i)
+	}
+	h.Settings.Hooks = append(h.Settings.Hooks[:i], h.Settings.Hooks[i+1:]...)
+	return nil
+}
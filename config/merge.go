@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+)
+
+// A Conflict describes data which could not be merged into a Hill.
+type Conflict struct {
+	Account string // JID of conflicting account
+	Reason  string // why the data could not be merged
+}
+
+// String returns a human readable description of the conflict.
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s", c.Account, c.Reason)
+}
+
+// Account returns the account with the given username or nil.
+func (h *Hill) Account(username string) *Account {
+	for i := range h.Accounts {
+		if h.Accounts[i].Username == username {
+			return &h.Accounts[i]
+		}
+	}
+	return nil
+}
+
+// hasContact reports whether h contains contact already (regardless of its
+// settings).
+func (h *Hill) hasContact(contact Contact) bool {
+	return h.Contact(contact.Local, contact.Remote) != nil
+}
+
+// Select returns a copy of h which only contains the accounts with the given
+// usernames (and their contacts and rooms). If usernames is empty all
+// accounts are selected.
+func (h *Hill) Select(usernames []string) (*Hill, error) {
+	if len(usernames) == 0 {
+		cp := *h
+		cp.Accounts = append([]Account(nil), h.Accounts...)
+		cp.Contacts = append([]Contact(nil), h.Contacts...)
+		cp.Rooms = append([]Room(nil), h.Rooms...)
+		return &cp, nil
+	}
+	sel := &Hill{Settings: h.Settings}
+	for _, username := range usernames {
+		account := h.Account(username)
+		if account == nil {
+			return nil, fmt.Errorf("config: unknown account '%s'", username)
+		}
+		sel.Accounts = append(sel.Accounts, *account)
+	}
+	for _, contact := range h.Contacts {
+		if sel.Account(contact.Local) != nil {
+			sel.Contacts = append(sel.Contacts, contact)
+		}
+	}
+	for _, room := range h.Rooms {
+		if sel.Account(room.Local) != nil {
+			sel.Rooms = append(sel.Rooms, room)
+		}
+	}
+	return sel, nil
+}
+
+// Merge the accounts, contacts, and rooms of other into h. Accounts which exist in h
+// already with different data are not merged and reported as conflicts, the
+// settings of h are kept.
+func (h *Hill) Merge(other *Hill) []Conflict {
+	var conflicts []Conflict
+	skip := make(map[string]bool)
+	for _, account := range other.Accounts {
+		existing := h.Account(account.Username)
+		if existing == nil {
+			account.Password = append(Secret(nil), account.Password...)
+			h.Accounts = append(h.Accounts, account)
+			continue
+		}
+		if !existing.Equal(&account) {
+			conflicts = append(conflicts, Conflict{
+				Account: account.Username,
+				Reason:  "account exists already with different settings, kept existing",
+			})
+			skip[account.Username] = true
+		}
+	}
+	for _, contact := range other.Contacts {
+		if skip[contact.Local] || h.hasContact(contact) {
+			continue
+		}
+		h.Contacts = append(h.Contacts, contact)
+	}
+	for _, room := range other.Rooms {
+		if skip[room.Local] || h.Room(room.Local, room.Room) != nil {
+			continue
+		}
+		room.Password = append(Secret(nil), room.Password...)
+		h.Rooms = append(h.Rooms, room)
+	}
+	return conflicts
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Version of the hill schema written by this version of Mole.
+const Version = 2
+
+// A migration migrates the generic JSON representation of a hill from one
+// schema version to the next.
+type migration func(h map[string]interface{}) error
+
+// migrations[v] migrates a hill from schema version v to v+1.
+// Hills without a version field have schema version 0.
+var migrations = []migration{
+	migrateV0, // 0 -> 1
+	migrateV1, // 1 -> 2
+}
+
+// migrateV0 migrates a hill from schema version 0 to 1.
+func migrateV0(h map[string]interface{}) error {
+	if err := migrateAccountContact(h); err != nil {
+		return err
+	}
+	return migrateIdleTimeout(h)
+}
+
+// migrateV1 migrates a hill from schema version 1 to 2. Version 2 adds rooms,
+// hooks, and the receipt and chat state settings, which are all optional.
+// The version is bumped anyway, so that older versions of Mole refuse to load
+// (and save without them) hills which use them.
+func migrateV1(h map[string]interface{}) error {
+	return nil
+}
+
+// migrateIdleTimeout sets the idle timeout to DefaultIdleTimeout, if it is
+// not set. An idle timeout of 0 set explicitly still disables auto-lock.
+func migrateIdleTimeout(h map[string]interface{}) error {
+	settings, ok := h["Settings"].(map[string]interface{})
+	if !ok {
+		if h["Settings"] != nil {
+			return fmt.Errorf("config: settings are not an object")
+		}
+		settings = make(map[string]interface{})
+		h["Settings"] = settings
+	}
+	if _, ok := settings["IdleTimeout"]; !ok {
+		settings["IdleTimeout"] = DefaultIdleTimeout
+	}
+	return nil
+}
+
+// migrateAccountContact moves the contact of each account (Account.Contact)
+// to the list of contacts.
+func migrateAccountContact(h map[string]interface{}) error {
+	accounts, _ := h["Accounts"].([]interface{})
+	contacts, _ := h["Contacts"].([]interface{})
+	for _, a := range accounts {
+		account, ok := a.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("config: account is not an object")
+		}
+		contact, _ := account["Contact"].(string)
+		delete(account, "Contact")
+		if contact == "" {
+			continue
+		}
+		exists := false
+		for _, c := range contacts {
+			c, ok := c.(map[string]interface{})
+			if ok && c["Remote"] == contact && c["Local"] == account["Username"] {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			contacts = append(contacts, map[string]interface{}{
+				"Remote": contact,
+				"Local":  account["Username"],
+			})
+		}
+	}
+	h["Contacts"] = contacts
+	return nil
+}
+
+// migrate the generic JSON representation of a hill to the current schema
+// version.
+func migrate(h map[string]interface{}) error {
+	var version int
+	if v, ok := h["Version"]; ok {
+		f, ok := v.(float64)
+		if !ok || f < 0 || f != float64(int(f)) {
+			return fmt.Errorf("config: invalid hill version: %v", v)
+		}
+		version = int(f)
+	}
+	if version > Version {
+		return fmt.Errorf("config: hill version %d is newer than supported version %d",
+			version, Version)
+	}
+	for ; version < Version; version++ {
+		if err := migrations[version](h); err != nil {
+			return err
+		}
+	}
+	h["Version"] = Version
+	return nil
+}
+
+// unknownFields returns the paths of all fields in the generic JSON value v
+// which do not correspond to a field of type t. Field names are matched
+// case-insensitively, like encoding/json does.
+func unknownFields(v interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for key, value := range obj {
+			field, ok := t.FieldByNameFunc(func(name string) bool {
+				return strings.EqualFold(name, key)
+			})
+			if !ok {
+				unknown = append(unknown, path+key)
+				continue
+			}
+			unknown = append(unknown,
+				unknownFields(value, field.Type, path+key+".")...)
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, elem := range arr {
+			unknown = append(unknown, unknownFields(elem, t.Elem(),
+				fmt.Sprintf("%s%d.", path, i))...)
+		}
+	}
+	return unknown
+}
+
+// Unmarshal data into a Hill. Hills with older schema versions are migrated
+// to the current version. Fields which are not known to the current schema
+// are ignored and returned as warnings, as are validation errors.
+func Unmarshal(data []byte) (*Hill, []string, error) {
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, nil, err
+	}
+	if err := migrate(generic); err != nil {
+		return nil, nil, err
+	}
+	var warnings []string
+	unknown := unknownFields(generic, reflect.TypeOf(Hill{}), "")
+	sort.Strings(unknown)
+	for _, field := range unknown {
+		warnings = append(warnings,
+			fmt.Sprintf("config: ignoring unknown field '%s'", field))
+	}
+	migrated, err := json.Marshal(generic)
+	if err != nil {
+		return nil, nil, err
+	}
+	var h Hill
+	if err := json.Unmarshal(migrated, &h); err != nil {
+		return nil, nil, err
+	}
+	for _, err := range h.Validate() {
+		warnings = append(warnings, err.Error())
+	}
+	return &h, warnings, nil
+}
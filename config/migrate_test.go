@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestUnmarshalGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "hill-v*.json"))
+	if err != nil {
+		t.Fatalf("Glob() failed: %v", err)
+	}
+	if len(fixtures) != Version+1 {
+		t.Errorf("expected a fixture for each of the %d schema versions, found %d",
+			Version+1, len(fixtures))
+	}
+	for _, fixture := range fixtures {
+		data, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatalf("ReadFile() failed: %v", err)
+		}
+		h, warnings, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal() failed: %v", fixture, err)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("%s: Unmarshal() returned warnings: %v", fixture, warnings)
+		}
+		if h.Version != Version {
+			t.Errorf("%s: not migrated to version %d", fixture, Version)
+		}
+		out := append(h.MarshalIndent(), '\n')
+		golden := fixture[:len(fixture)-len(".json")] + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, out, 0644); err != nil {
+				t.Fatalf("WriteFile() failed: %v", err)
+			}
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("ReadFile() failed: %v", err)
+		}
+		if !bytes.Equal(out, expected) {
+			t.Errorf("%s: migrated hill differs from %s:\n%s", fixture, golden, out)
+		}
+	}
+}
+
+func TestUnmarshalUnknownFields(t *testing.T) {
+	data := []byte(`{"Version":1,"Settings":{"Resource":"r","Theme":"dark"},"Accounts":[{"Username":"a@example.com","Password":"p","Hostname":"h"}],"Extra":true}`)
+	h, warnings, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	expected := []string{
+		"config: ignoring unknown field 'Accounts.0.Hostname'",
+		"config: ignoring unknown field 'Extra'",
+		"config: ignoring unknown field 'Settings.Theme'",
+	}
+	if len(warnings) != len(expected) {
+		t.Fatalf("Unmarshal() returned warnings %v, expected %v", warnings, expected)
+	}
+	for i := range expected {
+		if warnings[i] != expected[i] {
+			t.Errorf("warning %d is %q, expected %q", i, warnings[i], expected[i])
+		}
+	}
+	if h.Account("a@example.com") == nil {
+		t.Error("known fields not unmarshalled")
+	}
+}
+
+func TestUnmarshalVersionErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"Version":3}`,
+		`{"Version":-1}`,
+		`{"Version":"1"}`,
+		`{"Accounts":["alice@example.com"]}`,
+	} {
+		if _, _, err := Unmarshal([]byte(data)); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", data)
+		}
+	}
+}
+
+func TestMigrateIdleTimeout(t *testing.T) {
+	for data, expected := range map[string]int{
+		`{"Settings":{"Resource":"r"}}`:                 DefaultIdleTimeout,
+		`{"Settings":{"Resource":"r","IdleTimeout":0}}`: 0,
+		`{"Settings":{"Resource":"r","IdleTimeout":5}}`: 5,
+	} {
+		h, _, err := Unmarshal([]byte(data))
+		if err != nil {
+			t.Fatalf("Unmarshal(%s) failed: %v", data, err)
+		}
+		if h.Settings.IdleTimeout != expected {
+			t.Errorf("Unmarshal(%s): idle timeout %d, expected %d", data,
+				h.Settings.IdleTimeout, expected)
+		}
+	}
+}
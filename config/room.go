@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/frankbraun/mole/jid"
+)
+
+// DefaultRoomHistory is the default number of history messages requested
+// when joining a room.
+const DefaultRoomHistory = 20
+
+// Room defines a multi-user chat room (XEP-0045).
+type Room struct {
+	Room       string // bare JID of room
+	Local      string // own JID, corresponds to an account
+	Name       string `json:",omitempty"` // name of bookmark (optional)
+	Nick       string // own nickname in room
+	Password   Secret `json:",omitempty"` // room password (optional)
+	History    int    `json:",omitempty"` // number of history messages requested on join
+	AutoJoin   bool   `json:",omitempty"` // join room after connecting
+	Bookmarked bool   `json:",omitempty"` // room has been synchronized with bookmarks
+}
+
+// Room returns the room with the given JID of account local or nil.
+func (h *Hill) Room(local, room string) *Room {
+	for i := range h.Rooms {
+		if h.Rooms[i].Local == local && h.Rooms[i].Room == room {
+			return &h.Rooms[i]
+		}
+	}
+	return nil
+}
+
+// AccountRooms returns the rooms of the account with the given username.
+func (h *Hill) AccountRooms(username string) []Room {
+	var rooms []Room
+	for _, room := range h.Rooms {
+		if room.Local == username {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+// AddRoom adds room to h. The JIDs are normalized, the account has to exist
+// and the room must not exist already for it.
+func (h *Hill) AddRoom(room Room) error {
+	r, err := parseBareJID(room.Room)
+	if err != nil {
+		return err
+	}
+	local, err := parseBareJID(room.Local)
+	if err != nil {
+		return err
+	}
+	if h.Account(local) == nil {
+		return fmt.Errorf("config: account '%s' does not exist", local)
+	}
+	if err := validateRoom(&room); err != nil {
+		return err
+	}
+	if h.Room(local, r) != nil {
+		return fmt.Errorf("config: room '%s' exists already for account '%s'",
+			r, local)
+	}
+	room.Room = r
+	room.Local = local
+	h.Rooms = append(h.Rooms, room)
+	return nil
+}
+
+// validateRoom checks the settings of room which are not JIDs.
+func validateRoom(room *Room) error {
+	if room.Nick == "" {
+		return fmt.Errorf("config: room '%s' has no nickname", room.Room)
+	}
+	if room.History < 0 {
+		return errors.New("config: negative room history")
+	}
+	return nil
+}
+
+// RemoveRoom removes the room with the given JID of account local from h.
+func (h *Hill) RemoveRoom(local, room string) error {
+	r, err := jid.Normalize(room)
+	if err != nil {
+		return err
+	}
+	local, err = jid.Normalize(local)
+	if err != nil {
+		return err
+	}
+	existing := h.Room(local, r)
+	if existing == nil {
+		return fmt.Errorf("config: room '%s' does not exist for account '%s'",
+			r, local)
+	}
+	existing.Password.Wipe()
+	var rooms []Room
+	for _, rm := range h.Rooms {
+		if rm.Local != local || rm.Room != r {
+			rooms = append(rooms, rm)
+		}
+	}
+	h.Rooms = rooms
+	return nil
+}
+
+// SyncRooms synchronizes the rooms of account local with its bookmarks.
+// Bookmarked rooms which do not exist in h are added and returned in added,
+// existing rooms are updated with the settings of their bookmarks. Rooms
+// which have been synchronized before but are no longer bookmarked have been
+// removed elsewhere and are removed from h. Rooms which have never been
+// synchronized are returned in publish, they should be bookmarked and marked
+// as Bookmarked afterwards. changed reports whether h has been changed.
+func (h *Hill) SyncRooms(local string, bookmarks []Room) (added, publish []Room, changed bool) {
+	bookmarked := make(map[string]*Room)
+	for i := range bookmarks {
+		bookmarked[bookmarks[i].Room] = &bookmarks[i]
+	}
+	var rooms []Room
+	for _, r := range h.Rooms {
+		if r.Local != local {
+			rooms = append(rooms, r)
+			continue
+		}
+		b := bookmarked[r.Room]
+		switch {
+		case b != nil:
+			delete(bookmarked, r.Room)
+			updated := r
+			updated.Name = b.Name
+			updated.AutoJoin = b.AutoJoin
+			updated.Bookmarked = true
+			if b.Nick != "" {
+				updated.Nick = b.Nick
+			}
+			if len(b.Password) > 0 && !b.Password.Equal(r.Password) {
+				updated.Password = append(Secret(nil), b.Password...)
+				r.Password.Wipe()
+				changed = true
+			}
+			if updated.Name != r.Name || updated.AutoJoin != r.AutoJoin ||
+				updated.Nick != r.Nick || !r.Bookmarked {
+				changed = true
+			}
+			rooms = append(rooms, updated)
+		case r.Bookmarked:
+			r.Password.Wipe()
+			changed = true
+		default:
+			publish = append(publish, r)
+			rooms = append(rooms, r)
+		}
+	}
+	for _, b := range bookmarks {
+		if bookmarked[b.Room] == nil {
+			continue // exists already
+		}
+		r := b
+		r.Local = local
+		r.Password = append(Secret(nil), b.Password...)
+		r.Bookmarked = true
+		if r.Nick == "" {
+			r.Nick = localpart(local)
+		}
+		rooms = append(rooms, r)
+		added = append(added, r)
+		delete(bookmarked, b.Room)
+		changed = true
+	}
+	h.Rooms = rooms
+	return added, publish, changed
+}
+
+// localpart returns the localpart of the JID j.
+func localpart(j string) string {
+	if parsed, err := jid.Parse(j); err == nil {
+		return parsed.Local
+	}
+	return j
+}
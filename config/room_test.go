@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestSyncRooms(t *testing.T) {
+	h := &Hill{
+		Rooms: []Room{
+			{Room: "ops@conference.example.com", Local: "alice@example.com", Nick: "al", AutoJoin: true, Bookmarked: true},
+			{Room: "old@conference.example.com", Local: "alice@example.com", Nick: "al", Bookmarked: true},
+			{Room: "new@conference.example.com", Local: "alice@example.com", Nick: "al"},
+			{Room: "ops@conference.example.com", Local: "carol@example.org", Nick: "carol"},
+		},
+	}
+	added, publish, changed := h.SyncRooms("alice@example.com", []Room{
+		{Room: "ops@conference.example.com", Name: "Ops", AutoJoin: true},
+		{Room: "dev@conference.example.com", AutoJoin: true, Password: Secret("pw")},
+	})
+	if !changed {
+		t.Error("SyncRooms() did not report change")
+	}
+	if len(added) != 1 || added[0].Room != "dev@conference.example.com" ||
+		added[0].Nick != "alice" || !added[0].Bookmarked {
+		t.Errorf("unexpected added rooms: %+v", added)
+	}
+	if len(publish) != 1 || publish[0].Room != "new@conference.example.com" {
+		t.Errorf("unexpected rooms to publish: %+v", publish)
+	}
+	if h.Room("alice@example.com", "old@conference.example.com") != nil {
+		t.Error("room removed elsewhere not removed")
+	}
+	if r := h.Room("alice@example.com", "ops@conference.example.com"); r.Name != "Ops" || r.Nick != "al" {
+		t.Errorf("room not updated: %+v", r)
+	}
+	if h.Room("carol@example.org", "ops@conference.example.com") == nil {
+		t.Error("room of other account removed")
+	}
+	// mark published and sync again without changes
+	h.Room("alice@example.com", "new@conference.example.com").Bookmarked = true
+	_, _, changed = h.SyncRooms("alice@example.com", []Room{
+		{Room: "ops@conference.example.com", Name: "Ops", AutoJoin: true},
+		{Room: "dev@conference.example.com", AutoJoin: true, Password: Secret("pw")},
+		{Room: "new@conference.example.com"},
+	})
+	if changed {
+		t.Error("SyncRooms() reported change for synchronized rooms")
+	}
+}
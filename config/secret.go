@@ -0,0 +1,37 @@
+package config
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+
+	"github.com/frankbraun/mole/util"
+)
+
+// Secret is a byte buffer holding sensitive data (like a password) which can
+// be wiped from memory after use. It is marshalled as a JSON string.
+type Secret []byte
+
+// MarshalJSON marshals s as a JSON string.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(s))
+}
+
+// UnmarshalJSON unmarshals a JSON string into s.
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*s = Secret(str)
+	return nil
+}
+
+// Equal reports whether s and t contain the same secret.
+func (s Secret) Equal(t Secret) bool {
+	return subtle.ConstantTimeCompare(s, t) == 1
+}
+
+// Wipe overwrites s with zeros.
+func (s Secret) Wipe() {
+	util.Wipe(s)
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/frankbraun/mole/jid"
+)
+
+// parseBareJID parses s as a bare JID with localpart and returns its
+// normalized form.
+func parseBareJID(s string) (string, error) {
+	j, err := jid.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if !j.IsBare() {
+		return "", fmt.Errorf("config: '%s' is not a bare JID", s)
+	}
+	if j.Local == "" {
+		return "", fmt.Errorf("config: '%s' has no localpart", s)
+	}
+	return j.String(), nil
+}
+
+// AddAccount normalizes the username of account and adds it to h. It returns
+// an error if the username is not a valid bare JID or the account exists
+// already.
+func (h *Hill) AddAccount(account Account) error {
+	username, err := parseBareJID(account.Username)
+	if err != nil {
+		return err
+	}
+	if h.Account(username) != nil {
+		return fmt.Errorf("config: account '%s' exists already", username)
+	}
+	if len(account.Password) == 0 {
+		return errors.New("config: password is empty")
+	}
+	account.Username = username
+	h.Accounts = append(h.Accounts, account)
+	return nil
+}
+
+// AddContact normalizes the JIDs of contact and adds it to h. It returns an
+// error if the JIDs are invalid, the local account does not exist, or the
+// contact exists already.
+func (h *Hill) AddContact(contact Contact) error {
+	remote, err := parseBareJID(contact.Remote)
+	if err != nil {
+		return err
+	}
+	local, err := parseBareJID(contact.Local)
+	if err != nil {
+		return err
+	}
+	if h.Account(local) == nil {
+		return fmt.Errorf("config: account '%s' does not exist", local)
+	}
+	contact.Remote = remote
+	contact.Local = local
+	if h.hasContact(contact) {
+		return fmt.Errorf("config: contact '%s' exists already for account '%s'",
+			remote, local)
+	}
+	h.Contacts = append(h.Contacts, contact)
+	return nil
+}
+
+// Validate checks that all accounts, contacts, and rooms in h have
+// normalized JIDs and that there are no duplicates. It returns a list of all
+// problems found.
+func (h *Hill) Validate() []error {
+	var errs []error
+	accounts := make(map[string]bool)
+	for _, account := range h.Accounts {
+		username, err := parseBareJID(account.Username)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if username != account.Username {
+			errs = append(errs, fmt.Errorf("config: account '%s' is not normalized",
+				account.Username))
+		}
+		if accounts[username] {
+			errs = append(errs, fmt.Errorf("config: duplicate account '%s'", username))
+		}
+		accounts[username] = true
+	}
+	contacts := make(map[Contact]bool)
+	for _, contact := range h.Contacts {
+		remote, err := parseBareJID(contact.Remote)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if remote != contact.Remote {
+			errs = append(errs, fmt.Errorf("config: contact '%s' is not normalized",
+				contact.Remote))
+		}
+		if !accounts[contact.Local] {
+			errs = append(errs, fmt.Errorf("config: contact '%s' refers to unknown account '%s'",
+				contact.Remote, contact.Local))
+		}
+		key := Contact{Remote: contact.Remote, Local: contact.Local}
+		if contacts[key] {
+			errs = append(errs, fmt.Errorf("config: duplicate contact '%s' for account '%s'",
+				contact.Remote, contact.Local))
+		}
+		contacts[key] = true
+	}
+	rooms := make(map[[2]string]bool) // room, local
+	for _, room := range h.Rooms {
+		r, err := parseBareJID(room.Room)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if r != room.Room {
+			errs = append(errs, fmt.Errorf("config: room '%s' is not normalized",
+				room.Room))
+		}
+		if !accounts[room.Local] {
+			errs = append(errs, fmt.Errorf("config: room '%s' refers to unknown account '%s'",
+				room.Room, room.Local))
+		}
+		if err := validateRoom(&room); err != nil {
+			errs = append(errs, err)
+		}
+		key := [2]string{room.Room, room.Local}
+		if rooms[key] {
+			errs = append(errs, fmt.Errorf("config: duplicate room '%s' for account '%s'",
+				room.Room, room.Local))
+		}
+		rooms[key] = true
+	}
+	for i := range h.Settings.Hooks {
+		if err := h.Settings.Hooks[i].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("config: hook %d: %v", i, err))
+		}
+	}
+	if h.Settings.MaxHooks < 0 {
+		errs = append(errs, errors.New("config: negative MaxHooks"))
+	}
+	return errs
+}
+
+// RemoveAccount removes the account with the given username and all its
+// contacts and rooms from h.
+func (h *Hill) RemoveAccount(username string) error {
+	username, err := jid.Normalize(username)
+	if err != nil {
+		return err
+	}
+	account := h.Account(username)
+	if account == nil {
+		return fmt.Errorf("config: account '%s' does not exist", username)
+	}
+	account.Password.Wipe()
+	var accounts []Account
+	for _, a := range h.Accounts {
+		if a.Username != username {
+			accounts = append(accounts, a)
+		}
+	}
+	h.Accounts = accounts
+	var contacts []Contact
+	for _, c := range h.Contacts {
+		if c.Local != username {
+			contacts = append(contacts, c)
+		}
+	}
+	h.Contacts = contacts
+	var rooms []Room
+	for _, r := range h.Rooms {
+		if r.Local != username {
+			rooms = append(rooms, r)
+		} else {
+			r.Password.Wipe()
+		}
+	}
+	h.Rooms = rooms
+	return nil
+}
+
+// RemoveContact removes contact from h.
+func (h *Hill) RemoveContact(contact Contact) error {
+	remote, err := jid.Normalize(contact.Remote)
+	if err != nil {
+		return err
+	}
+	local, err := jid.Normalize(contact.Local)
+	if err != nil {
+		return err
+	}
+	contact = Contact{Remote: remote, Local: local}
+	if !h.hasContact(contact) {
+		return fmt.Errorf("config: contact '%s' does not exist for account '%s'",
+			remote, local)
+	}
+	var contacts []Contact
+	for _, c := range h.Contacts {
+		if c.Remote != remote || c.Local != local {
+			contacts = append(contacts, c)
+		}
+	}
+	h.Contacts = contacts
+	return nil
+}
@@ -0,0 +1,653 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+const (
+	defaultEventsTimeout = 30 // seconds
+	maxEventsTimeout     = 300
+)
+
+// Event is an XMPP event with a sequence number.
+type Event struct {
+	Seq uint64 `json:"seq"`
+	xmpp.Event
+}
+
+// API is the JSON-RPC API of the daemon. Its methods are called as
+// "Mole.<Method>" with a single argument object.
+type API struct {
+	s *Server
+}
+
+// connection returns the connection of account (the last account if empty).
+// s.mutex must be held.
+func (s *Server) connection(account string) (string, *connection, error) {
+	if account == "" {
+		a := s.hill.LastAccount()
+		if a == nil {
+			return "", nil, errors.New("daemon: no account defined")
+		}
+		account = a.Username
+	} else {
+		var err error
+		account, err = jid.Normalize(account)
+		if err != nil {
+			return "", nil, err
+		}
+		if s.hill.Account(account) == nil {
+			return "", nil, fmt.Errorf("daemon: account '%s' does not exist", account)
+		}
+	}
+	c := s.connections[account]
+	if c == nil {
+		return "", nil, fmt.Errorf("daemon: account '%s' is not connected", account)
+	}
+	return account, c, nil
+}
+
+// HillArgs are the arguments of API.Hill.
+type HillArgs struct{}
+
+// HillReply is the reply of API.Hill.
+type HillReply struct {
+	Hill *config.Hill `json:"hill"` // secrets are redacted
+}
+
+// Hill returns the hill of the daemon with all secrets redacted.
+func (a *API) Hill(args *HillArgs, reply *HillReply) error {
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	if a.s.closed {
+		return ErrClosed
+	}
+	reply.Hill = a.s.hill.Redact()
+	return nil
+}
+
+// SendArgs are the arguments of API.Send.
+type SendArgs struct {
+	Account   string `json:"account"` // default: last account
+	To        string `json:"to"`      // JID of contact or room
+	Type      string `json:"type"`    // "chat" (default) or "groupchat" (for rooms)
+	Text      string `json:"text"`
+	ID        string `json:"id"`        // message ID (generated if empty), or ID of marked message
+	Marker    string `json:"marker"`    // send "delivered" or "displayed" marker for ID instead of text
+	ChatState string `json:"chatState"` // chat state sent with text, or alone (if text is empty)
+	Replace   string `json:"replace"`   // ID of the message corrected by text (if any)
+	ReplyTo   string `json:"replyTo"`   // JID of the author of the message replied to
+	ReplyID   string `json:"replyId"`   // ID of the message replied to (if any)
+	Quote     string `json:"quote"`     // text of the message replied to
+}
+
+// SendReply is the reply of API.Send.
+type SendReply struct {
+	Message xmpp.Message `json:"message"` // the message as sent
+}
+
+// Send a chat message, a chat marker, or a chat state notification.
+func (a *API) Send(args *SendArgs, reply *SendReply) error {
+	to, err := jid.Parse(args.To)
+	if err != nil {
+		return err
+	}
+	if args.Type != "" && args.Type != xmpp.Chat && args.Type != xmpp.Groupchat {
+		return fmt.Errorf("daemon: unknown message type '%s'", args.Type)
+	}
+	if args.Marker != "" {
+		return a.sendMarker(args, to)
+	}
+	if args.ChatState != "" && !validChatState(args.ChatState) {
+		return fmt.Errorf("daemon: unknown chat state '%s'", args.ChatState)
+	}
+	if args.Text == "" {
+		if args.ChatState != "" {
+			return a.sendChatState(args, to)
+		}
+		return errors.New("daemon: message text is empty")
+	}
+	msg := xmpp.Message{
+		Type:      args.Type,
+		To:        to.Bare().String(),
+		Text:      args.Text,
+		Time:      time.Now(),
+		ID:        args.ID,
+		ChatState: args.ChatState,
+		Replace:   args.Replace,
+		ReplyTo:   args.ReplyTo,
+		ReplyID:   args.ReplyID,
+		Quote:     args.Quote,
+	}
+	if msg.ID == "" {
+		if msg.ID, err = xmpp.NewID(); err != nil {
+			return err
+		}
+	}
+	if msg.Type != xmpp.Groupchat {
+		msg.State = xmpp.Sent
+	}
+	a.s.mutex.Lock()
+	account, c, err := a.s.connection(args.Account)
+	a.s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if msg.Type == xmpp.Groupchat {
+		if err := encryptable(c.session, msg.To); err != nil {
+			return err
+		}
+	}
+	msg.From = account
+	if err := c.post(msg); err != nil {
+		return err
+	}
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	a.s.addMessage(account, msg.To, &msg)
+	a.s.addEvent(xmpp.Event{Kind: xmpp.MessageEvent, Account: account, Message: &msg})
+	reply.Message = msg
+	return nil
+}
+
+// encryptable checks that messages to room could be encrypted, which is
+// only possible in members-only, non-anonymous rooms.
+func encryptable(sess session, room string) error {
+	info, err := sess.RoomInfo(room)
+	if err != nil {
+		return err
+	}
+	if !info.Encryptable() {
+		return fmt.Errorf("daemon: messages to room '%s' cannot be encrypted, "+
+			"it is not members-only and non-anonymous", room)
+	}
+	return nil
+}
+
+// sendMarker sends the chat marker of args to to.
+func (a *API) sendMarker(args *SendArgs, to jid.JID) error {
+	if args.Marker != xmpp.Delivered && args.Marker != xmpp.Displayed {
+		return fmt.Errorf("daemon: unknown marker '%s'", args.Marker)
+	}
+	if args.ID == "" {
+		return errors.New("daemon: marker without message ID")
+	}
+	a.s.mutex.Lock()
+	_, c, err := a.s.connection(args.Account)
+	a.s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.post(xmpp.Message{To: to.Bare().String(), ID: args.ID, Marker: args.Marker})
+}
+
+// validChatState reports whether state is a chat state.
+func validChatState(state string) bool {
+	for _, s := range xmpp.ChatStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// sendChatState sends the standalone chat state notification of args to to.
+func (a *API) sendChatState(args *SendArgs, to jid.JID) error {
+	if args.Type == xmpp.Groupchat {
+		return errors.New("daemon: chat states are not sent to rooms")
+	}
+	a.s.mutex.Lock()
+	_, c, err := a.s.connection(args.Account)
+	a.s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.post(xmpp.Message{To: to.Bare().String(), ChatState: args.ChatState})
+}
+
+// RetractArgs are the arguments of API.Retract.
+type RetractArgs struct {
+	Account string `json:"account"` // default: last account
+	To      string `json:"to"`      // JID of contact or room
+	Type    string `json:"type"`    // "chat" (default) or "groupchat" (for rooms)
+	ID      string `json:"id"`      // ID of our retracted message
+}
+
+// RetractReply is the reply of API.Retract.
+type RetractReply struct{}
+
+// Retract asks the recipients to remove our message and removes its text
+// from the conversation.
+func (a *API) Retract(args *RetractArgs, reply *RetractReply) error {
+	to, err := jid.Parse(args.To)
+	if err != nil {
+		return err
+	}
+	if args.ID == "" {
+		return errors.New("daemon: message ID missing")
+	}
+	a.s.mutex.Lock()
+	account, c, err := a.s.connection(args.Account)
+	a.s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	remote := to.Bare().String()
+	if err := c.session.Retract(remote, args.ID, args.Type == xmpp.Groupchat); err != nil {
+		return err
+	}
+	r := &xmpp.Retraction{From: remote, ID: args.ID, Own: true}
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	a.s.retract(account, r)
+	a.s.addEvent(xmpp.Event{Kind: xmpp.RetractEvent, Account: account, Retraction: r})
+	return nil
+}
+
+// ReactArgs are the arguments of API.React.
+type ReactArgs struct {
+	Account   string   `json:"account"`   // default: last account
+	To        string   `json:"to"`        // JID of contact or room
+	Type      string   `json:"type"`      // "chat" (default) or "groupchat" (for rooms)
+	ID        string   `json:"id"`        // ID of the message reacted to
+	Reactions []string `json:"reactions"` // all our reactions (empty to remove them)
+}
+
+// ReactReply is the reply of API.React.
+type ReactReply struct{}
+
+// React sets our reactions to a message, which replace all previous ones.
+func (a *API) React(args *ReactArgs, reply *ReactReply) error {
+	to, err := jid.Parse(args.To)
+	if err != nil {
+		return err
+	}
+	if args.ID == "" {
+		return errors.New("daemon: message ID missing")
+	}
+	a.s.mutex.Lock()
+	account, c, err := a.s.connection(args.Account)
+	a.s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	remote := to.Bare().String()
+	err = c.session.React(remote, args.ID, args.Type == xmpp.Groupchat, args.Reactions)
+	if err != nil {
+		return err
+	}
+	r := &xmpp.Reactions{From: remote, ID: args.ID, Own: true, Reactions: args.Reactions}
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	a.s.addEvent(xmpp.Event{Kind: xmpp.ReactionEvent, Account: account, Reactions: r})
+	return nil
+}
+
+// ConversationsArgs are the arguments of API.Conversations.
+type ConversationsArgs struct {
+	Account string `json:"account"` // only list conversations of account (if not empty)
+	History bool   `json:"history"` // include the messages of conversations
+}
+
+// Conversation is a conversation of an account with a remote JID.
+type Conversation struct {
+	Account  string         `json:"account"`
+	Remote   string         `json:"remote"`
+	Count    int            `json:"count"` // number of messages kept
+	Last     xmpp.Message   `json:"last"`
+	Messages []xmpp.Message `json:"messages,omitempty"`
+}
+
+// ConversationsReply is the reply of API.Conversations.
+type ConversationsReply struct {
+	Conversations []Conversation `json:"conversations"`
+}
+
+// Conversations lists the conversations since the daemon has been started,
+// most recent first.
+func (a *API) Conversations(args *ConversationsArgs, reply *ConversationsReply) error {
+	account := args.Account
+	if account != "" {
+		var err error
+		account, err = jid.Normalize(account)
+		if err != nil {
+			return err
+		}
+	}
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	reply.Conversations = []Conversation{}
+	for key, msgs := range a.s.conversations {
+		if account != "" && key.account != account {
+			continue
+		}
+		c := Conversation{
+			Account: key.account,
+			Remote:  key.remote,
+			Count:   len(msgs),
+			Last:    msgs[len(msgs)-1],
+		}
+		if args.History {
+			c.Messages = append([]xmpp.Message(nil), msgs...)
+		}
+		reply.Conversations = append(reply.Conversations, c)
+	}
+	sort.Slice(reply.Conversations, func(i, j int) bool {
+		return reply.Conversations[i].Last.Time.After(reply.Conversations[j].Last.Time)
+	})
+	return nil
+}
+
+// EventsArgs are the arguments of API.Events.
+type EventsArgs struct {
+	Since   uint64 `json:"since"`   // return events with at least this sequence number (0: only new events)
+	Timeout int    `json:"timeout"` // seconds to wait for events (default: 30)
+}
+
+// EventsReply is the reply of API.Events.
+type EventsReply struct {
+	Events []Event `json:"events"`
+	Next   uint64  `json:"next"` // pass as Since in the next call
+}
+
+// Events subscribes to events by long polling: it returns all recent events
+// since the given sequence number and waits for new events if there are
+// none, until the timeout expires. Sequence numbers start at 1. Only the
+// most recent events are kept, a gap in the sequence numbers shows that
+// events have been missed.
+func (a *API) Events(args *EventsArgs, reply *EventsReply) error {
+	timeout := args.Timeout
+	if timeout <= 0 {
+		timeout = defaultEventsTimeout
+	} else if timeout > maxEventsTimeout {
+		timeout = maxEventsTimeout
+	}
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+	since := args.Since
+	for {
+		a.s.mutex.Lock()
+		if since == 0 {
+			since = a.s.next
+		}
+		events := a.s.eventsSince(since)
+		next := a.s.next
+		notify := a.s.notify
+		closed := a.s.closed
+		a.s.mutex.Unlock()
+		if len(events) > 0 {
+			reply.Events = events
+			reply.Next = events[len(events)-1].Seq + 1
+			return nil
+		}
+		if closed {
+			return ErrClosed
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			reply.Events = []Event{}
+			reply.Next = next
+			return nil
+		}
+	}
+}
+
+// PresenceArgs are the arguments of API.SetPresence.
+type PresenceArgs struct {
+	Account string `json:"account"` // default: all accounts
+	Show    string `json:"show"`    // "", "away", "chat", "dnd", or "xa"
+	Status  string `json:"status"`
+}
+
+// PresenceReply is the reply of API.SetPresence.
+type PresenceReply struct{}
+
+// SetPresence sets the presence of an account or of all connected accounts.
+func (a *API) SetPresence(args *PresenceArgs, reply *PresenceReply) error {
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	if args.Account != "" {
+		_, c, err := a.s.connection(args.Account)
+		if err != nil {
+			return err
+		}
+		return c.session.SetPresence(args.Show, args.Status)
+	}
+	for _, c := range a.s.connections {
+		if err := c.session.SetPresence(args.Show, args.Status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RoomArgs are the arguments of the room methods of API.
+type RoomArgs struct {
+	Account     string     `json:"account"`     // default: last account
+	Room        string     `json:"room"`        // bare JID of room
+	Nick        string     `json:"nick"`        // JoinRoom: nickname (default: from hill or localpart), ChangeNick: new nickname, SetRole: occupant
+	Password    string     `json:"password"`    // JoinRoom: room password (default: from hill), Invite: included password
+	History     *int       `json:"history"`     // JoinRoom: history messages (default: from hill)
+	Subject     string     `json:"subject"`     // SetSubject: new subject
+	JID         string     `json:"jid"`         // SetAffiliation: user, Invite: invitee
+	Role        string     `json:"role"`        // SetRole: new role
+	Affiliation string     `json:"affiliation"` // SetAffiliation: new affiliation
+	Reason      string     `json:"reason"`      // SetRole, SetAffiliation, Invite, Moderate: optional reason
+	Direct      bool       `json:"direct"`      // Invite: send direct invitation (XEP-0249)
+	Form        *xmpp.Form `json:"form"`        // ConfigureRoom: filled configuration form
+	ID          string     `json:"id"`          // Moderate: stanza ID of retracted message
+}
+
+// RoomReply is the reply of the room methods of API (except RoomInfo and
+// RoomConfig).
+type RoomReply struct{}
+
+// JoinRoom joins a multi-user chat room. Settings of the room which are not
+// given are taken from the hill, if the room is defined there. Otherwise the
+// room is added to the hill (joined automatically) and bookmarked.
+func (a *API) JoinRoom(args *RoomArgs, reply *RoomReply) error {
+	room, err := jid.Parse(args.Room)
+	if err != nil {
+		return err
+	}
+	a.s.mutex.Lock()
+	account, c, err := a.s.connection(args.Account)
+	if err != nil {
+		a.s.mutex.Unlock()
+		return err
+	}
+	r := config.Room{Room: room.Bare().String(), Local: account, Nick: room.Local,
+		History: config.DefaultRoomHistory, AutoJoin: true}
+	if j, err := jid.Parse(account); err == nil {
+		r.Nick = j.Local
+	}
+	existing := a.s.hill.Room(account, r.Room)
+	if existing != nil {
+		r = *existing
+	}
+	if args.Nick != "" {
+		r.Nick = args.Nick
+	}
+	if args.Password != "" {
+		r.Password = config.Secret(args.Password)
+	}
+	if args.History != nil {
+		r.History = *args.History
+	}
+	err = c.session.JoinRoom(r.Room, r.Nick, r.Password, r.History)
+	if err == nil && existing == nil {
+		err = a.s.hill.AddRoom(r)
+	}
+	a.s.mutex.Unlock()
+	if err != nil || existing != nil {
+		return err
+	}
+	a.s.publishBookmark(account, c.session, r)
+	return nil
+}
+
+// LeaveRoom leaves a multi-user chat room. The room is removed from the hill
+// and its bookmarks.
+func (a *API) LeaveRoom(args *RoomArgs, reply *RoomReply) error {
+	room, err := jid.Parse(args.Room)
+	if err != nil {
+		return err
+	}
+	a.s.mutex.Lock()
+	account, c, err := a.s.connection(args.Account)
+	if err != nil {
+		a.s.mutex.Unlock()
+		return err
+	}
+	err = c.session.LeaveRoom(room.Bare().String())
+	var bookmarked bool
+	if r := a.s.hill.Room(account, room.Bare().String()); err == nil && r != nil {
+		bookmarked = r.Bookmarked
+		err = a.s.hill.RemoveRoom(account, r.Room)
+	}
+	a.s.mutex.Unlock()
+	if err != nil || !bookmarked {
+		return err
+	}
+	return c.session.RetractBookmark(room.Bare().String())
+}
+
+// ChangeNick changes our nickname in a multi-user chat room.
+func (a *API) ChangeNick(args *RoomArgs, reply *RoomReply) error {
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	_, c, err := a.s.connection(args.Account)
+	if err != nil {
+		return err
+	}
+	return c.session.ChangeNick(args.Room, args.Nick)
+}
+
+// SetSubject changes the subject of a multi-user chat room.
+func (a *API) SetSubject(args *RoomArgs, reply *RoomReply) error {
+	a.s.mutex.Lock()
+	defer a.s.mutex.Unlock()
+	_, c, err := a.s.connection(args.Account)
+	if err != nil {
+		return err
+	}
+	return c.session.SetSubject(args.Room, args.Subject)
+}
+
+// session returns the session of account. Unlike connection, the mutex is
+// not held afterwards, for requests which wait for a response.
+func (s *Server) session(account string) (session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, c, err := s.connection(account)
+	if err != nil {
+		return nil, err
+	}
+	return c.session, nil
+}
+
+// SetRole changes the role of an occupant of a multi-user chat room (e.g.,
+// to kick the occupant or to grant voice).
+func (a *API) SetRole(args *RoomArgs, reply *RoomReply) error {
+	sess, err := a.s.session(args.Account)
+	if err != nil {
+		return err
+	}
+	return sess.SetRole(args.Room, args.Nick, args.Role, args.Reason)
+}
+
+// SetAffiliation changes the affiliation of a user with a multi-user chat
+// room (e.g., to ban the user or to grant membership).
+func (a *API) SetAffiliation(args *RoomArgs, reply *RoomReply) error {
+	sess, err := a.s.session(args.Account)
+	if err != nil {
+		return err
+	}
+	return sess.SetAffiliation(args.Room, args.JID, args.Affiliation, args.Reason)
+}
+
+// Invite invites a user to a multi-user chat room. Direct invitations
+// include the room password from the hill, if none is given.
+func (a *API) Invite(args *RoomArgs, reply *RoomReply) error {
+	a.s.mutex.Lock()
+	account, c, err := a.s.connection(args.Account)
+	password := config.Secret(args.Password)
+	if err == nil && len(password) == 0 {
+		if r := a.s.hill.Room(account, args.Room); r != nil {
+			password = append(config.Secret(nil), r.Password...)
+		}
+	}
+	a.s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if args.Direct {
+		defer password.Wipe()
+		return c.session.DirectInvite(args.Room, args.JID, args.Reason, password)
+	}
+	return c.session.Invite(args.Room, args.JID, args.Reason)
+}
+
+// RoomInfoReply is the reply of API.RoomInfo.
+type RoomInfoReply struct {
+	Info *xmpp.RoomInfo `json:"info"`
+}
+
+// RoomInfo returns whether a multi-user chat room is members-only and
+// non-anonymous.
+func (a *API) RoomInfo(args *RoomArgs, reply *RoomInfoReply) error {
+	sess, err := a.s.session(args.Account)
+	if err != nil {
+		return err
+	}
+	reply.Info, err = sess.RoomInfo(args.Room)
+	return err
+}
+
+// RoomConfigReply is the reply of API.RoomConfig.
+type RoomConfigReply struct {
+	Form *xmpp.Form `json:"form"`
+}
+
+// RoomConfig returns the configuration form of a multi-user chat room.
+func (a *API) RoomConfig(args *RoomArgs, reply *RoomConfigReply) error {
+	sess, err := a.s.session(args.Account)
+	if err != nil {
+		return err
+	}
+	reply.Form, err = sess.RoomConfig(args.Room)
+	return err
+}
+
+// ConfigureRoom submits the configuration form of a multi-user chat room.
+func (a *API) ConfigureRoom(args *RoomArgs, reply *RoomReply) error {
+	if args.Form == nil {
+		return errors.New("daemon: configuration form missing")
+	}
+	sess, err := a.s.session(args.Account)
+	if err != nil {
+		return err
+	}
+	return sess.ConfigureRoom(args.Room, args.Form)
+}
+
+// Moderate retracts a message of another occupant from a multi-user chat
+// room (requires the moderator role).
+func (a *API) Moderate(args *RoomArgs, reply *RoomReply) error {
+	if args.ID == "" {
+		return errors.New("daemon: message ID missing")
+	}
+	sess, err := a.s.session(args.Account)
+	if err != nil {
+		return err
+	}
+	return sess.Moderate(args.Room, args.ID, args.Reason)
+}
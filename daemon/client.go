@@ -0,0 +1,376 @@
+package daemon
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// Client is a client of a running daemon.
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the daemon listening on the Unix domain socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{rpc: jsonrpc.NewClient(conn)}, nil
+}
+
+// Close the connection to the daemon.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+func (c *Client) call(method string, args, reply interface{}) error {
+	return c.rpc.Call(ServiceName+"."+method, args, reply)
+}
+
+// Hill returns the hill of the daemon with all secrets redacted.
+func (c *Client) Hill() (*config.Hill, error) {
+	var reply HillReply
+	if err := c.call("Hill", &HillArgs{}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Hill, nil
+}
+
+// Send msg from account (the last account if empty). Only the recipient,
+// type, text, and the fields to send markers, chat states, corrections, and
+// replies of msg are used.
+func (c *Client) Send(account string, msg *xmpp.Message) (*xmpp.Message, error) {
+	var reply SendReply
+	args := &SendArgs{
+		Account:   account,
+		To:        msg.To,
+		Type:      msg.Type,
+		Text:      msg.Text,
+		ID:        msg.ID,
+		Marker:    msg.Marker,
+		ChatState: msg.ChatState,
+		Replace:   msg.Replace,
+		ReplyTo:   msg.ReplyTo,
+		ReplyID:   msg.ReplyID,
+		Quote:     msg.Quote,
+	}
+	if err := c.call("Send", args, &reply); err != nil {
+		return nil, err
+	}
+	return &reply.Message, nil
+}
+
+// Conversations lists the conversations of account (all accounts if empty).
+// The messages are included if history is true.
+func (c *Client) Conversations(account string, history bool) ([]Conversation, error) {
+	var reply ConversationsReply
+	args := &ConversationsArgs{Account: account, History: history}
+	if err := c.call("Conversations", args, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Conversations, nil
+}
+
+// Events waits up to timeout seconds for events with at least sequence
+// number since (0: only new events) and returns them together with the
+// sequence number to pass in the next call.
+func (c *Client) Events(since uint64, timeout int) ([]Event, uint64, error) {
+	var reply EventsReply
+	args := &EventsArgs{Since: since, Timeout: timeout}
+	if err := c.call("Events", args, &reply); err != nil {
+		return nil, 0, err
+	}
+	return reply.Events, reply.Next, nil
+}
+
+// SetPresence sets the presence of account (all accounts if empty).
+func (c *Client) SetPresence(account, show, status string) error {
+	args := &PresenceArgs{Account: account, Show: show, Status: status}
+	return c.call("SetPresence", args, &PresenceReply{})
+}
+
+// JoinRoom joins room from account (the last account if empty) with the
+// given nickname, password, and number of history messages. Empty values
+// (and a negative history) are taken from the hill of the daemon.
+func (c *Client) JoinRoom(account, room, nick string, password []byte, history int) error {
+	args := &RoomArgs{
+		Account:  account,
+		Room:     room,
+		Nick:     nick,
+		Password: string(password),
+	}
+	if history >= 0 {
+		args.History = &history
+	}
+	return c.call("JoinRoom", args, &RoomReply{})
+}
+
+// LeaveRoom leaves room with account (the last account if empty).
+func (c *Client) LeaveRoom(account, room string) error {
+	args := &RoomArgs{Account: account, Room: room}
+	return c.call("LeaveRoom", args, &RoomReply{})
+}
+
+// ChangeNick changes our nickname in room with account (the last account
+// if empty).
+func (c *Client) ChangeNick(account, room, nick string) error {
+	args := &RoomArgs{Account: account, Room: room, Nick: nick}
+	return c.call("ChangeNick", args, &RoomReply{})
+}
+
+// SetSubject sets the subject of room with account (the last account if
+// empty).
+func (c *Client) SetSubject(account, room, subject string) error {
+	args := &RoomArgs{Account: account, Room: room, Subject: subject}
+	return c.call("SetSubject", args, &RoomReply{})
+}
+
+// SetRole sets the role of the occupant nick in room with account (the last
+// account if empty).
+func (c *Client) SetRole(account, room, nick, role, reason string) error {
+	args := &RoomArgs{Account: account, Room: room, Nick: nick, Role: role, Reason: reason}
+	return c.call("SetRole", args, &RoomReply{})
+}
+
+// SetAffiliation sets the affiliation of user with room with account (the
+// last account if empty).
+func (c *Client) SetAffiliation(account, room, user, affiliation, reason string) error {
+	args := &RoomArgs{
+		Account:     account,
+		Room:        room,
+		JID:         user,
+		Affiliation: affiliation,
+		Reason:      reason,
+	}
+	return c.call("SetAffiliation", args, &RoomReply{})
+}
+
+// Invite invites user to room with account (the last account if empty). If
+// direct is true, a direct invitation including password is sent.
+func (c *Client) Invite(account, room, user, reason string, direct bool, password []byte) error {
+	args := &RoomArgs{
+		Account:  account,
+		Room:     room,
+		JID:      user,
+		Reason:   reason,
+		Direct:   direct,
+		Password: string(password),
+	}
+	return c.call("Invite", args, &RoomReply{})
+}
+
+// RoomInfo returns the information about room with account (the last
+// account if empty).
+func (c *Client) RoomInfo(account, room string) (*xmpp.RoomInfo, error) {
+	var reply RoomInfoReply
+	err := c.call("RoomInfo", &RoomArgs{Account: account, Room: room}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Info, nil
+}
+
+// RoomConfig returns the configuration form of room with account (the last
+// account if empty).
+func (c *Client) RoomConfig(account, room string) (*xmpp.Form, error) {
+	var reply RoomConfigReply
+	err := c.call("RoomConfig", &RoomArgs{Account: account, Room: room}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Form, nil
+}
+
+// ConfigureRoom submits the configuration form of room with account (the
+// last account if empty).
+func (c *Client) ConfigureRoom(account, room string, form *xmpp.Form) error {
+	args := &RoomArgs{Account: account, Room: room, Form: form}
+	return c.call("ConfigureRoom", args, &RoomReply{})
+}
+
+// Retract retracts our message with the given ID sent to to with account
+// (the last account if empty).
+func (c *Client) Retract(account, to, id string, groupchat bool) error {
+	args := &RetractArgs{Account: account, To: to, Type: xmpp.Chat, ID: id}
+	if groupchat {
+		args.Type = xmpp.Groupchat
+	}
+	return c.call("Retract", args, &RetractReply{})
+}
+
+// React sets our reactions to the message with the given ID from to with
+// account (the last account if empty).
+func (c *Client) React(account, to, id string, groupchat bool, reactions []string) error {
+	args := &ReactArgs{Account: account, To: to, Type: xmpp.Chat, ID: id, Reactions: reactions}
+	if groupchat {
+		args.Type = xmpp.Groupchat
+	}
+	return c.call("React", args, &ReactReply{})
+}
+
+// Moderate retracts the message with the given stanza ID from room with
+// account (the last account if empty).
+func (c *Client) Moderate(account, room, id, reason string) error {
+	args := &RoomArgs{Account: account, Room: room, ID: id, Reason: reason}
+	return c.call("Moderate", args, &RoomReply{})
+}
+
+// Attachment is the attachment of a client to the XMPP session of an account
+// in the daemon.
+type Attachment struct {
+	client  *Client
+	account string
+	mutex   sync.Mutex
+	closed  bool
+}
+
+// SetPresence sets our presence via the daemon.
+func (a *Attachment) SetPresence(show, status string) error {
+	return a.client.SetPresence(a.account, show, status)
+}
+
+// JoinRoom joins room via the daemon.
+func (a *Attachment) JoinRoom(room, nick string, password []byte, history int) error {
+	return a.client.JoinRoom(a.account, room, nick, password, history)
+}
+
+// LeaveRoom leaves room via the daemon.
+func (a *Attachment) LeaveRoom(room string) error {
+	return a.client.LeaveRoom(a.account, room)
+}
+
+// ChangeNick changes our nickname in room via the daemon.
+func (a *Attachment) ChangeNick(room, nick string) error {
+	return a.client.ChangeNick(a.account, room, nick)
+}
+
+// SetSubject sets the subject of room via the daemon.
+func (a *Attachment) SetSubject(room, subject string) error {
+	return a.client.SetSubject(a.account, room, subject)
+}
+
+// SetRole sets the role of an occupant of room via the daemon.
+func (a *Attachment) SetRole(room, nick, role, reason string) error {
+	return a.client.SetRole(a.account, room, nick, role, reason)
+}
+
+// SetAffiliation sets the affiliation of user with room via the daemon.
+func (a *Attachment) SetAffiliation(room, user, affiliation, reason string) error {
+	return a.client.SetAffiliation(a.account, room, user, affiliation, reason)
+}
+
+// Invite invites user to room via the daemon (mediated invitation).
+func (a *Attachment) Invite(room, user, reason string) error {
+	return a.client.Invite(a.account, room, user, reason, false, nil)
+}
+
+// DirectInvite invites user to room via the daemon (direct invitation).
+func (a *Attachment) DirectInvite(room, user, reason string, password []byte) error {
+	return a.client.Invite(a.account, room, user, reason, true, password)
+}
+
+// RoomInfo returns the information about room via the daemon.
+func (a *Attachment) RoomInfo(room string) (*xmpp.RoomInfo, error) {
+	return a.client.RoomInfo(a.account, room)
+}
+
+// RoomConfig returns the configuration form of room via the daemon.
+func (a *Attachment) RoomConfig(room string) (*xmpp.Form, error) {
+	return a.client.RoomConfig(a.account, room)
+}
+
+// ConfigureRoom submits the configuration form of room via the daemon.
+func (a *Attachment) ConfigureRoom(room string, form *xmpp.Form) error {
+	return a.client.ConfigureRoom(a.account, room, form)
+}
+
+// Retract retracts our message via the daemon.
+func (a *Attachment) Retract(to, id string, groupchat bool) error {
+	return a.client.Retract(a.account, to, id, groupchat)
+}
+
+// Moderate retracts a message from room via the daemon.
+func (a *Attachment) Moderate(room, id, reason string) error {
+	return a.client.Moderate(a.account, room, id, reason)
+}
+
+// React sets our reactions to a message via the daemon.
+func (a *Attachment) React(to, id string, groupchat bool, reactions []string) error {
+	return a.client.React(a.account, to, id, groupchat, reactions)
+}
+
+func (a *Attachment) isClosed() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.closed
+}
+
+// Close the attachment. The XMPP session in the daemon stays connected.
+func (a *Attachment) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closed = true
+	return nil
+}
+
+// Start attaches to the XMPP session of account in the daemon, it has the
+// same semantics as xmpp.Start: Messages are read from send channel and sent
+// via the daemon, incoming messages and other events of account are written
+// to the recv channel. The debug flag is ignored, XMPP debugging has to be
+// enabled in the daemon.
+func (c *Client) Start(
+	account *config.Account,
+	send <-chan xmpp.Message,
+	recv chan<- xmpp.Event,
+	debug bool,
+) (*Attachment, error) {
+	username := account.Username
+	a := &Attachment{client: c, account: username}
+
+	go func() {
+		defer close(recv)
+		var since uint64
+		for {
+			events, next, err := c.Events(since, 0)
+			if a.isClosed() {
+				return
+			}
+			if err != nil {
+				log.Printf("receiving events from daemon failed: %v", err)
+				recv <- xmpp.Event{
+					Kind:    xmpp.DisconnectEvent,
+					Account: username,
+					Error:   err.Error(),
+				}
+				return
+			}
+			for _, ev := range events {
+				if ev.Account != username {
+					continue
+				}
+				if ev.Kind == xmpp.MessageEvent && ev.Message.From == username {
+					continue // sent by ourselves (or another client)
+				}
+				recv <- ev.Event
+			}
+			since = next
+		}
+	}()
+
+	go func() {
+		for msg := range send {
+			if _, err := c.Send(username, &msg); err != nil {
+				log.Printf("sending message via daemon failed: %v", err)
+			}
+		}
+	}()
+
+	return a, nil
+}
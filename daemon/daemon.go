@@ -0,0 +1,454 @@
+// Package daemon implements the headless daemon mode of Mole.
+//
+// The daemon holds the decrypted hill and the XMPP sessions of all accounts
+// and serves a JSON-RPC 1.0 API (see API) on a Unix domain socket which is
+// only accessible by the user running the daemon.
+package daemon
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/hook"
+	"github.com/frankbraun/mole/util"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// ServiceName is the name of the JSON-RPC service (methods are called as
+// "Mole.Send" etc.).
+const ServiceName = "Mole"
+
+const (
+	maxEvents      = 1000             // number of events kept for Events
+	maxMessages    = 1000             // number of messages kept per conversation
+	reconnectDelay = 30 * time.Second // delay before reconnecting lost sessions
+)
+
+// ErrClosed is returned if the server has been closed.
+var ErrClosed = errors.New("daemon: server closed")
+
+// ErrRunning is returned if another daemon is serving on the socket.
+var ErrRunning = errors.New("daemon: another daemon is running")
+
+// session is a running XMPP session.
+type session interface {
+	SetPresence(show, status string) error
+	JoinRoom(room, nick string, password []byte, history int) error
+	LeaveRoom(room string) error
+	ChangeNick(room, nick string) error
+	SetSubject(room, subject string) error
+	SetRole(room, nick, role, reason string) error
+	SetAffiliation(room, user, affiliation, reason string) error
+	Invite(room, user, reason string) error
+	DirectInvite(room, user, reason string, password []byte) error
+	RoomInfo(room string) (*xmpp.RoomInfo, error)
+	RoomConfig(room string) (*xmpp.Form, error)
+	ConfigureRoom(room string, form *xmpp.Form) error
+	Bookmarks() ([]config.Room, error)
+	PublishBookmark(r *config.Room) error
+	RetractBookmark(room string) error
+	Retract(to, id string, groupchat bool) error
+	Moderate(room, id, reason string) error
+	React(to, id string, groupchat bool, reactions []string) error
+	Close() error
+}
+
+// xmppStartFunc starts an XMPP session (see xmpp.Start).
+type xmppStartFunc func(account *config.Account, send <-chan xmpp.Message,
+	recv chan<- xmpp.Event, debug bool) (session, error)
+
+// startSession starts an XMPP session with xmpp.Start.
+func startSession(
+	account *config.Account,
+	send <-chan xmpp.Message,
+	recv chan<- xmpp.Event,
+	debug bool,
+) (session, error) {
+	sess, err := xmpp.Start(account, send, recv, debug)
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// connection is the XMPP session of an account.
+type connection struct {
+	session session
+	mutex   sync.RWMutex // held for reading while sending, for writing while closing
+	send    chan xmpp.Message
+	closed  bool // send has been closed
+}
+
+// post sends msg via the session of c. The server must not be locked,
+// because sending blocks until the session takes the message.
+func (c *connection) post(msg xmpp.Message) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.closed {
+		return errors.New("daemon: account has been disconnected")
+	}
+	c.send <- msg
+	return nil
+}
+
+// close closes the send channel of c, after pending messages have been
+// taken by the session.
+func (c *connection) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	close(c.send)
+}
+
+// conversationKey identifies a conversation.
+type conversationKey struct {
+	account string
+	remote  string
+}
+
+// Server is a Mole daemon.
+type Server struct {
+	hill      *config.Hill  // decrypted hill
+	save      saveFunc      // saves the hill when the server is closed
+	xmppStart xmppStartFunc // starts XMPP sessions (replaced in tests)
+	xmppDebug bool          // enable XMPP debugging
+	hooks     *hook.Runner  // runs hooks on events
+
+	mutex         sync.Mutex                         // protects the following fields
+	listener      net.Listener                       // control socket
+	connections   map[string]*connection             // by account
+	conversations map[conversationKey][]xmpp.Message // message history
+	events        []Event                            // recent events
+	next          uint64                             // sequence number of next event
+	notify        chan struct{}                      // closed when events are added
+	closed        bool                               // server has been closed
+}
+
+// saveFunc saves the marshalled hill data (see storage.State.Save).
+type saveFunc func(data []byte) error
+
+// New returns a new daemon for the given hill. The hill is saved with save
+// (if not nil) and wiped when the server is closed.
+func New(hill *config.Hill, save saveFunc, xmppDebug bool) *Server {
+	return &Server{
+		hill:          hill,
+		save:          save,
+		xmppStart:     startSession,
+		xmppDebug:     xmppDebug,
+		hooks:         hook.New(&hill.Settings),
+		connections:   make(map[string]*connection),
+		conversations: make(map[conversationKey][]xmpp.Message),
+		next:          1,
+		notify:        make(chan struct{}),
+	}
+}
+
+// Start the XMPP sessions of all accounts, synchronize their bookmarks, and
+// join their auto-join rooms.
+// Accounts which cannot connect are retried periodically.
+func (s *Server) Start() {
+	for i := range s.hill.Accounts {
+		s.connect(&s.hill.Accounts[i])
+	}
+}
+
+// connect starts the XMPP session of account. The server is not locked
+// while connecting.
+func (s *Server) connect(account *config.Account) {
+	if s.isClosed() {
+		return
+	}
+	send := make(chan xmpp.Message)
+	recv := make(chan xmpp.Event)
+	sess, err := s.xmppStart(account, send, recv, s.xmppDebug)
+	if err != nil {
+		log.Printf("connecting account '%s' failed: %v", account.Username, err)
+		time.AfterFunc(reconnectDelay, func() { s.connect(account) })
+		return
+	}
+	s.mutex.Lock()
+	if s.closed {
+		// closed while connecting
+		s.mutex.Unlock()
+		sess.Close()
+		go func() {
+			for range recv {
+			}
+			close(send)
+		}()
+		return
+	}
+	log.Printf("account '%s' connected.", account.Username)
+	c := &connection{session: sess, send: send}
+	s.connections[account.Username] = c
+	rooms := s.hill.AccountRooms(account.Username)
+	s.mutex.Unlock()
+	go s.receive(account, c, recv)
+	for _, room := range rooms {
+		if !room.AutoJoin {
+			continue
+		}
+		err := sess.JoinRoom(room.Room, room.Nick, room.Password, room.History)
+		if err != nil {
+			log.Printf("joining room '%s' failed: %v", room.Room, err)
+		}
+	}
+	go s.syncBookmarks(account.Username, sess)
+}
+
+// syncBookmarks synchronizes the rooms of account with its bookmarks and
+// joins the added auto-join rooms. The changes are saved to the hill file
+// when the server is closed.
+func (s *Server) syncBookmarks(account string, sess session) {
+	bookmarks, err := sess.Bookmarks()
+	if err != nil {
+		log.Printf("fetching bookmarks of account '%s' failed: %v", account, err)
+		return
+	}
+	s.mutex.Lock()
+	added, publish, _ := s.hill.SyncRooms(account, bookmarks)
+	s.mutex.Unlock()
+	for _, room := range added {
+		if !room.AutoJoin {
+			continue
+		}
+		err := sess.JoinRoom(room.Room, room.Nick, room.Password, room.History)
+		if err != nil {
+			log.Printf("joining room '%s' failed: %v", room.Room, err)
+		}
+	}
+	for _, room := range publish {
+		s.publishBookmark(account, sess, room)
+	}
+}
+
+// publishBookmark bookmarks room of account and marks it as bookmarked in
+// the hill.
+func (s *Server) publishBookmark(account string, sess session, room config.Room) {
+	if err := sess.PublishBookmark(&room); err != nil {
+		log.Printf("bookmarking room '%s' failed: %v", room.Room, err)
+		return
+	}
+	s.mutex.Lock()
+	if r := s.hill.Room(account, room.Room); r != nil {
+		r.Bookmarked = true
+	}
+	s.mutex.Unlock()
+}
+
+// receive events of account from recv.
+func (s *Server) receive(account *config.Account, c *connection, recv <-chan xmpp.Event) {
+	for ev := range recv {
+		s.hooks.Handle(ev)
+		var receipt *xmpp.Message
+		s.mutex.Lock()
+		switch ev.Kind {
+		case xmpp.MessageEvent:
+			s.addMessage(ev.Account, ev.Message.From, ev.Message)
+			msg := ev.Message
+			if msg.Receipt && msg.ID != "" && !s.hill.Settings.NoReceipts {
+				receipt = &xmpp.Message{To: msg.From, ID: msg.ID, Marker: xmpp.Delivered}
+			}
+		case xmpp.ReceiptEvent:
+			s.updateState(ev.Account, ev.Receipt)
+		case xmpp.RetractEvent:
+			s.retract(ev.Account, ev.Retraction)
+		}
+		s.addEvent(ev)
+		s.mutex.Unlock()
+		if receipt != nil {
+			if err := c.post(*receipt); err != nil {
+				log.Printf("sending receipt failed: %v", err)
+			}
+		}
+	}
+	// session ended
+	c.close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.connections[account.Username] == c {
+		delete(s.connections, account.Username)
+	}
+	if !s.closed {
+		log.Printf("account '%s' disconnected, reconnecting in %v",
+			account.Username, reconnectDelay)
+		time.AfterFunc(reconnectDelay, func() { s.connect(account) })
+	}
+}
+
+// addMessage adds msg to the conversation of account with remote. A
+// correction replaces the text of the corrected message instead, if it is
+// still kept. s.mutex must be held.
+func (s *Server) addMessage(account, remote string, msg *xmpp.Message) {
+	key := conversationKey{account: account, remote: remote}
+	if msg.Replace != "" && correct(s.conversations[key], msg) {
+		return
+	}
+	msgs := append(s.conversations[key], *msg)
+	if len(msgs) > maxMessages {
+		msgs = msgs[len(msgs)-maxMessages:]
+	}
+	s.conversations[key] = msgs
+}
+
+// correct replaces the text of the message in msgs which is corrected by
+// msg and reports whether it has been found. Only the sender of a message
+// can correct it.
+func correct(msgs []xmpp.Message, msg *xmpp.Message) bool {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		m := &msgs[i]
+		if m.ID == msg.Replace && m.From == msg.From && m.Nick == msg.Nick {
+			m.Text = msg.Text
+			return true
+		}
+	}
+	return false
+}
+
+// retract removes the text of the messages of account retracted by r and
+// the quotes of replies to them. s.mutex must be held.
+func (s *Server) retract(account string, r *xmpp.Retraction) {
+	msgs := s.conversations[conversationKey{account: account, remote: r.From}]
+	retracted := make(map[string]bool)
+	for i := range msgs {
+		if r.Matches(account, &msgs[i]) {
+			msgs[i].Text = ""
+			msgs[i].Retracted = true
+			retracted[msgs[i].ID] = true
+			retracted[msgs[i].StanzaID] = true
+		}
+	}
+	for i := range msgs {
+		if msgs[i].ReplyID != "" && retracted[msgs[i].ReplyID] {
+			msgs[i].Quote = ""
+		}
+	}
+}
+
+// updateState updates the delivery state of the sent message r refers to.
+// s.mutex must be held.
+func (s *Server) updateState(account string, r *xmpp.Receipt) {
+	msgs := s.conversations[conversationKey{account: account, remote: r.From}]
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].ID == r.ID {
+			if xmpp.Later(r.State, msgs[i].State) {
+				msgs[i].State = r.State
+			}
+			return
+		}
+	}
+}
+
+// addEvent adds ev to the list of recent events and wakes up waiting
+// clients. s.mutex must be held.
+func (s *Server) addEvent(ev xmpp.Event) {
+	s.events = append(s.events, Event{Seq: s.next, Event: ev})
+	s.next++
+	if len(s.events) > maxEvents {
+		s.events = s.events[len(s.events)-maxEvents:]
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// eventsSince returns all recent events with a sequence number of at least
+// seq. s.mutex must be held.
+func (s *Server) eventsSince(seq uint64) []Event {
+	for i, ev := range s.events {
+		if ev.Seq >= seq {
+			return append([]Event(nil), s.events[i:]...)
+		}
+	}
+	return nil
+}
+
+// Serve the JSON-RPC API on the Unix domain socket at path until the
+// server is closed. The socket is only accessible by the current user, a
+// stale socket file at path is replaced. If another daemon answers on the
+// socket, ErrRunning is returned.
+func (s *Server) Serve(path string) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return ErrRunning
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := listen(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		l.Close()
+		return ErrClosed
+	}
+	s.listener = l
+	s.mutex.Unlock()
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(ServiceName, &API{s: s}); err != nil {
+		return err
+	}
+	log.Printf("listening on '%s'", path)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+// Close the server: stop serving, close all XMPP sessions, and save and wipe
+// the hill.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.notify)
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	connections := s.connections
+	s.connections = make(map[string]*connection)
+	s.mutex.Unlock()
+	for username, c := range connections {
+		if err := c.session.Close(); err != nil {
+			log.Printf("closing session of account '%s' failed: %v", username, err)
+		}
+	}
+	s.hooks.Wait()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.save != nil {
+		data := s.hill.Marshal()
+		if serr := s.save(data); serr != nil && err == nil {
+			err = serr
+		}
+		util.Wipe(data)
+	}
+	s.hill.Wipe()
+	return err
+}
@@ -0,0 +1,735 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// fakeSession records sent messages and presences.
+type fakeSession struct {
+	mutex      sync.Mutex
+	recv       chan<- xmpp.Event
+	sent       []xmpp.Message
+	presences  []string
+	joined     []string
+	bookmarks  []config.Room
+	bookmarked []string
+}
+
+func (f *fakeSession) SetPresence(show, status string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.presences = append(f.presences, show+"/"+status)
+	return nil
+}
+
+func (f *fakeSession) JoinRoom(room, nick string, password []byte, history int) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s/%s %s %d", room, nick, password, history))
+	return nil
+}
+
+func (f *fakeSession) LeaveRoom(room string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, "-"+room)
+	return nil
+}
+
+func (f *fakeSession) ChangeNick(room, nick string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, room+" nick "+nick)
+	return nil
+}
+
+func (f *fakeSession) SetSubject(room, subject string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, room+" "+subject)
+	return nil
+}
+
+func (f *fakeSession) SetRole(room, nick, role, reason string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s role %s %s", room, nick, role))
+	return nil
+}
+
+func (f *fakeSession) SetAffiliation(room, user, affiliation, reason string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s affiliation %s %s", room, user, affiliation))
+	return nil
+}
+
+func (f *fakeSession) Invite(room, user, reason string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s invite %s", room, user))
+	return nil
+}
+
+func (f *fakeSession) DirectInvite(room, user, reason string, password []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s direct-invite %s %s", room, user, password))
+	return nil
+}
+
+func (f *fakeSession) RoomInfo(room string) (*xmpp.RoomInfo, error) {
+	info := &xmpp.RoomInfo{Room: room, MembersOnly: true}
+	info.NonAnonymous = room == "team@conference.example.com"
+	return info, nil
+}
+
+func (f *fakeSession) RoomConfig(room string) (*xmpp.Form, error) {
+	return &xmpp.Form{Type: "form", Title: room}, nil
+}
+
+func (f *fakeSession) ConfigureRoom(room string, form *xmpp.Form) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s configure %s", room, form.Type))
+	return nil
+}
+
+func (f *fakeSession) Bookmarks() ([]config.Room, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]config.Room(nil), f.bookmarks...), nil
+}
+
+func (f *fakeSession) PublishBookmark(r *config.Room) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.bookmarked = append(f.bookmarked, r.Room)
+	return nil
+}
+
+func (f *fakeSession) RetractBookmark(room string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.bookmarked = append(f.bookmarked, "-"+room)
+	return nil
+}
+
+func (f *fakeSession) Retract(to, id string, groupchat bool) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s retract %s %v", to, id, groupchat))
+	return nil
+}
+
+func (f *fakeSession) Moderate(room, id, reason string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s moderate %s %s", room, id, reason))
+	return nil
+}
+
+func (f *fakeSession) React(to, id string, groupchat bool, reactions []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.joined = append(f.joined, fmt.Sprintf("%s react %s %v %v", to, id, groupchat, reactions))
+	return nil
+}
+
+func (f *fakeSession) rooms() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.joined...)
+}
+
+func (f *fakeSession) Close() error {
+	close(f.recv)
+	return nil
+}
+
+func (f *fakeSession) messages() []xmpp.Message {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]xmpp.Message(nil), f.sent...)
+}
+
+type fakeXMPP struct {
+	mutex     sync.Mutex
+	sessions  map[string]*fakeSession
+	bookmarks map[string][]config.Room // by account
+}
+
+func (f *fakeXMPP) start(
+	account *config.Account,
+	send <-chan xmpp.Message,
+	recv chan<- xmpp.Event,
+	debug bool,
+) (session, error) {
+	sess := &fakeSession{recv: recv, bookmarks: f.bookmarks[account.Username]}
+	go func() {
+		for msg := range send {
+			sess.mutex.Lock()
+			sess.sent = append(sess.sent, msg)
+			sess.mutex.Unlock()
+		}
+	}()
+	f.mutex.Lock()
+	f.sessions[account.Username] = sess
+	f.mutex.Unlock()
+	return sess, nil
+}
+
+func startServer(t *testing.T) (*Server, *fakeXMPP, *Client) {
+	return startServerWithBookmarks(t, nil)
+}
+
+// startServerWithBookmarks starts a server whose accounts have the given
+// bookmarks.
+func startServerWithBookmarks(
+	t *testing.T,
+	bookmarks map[string][]config.Room,
+) (*Server, *fakeXMPP, *Client) {
+	hill, err := config.NewHill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"alice@example.com", "carol@example.org"} {
+		err := hill.AddAccount(config.Account{
+			Username: username,
+			Password: config.Secret("secret"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = hill.AddRoom(config.Room{
+		Room:     "team@conference.example.com",
+		Local:    "alice@example.com",
+		Nick:     "al",
+		Password: config.Secret("pw"),
+		History:  5,
+		AutoJoin: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeXMPP{sessions: make(map[string]*fakeSession), bookmarks: bookmarks}
+	srv := New(hill, nil, false)
+	srv.xmppStart = f.start
+	srv.Start()
+	dir, err := ioutil.TempDir("", "mole-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "mole.sock")
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(path) }()
+	var client *Client
+	for i := 0; ; i++ {
+		client, err = Dial(path)
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Dial() failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket has permissions %o, want 600", perm)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		if err := srv.Close(); err != nil {
+			t.Errorf("Close() failed: %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("Serve() failed: %v", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("socket not removed")
+		}
+	})
+	return srv, f, client
+}
+
+func TestServeRunning(t *testing.T) {
+	srv, _, _ := startServer(t)
+	srv.mutex.Lock()
+	path := srv.listener.Addr().String()
+	srv.mutex.Unlock()
+	hill, err := config.NewHill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := New(hill, nil, false)
+	defer second.Close()
+	if err := second.Serve(path); err != ErrRunning {
+		t.Errorf("Serve() on socket of running daemon: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("socket of running daemon removed: %v", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	hill, err := config.NewHill()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []byte
+	srv := New(hill, func(data []byte) error {
+		saved = append([]byte(nil), data...)
+		return nil
+	}, false)
+	err = hill.AddAccount(config.Account{Username: "alice@example.com", Password: config.Secret("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = hill.AddRoom(config.Room{Room: "ops@conference.example.com", Local: "alice@example.com", Nick: "al"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	h, _, err := config.Unmarshal(saved)
+	if err != nil {
+		t.Fatalf("config.Unmarshal() failed: %v", err)
+	}
+	if h.Room("alice@example.com", "ops@conference.example.com") == nil {
+		t.Errorf("changed hill not saved: %s", saved)
+	}
+}
+
+func TestHill(t *testing.T) {
+	_, _, client := startServer(t)
+	hill, err := client.Hill()
+	if err != nil {
+		t.Fatalf("Hill() failed: %v", err)
+	}
+	if len(hill.Accounts) != 2 {
+		t.Fatalf("Hill() returned %d accounts, want 2", len(hill.Accounts))
+	}
+	for _, account := range hill.Accounts {
+		if string(account.Password) != config.Redacted {
+			t.Errorf("password of account '%s' not redacted", account.Username)
+		}
+	}
+}
+
+func TestSendAndConversations(t *testing.T) {
+	_, f, client := startServer(t)
+	msg, err := client.Send("alice@example.com", &xmpp.Message{To: "bob@example.com", Text: "helo"})
+	if err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	// correction
+	_, err = client.Send("alice@example.com", &xmpp.Message{To: "bob@example.com", Text: "hello", Replace: msg.ID})
+	if err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if _, err := client.Send("nobody@example.com", &xmpp.Message{To: "bob@example.com", Text: "hello"}); err == nil {
+		t.Error("Send() from unknown account should fail")
+	}
+	if _, err := client.Send("", &xmpp.Message{To: "example.com/", Text: "hello"}); err == nil {
+		t.Error("Send() to invalid JID should fail")
+	}
+	f.sessions["carol@example.org"].recv <- xmpp.Event{
+		Kind:    xmpp.MessageEvent,
+		Account: "carol@example.org",
+		Message: &xmpp.Message{
+			From: "bob@example.com",
+			To:   "carol@example.org",
+			Text: "hi",
+			Time: time.Now().Add(time.Second),
+		},
+	}
+	var convs []Conversation
+	for i := 0; i < 100; i++ {
+		var err error
+		convs, err = client.Conversations("", true)
+		if err != nil {
+			t.Fatalf("Conversations() failed: %v", err)
+		}
+		if len(convs) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(convs) != 2 {
+		t.Fatalf("Conversations() returned %d conversations, want 2", len(convs))
+	}
+	if convs[0].Account != "carol@example.org" || convs[0].Last.Text != "hi" {
+		t.Errorf("most recent conversation is %+v", convs[0])
+	}
+	if convs[1].Account != "alice@example.com" || len(convs[1].Messages) != 1 ||
+		convs[1].Messages[0].Text != "hello" {
+		t.Errorf("conversation of alice is %+v", convs[1])
+	}
+	convs, err = client.Conversations("Alice@Example.com", false)
+	if err != nil {
+		t.Fatalf("Conversations() failed: %v", err)
+	}
+	if len(convs) != 1 || convs[0].Messages != nil {
+		t.Errorf("Conversations(alice) returned %+v", convs)
+	}
+	sent := f.sessions["alice@example.com"].messages()
+	if len(sent) != 2 || sent[0].To != "bob@example.com" || sent[1].Replace != msg.ID {
+		t.Errorf("session sent %+v", sent)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	_, f, client := startServer(t)
+	events, next, err := client.Events(0, 1)
+	if err != nil {
+		t.Fatalf("Events() failed: %v", err)
+	}
+	if len(events) != 0 || next != 1 {
+		t.Fatalf("Events() returned %v, %d", events, next)
+	}
+	done := make(chan []Event)
+	go func() {
+		events, _, err := client.Events(next, 10)
+		if err != nil {
+			t.Errorf("Events() failed: %v", err)
+		}
+		done <- events
+	}()
+	time.Sleep(50 * time.Millisecond)
+	f.sessions["alice@example.com"].recv <- xmpp.Event{
+		Kind:     xmpp.PresenceEvent,
+		Account:  "alice@example.com",
+		Presence: &xmpp.Presence{From: "bob@example.com", Type: "subscribe"},
+	}
+	events = <-done
+	if len(events) != 1 || events[0].Seq != 1 || events[0].Kind != xmpp.PresenceEvent ||
+		events[0].Presence.Type != "subscribe" {
+		t.Errorf("Events() returned %+v", events)
+	}
+}
+
+func TestSetPresence(t *testing.T) {
+	_, f, client := startServer(t)
+	if err := client.SetPresence("", "away", "gone fishing"); err != nil {
+		t.Fatalf("SetPresence() failed: %v", err)
+	}
+	if err := client.SetPresence("carol@example.org", "dnd", ""); err != nil {
+		t.Fatalf("SetPresence() failed: %v", err)
+	}
+	if p := f.sessions["alice@example.com"].presences; len(p) != 1 || p[0] != "away/gone fishing" {
+		t.Errorf("presences of alice: %v", p)
+	}
+	if p := f.sessions["carol@example.org"].presences; len(p) != 2 || p[1] != "dnd/" {
+		t.Errorf("presences of carol: %v", p)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	_, f, client := startServer(t)
+	account := &config.Account{Username: "alice@example.com"}
+	send := make(chan xmpp.Message)
+	recv := make(chan xmpp.Event)
+	a, err := client.Start(account, send, recv, false)
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond) // wait for first Events call
+	send <- xmpp.Message{To: "bob@example.com", Text: "hello"}
+	for _, username := range []string{"carol@example.org", "alice@example.com"} {
+		f.sessions[username].recv <- xmpp.Event{
+			Kind:    xmpp.MessageEvent,
+			Account: username,
+			Message: &xmpp.Message{From: "bob@example.com", To: username, Text: "hi " + username},
+		}
+	}
+	select {
+	case ev := <-recv:
+		if ev.Message == nil || ev.Message.Text != "hi alice@example.com" {
+			t.Errorf("received %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	a.Close()
+	close(send)
+	var sent []xmpp.Message
+	for i := 0; i < 100 && len(sent) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		sent = f.sessions["alice@example.com"].messages()
+	}
+	if len(sent) != 1 || sent[0].Text != "hello" {
+		t.Errorf("session sent %+v", sent)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	srv, f, client := startServer(t)
+	alice := f.sessions["alice@example.com"]
+	if rooms := alice.rooms(); len(rooms) != 1 ||
+		rooms[0] != "team@conference.example.com/al pw 5" {
+		t.Fatalf("rooms not auto-joined: %v", rooms)
+	}
+	if rooms := f.sessions["carol@example.org"].rooms(); len(rooms) != 0 {
+		t.Errorf("carol joined rooms: %v", rooms)
+	}
+	err := client.JoinRoom("alice@example.com", "team@conference.example.com", "", nil, 0)
+	if err != nil {
+		t.Fatalf("JoinRoom() failed: %v", err)
+	}
+	err = client.JoinRoom("", "ops@conference.example.com", "", nil, -1)
+	if err != nil {
+		t.Fatalf("JoinRoom() failed: %v", err)
+	}
+	srv.mutex.Lock()
+	r := srv.hill.Room("carol@example.org", "ops@conference.example.com")
+	if r == nil || !r.AutoJoin || !r.Bookmarked {
+		t.Errorf("joined room not added to hill: %+v", r)
+	}
+	srv.mutex.Unlock()
+	if err := client.SetSubject("", "ops@conference.example.com", "on call"); err != nil {
+		t.Fatalf("SetSubject() failed: %v", err)
+	}
+	if err := client.ChangeNick("", "ops@conference.example.com", "caro"); err != nil {
+		t.Fatalf("ChangeNick() failed: %v", err)
+	}
+	if err := client.LeaveRoom("", "ops@conference.example.com"); err != nil {
+		t.Fatalf("LeaveRoom() failed: %v", err)
+	}
+	if rooms := alice.rooms(); len(rooms) != 2 || rooms[1] != "team@conference.example.com/al pw 0" {
+		t.Errorf("alice rooms: %v", rooms)
+	}
+	srv.mutex.Lock()
+	if r := srv.hill.Room("carol@example.org", "ops@conference.example.com"); r != nil {
+		t.Errorf("left room not removed from hill: %+v", r)
+	}
+	srv.mutex.Unlock()
+	carol := f.sessions["carol@example.org"]
+	carol.mutex.Lock()
+	want := "[ops@conference.example.com -ops@conference.example.com]"
+	if bookmarked := fmt.Sprint(carol.bookmarked); bookmarked != want {
+		t.Errorf("carol bookmarks: %s, want %s", bookmarked, want)
+	}
+	carol.mutex.Unlock()
+	rooms := []string{
+		"ops@conference.example.com/carol  20",
+		"ops@conference.example.com on call",
+		"ops@conference.example.com nick caro",
+		"-ops@conference.example.com",
+	}
+	if joined := carol.rooms(); fmt.Sprint(joined) != fmt.Sprint(rooms) {
+		t.Errorf("carol rooms: %v, want %v", joined, rooms)
+	}
+	msg := &xmpp.Message{Type: xmpp.Groupchat, To: "ops@conference.example.com", Text: "hi"}
+	if _, err := client.Send("", msg); err == nil {
+		t.Error("Send() to room which cannot be encrypted should fail")
+	}
+	msg.To = "team@conference.example.com"
+	if _, err := client.Send("alice@example.com", msg); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	_, f, client := startServer(t)
+	room := "ops@conference.example.com"
+	if err := client.SetRole("", room, "bob", "none", "spam"); err != nil {
+		t.Fatalf("SetRole() failed: %v", err)
+	}
+	if err := client.SetAffiliation("", room, "bob@example.com", "outcast", ""); err != nil {
+		t.Fatalf("SetAffiliation() failed: %v", err)
+	}
+	if err := client.Invite("", room, "dave@example.com", "", false, nil); err != nil {
+		t.Fatalf("Invite() failed: %v", err)
+	}
+	if err := client.Invite("", room, "dave@example.com", "", true, []byte("pw")); err != nil {
+		t.Fatalf("Invite() failed: %v", err)
+	}
+	info, err := client.RoomInfo("", room)
+	if err != nil {
+		t.Fatalf("RoomInfo() failed: %v", err)
+	}
+	if info.Room != room || !info.MembersOnly || info.Encryptable() {
+		t.Errorf("unexpected room info: %+v", info)
+	}
+	form, err := client.RoomConfig("", room)
+	if err != nil {
+		t.Fatalf("RoomConfig() failed: %v", err)
+	}
+	if form.Title != room {
+		t.Errorf("unexpected form: %+v", form)
+	}
+	if err := client.ConfigureRoom("", room, form.Submit()); err != nil {
+		t.Fatalf("ConfigureRoom() failed: %v", err)
+	}
+	if err := client.ConfigureRoom("", room, nil); err == nil {
+		t.Error("ConfigureRoom() should fail without form")
+	}
+	want := []string{
+		room + " role bob none",
+		room + " affiliation bob@example.com outcast",
+		room + " invite dave@example.com",
+		room + " direct-invite dave@example.com pw",
+		room + " configure submit",
+	}
+	if rooms := f.sessions["carol@example.org"].rooms(); fmt.Sprint(rooms) != fmt.Sprint(want) {
+		t.Errorf("carol rooms: %v, want %v", rooms, want)
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	srv, f, _ := startServerWithBookmarks(t, map[string][]config.Room{
+		"alice@example.com": {{
+			Room:     "ops@conference.example.com",
+			Local:    "alice@example.com",
+			History:  config.DefaultRoomHistory,
+			AutoJoin: true,
+		}},
+	})
+	alice := f.sessions["alice@example.com"]
+	for i := 0; ; i++ {
+		alice.mutex.Lock()
+		bookmarked := fmt.Sprint(alice.bookmarked)
+		alice.mutex.Unlock()
+		if bookmarked == "[team@conference.example.com]" {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("unbookmarked room not published: %s", bookmarked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := "[team@conference.example.com/al pw 5 ops@conference.example.com/alice  20]"
+	if rooms := alice.rooms(); fmt.Sprint(rooms) != want {
+		t.Errorf("alice rooms: %v, want %v", rooms, want)
+	}
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if r := srv.hill.Room("alice@example.com", "team@conference.example.com"); r == nil || !r.Bookmarked {
+		t.Errorf("published room not marked: %+v", r)
+	}
+	if srv.hill.Room("alice@example.com", "ops@conference.example.com") == nil {
+		t.Error("bookmarked room not added")
+	}
+}
+
+func TestRetraction(t *testing.T) {
+	_, f, client := startServer(t)
+	msg, err := client.Send("alice@example.com", &xmpp.Message{To: "bob@example.com", Text: "secret"})
+	if err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if err := client.Retract("alice@example.com", "bob@example.com", msg.ID, false); err != nil {
+		t.Fatalf("Retract() failed: %v", err)
+	}
+	// retraction by contact
+	f.sessions["alice@example.com"].recv <- xmpp.Event{
+		Kind:    xmpp.MessageEvent,
+		Account: "alice@example.com",
+		Message: &xmpp.Message{From: "bob@example.com", ID: "b1", Text: "oops"},
+	}
+	f.sessions["alice@example.com"].recv <- xmpp.Event{
+		Kind:    xmpp.MessageEvent,
+		Account: "alice@example.com",
+		Message: &xmpp.Message{From: "bob@example.com", ID: "b2", Text: "ignore that",
+			ReplyID: "b1", Quote: "oops"},
+	}
+	f.sessions["alice@example.com"].recv <- xmpp.Event{
+		Kind:       xmpp.RetractEvent,
+		Account:    "alice@example.com",
+		Retraction: &xmpp.Retraction{From: "bob@example.com", ID: "b1"},
+	}
+	var msgs []xmpp.Message
+	for i := 0; i < 100; i++ {
+		convs, err := client.Conversations("alice@example.com", true)
+		if err != nil {
+			t.Fatalf("Conversations() failed: %v", err)
+		}
+		if len(convs) == 1 && len(convs[0].Messages) == 3 && convs[0].Messages[1].Retracted {
+			msgs = convs[0].Messages
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(msgs) != 3 {
+		t.Fatal("retracted messages not removed")
+	}
+	for _, msg := range msgs[:2] {
+		if !msg.Retracted || msg.Text != "" {
+			t.Errorf("message not retracted: %+v", msg)
+		}
+	}
+	if reply := msgs[2]; reply.Retracted || reply.Quote != "" {
+		t.Errorf("quote of retracted message kept: %+v", reply)
+	}
+	// moderation
+	room := "ops@conference.example.com"
+	if err := client.Moderate("", room, "s1", "spam"); err != nil {
+		t.Fatalf("Moderate() failed: %v", err)
+	}
+	if err := client.Moderate("", room, "", ""); err == nil {
+		t.Error("Moderate() should fail without message ID")
+	}
+	rooms := f.sessions["alice@example.com"].rooms()
+	if len(rooms) == 0 || rooms[len(rooms)-1] != "bob@example.com retract "+msg.ID+" false" {
+		t.Errorf("alice calls: %v", rooms)
+	}
+	want := []string{room + " moderate s1 spam"}
+	if rooms := f.sessions["carol@example.org"].rooms(); fmt.Sprint(rooms) != fmt.Sprint(want) {
+		t.Errorf("carol calls: %v, want %v", rooms, want)
+	}
+}
+
+func TestReactionsAndReplies(t *testing.T) {
+	_, f, client := startServer(t)
+	if err := client.React("", "bob@example.com", "b1", false, []string{"👍"}); err != nil {
+		t.Fatalf("React() failed: %v", err)
+	}
+	if err := client.React("", "bob@example.com", "", false, nil); err == nil {
+		t.Error("React() should fail without message ID")
+	}
+	want := []string{"bob@example.com react b1 false [👍]"}
+	if calls := f.sessions["carol@example.org"].rooms(); fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("carol calls: %v, want %v", calls, want)
+	}
+	events, _, err := client.Events(1, 1)
+	if err != nil {
+		t.Fatalf("Events() failed: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("no reaction event")
+	}
+	ev := events[len(events)-1]
+	if ev.Kind != xmpp.ReactionEvent || !ev.Reactions.Own || ev.Reactions.ID != "b1" {
+		t.Errorf("unexpected reaction event: %+v", ev)
+	}
+	_, err = client.Send("alice@example.com", &xmpp.Message{To: "bob@example.com",
+		Text: "me too", ReplyTo: "bob@example.com", ReplyID: "b1", Quote: "cake?"})
+	if err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	var sent []xmpp.Message
+	for i := 0; i < 100 && len(sent) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		sent = f.sessions["alice@example.com"].messages()
+	}
+	if len(sent) != 1 || sent[0].ReplyID != "b1" || sent[0].ReplyTo != "bob@example.com" ||
+		sent[0].Quote != "cake?" {
+		t.Errorf("session sent %+v", sent)
+	}
+}
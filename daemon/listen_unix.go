@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listen on the Unix domain socket at path, which is only accessible by the
+// current user.
+func listen(path string) (net.Listener, error) {
+	// the umask prevents a window in which the socket is accessible by others
+	mask := syscall.Umask(0077)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
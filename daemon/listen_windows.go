@@ -0,0 +1,11 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+)
+
+// listen is not supported on Windows.
+func listen(path string) (net.Listener, error) {
+	return nil, errors.New("daemon: not supported on Windows")
+}
@@ -0,0 +1,229 @@
+// Package hook runs the hooks defined in the settings of a hill on XMPP
+// events.
+//
+// Hooks get the event as a single JSON object (see Event), either on stdin
+// of an external command or as the body of a HTTP POST request to a webhook
+// URL. The decrypted message text is only included for hooks which have
+// Content enabled.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/util"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// Event is passed to hooks.
+type Event struct {
+	Event   string    `json:"event"`          // one of config.HookEvents
+	Account string    `json:"account"`        // username of the account
+	From    string    `json:"from,omitempty"` // JID of the sender (or room)
+	Nick    string    `json:"nick,omitempty"` // nickname of the sender in room
+	Text    string    `json:"text,omitempty"` // only passed if Content is enabled
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Runner runs hooks.
+type Runner struct {
+	hooks []config.Hook
+	sem   chan struct{} // limits the number of concurrently running hooks
+	wg    sync.WaitGroup
+	mutex sync.Mutex
+	nicks map[roomKey]string // our nicknames in joined rooms
+}
+
+// roomKey identifies a room joined by an account.
+type roomKey struct {
+	account string
+	room    string
+}
+
+// New returns a new runner for the hooks defined in settings.
+// The hooks are copied.
+func New(settings *config.Settings) *Runner {
+	max := settings.MaxHooks
+	if max <= 0 {
+		max = config.DefaultMaxHooks
+	}
+	return &Runner{
+		hooks: append([]config.Hook(nil), settings.Hooks...),
+		sem:   make(chan struct{}, max),
+		nicks: make(map[roomKey]string),
+	}
+}
+
+// trackNick keeps track of our nickname in the room of occupant o, if it is
+// our own occupant in a room joined by account.
+func (r *Runner) trackNick(account string, o *xmpp.Occupant) {
+	if !o.Self {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := roomKey{account: account, room: o.Room}
+	switch {
+	case o.NewNick != "":
+		r.nicks[key] = o.NewNick
+	case o.Left:
+		delete(r.nicks, key)
+	default:
+		r.nicks[key] = o.Nick
+	}
+}
+
+// mentions reports whether msg received by account mentions us as a whole
+// word: our nickname in rooms and the localpart of account otherwise.
+func (r *Runner) mentions(account string, msg *xmpp.Message) bool {
+	var name string
+	if msg.Type == xmpp.Groupchat {
+		r.mutex.Lock()
+		name = r.nicks[roomKey{account: account, room: msg.From}]
+		r.mutex.Unlock()
+	}
+	if name == "" {
+		j, err := jid.Parse(account)
+		if err != nil {
+			return false
+		}
+		name = j.Local
+	}
+	return util.Mentions(msg.Text, name)
+}
+
+// events returns the hook events for the XMPP event ev.
+func (r *Runner) events(ev *xmpp.Event) []Event {
+	switch ev.Kind {
+	case xmpp.MessageEvent:
+		if ev.Message.Type == xmpp.Groupchat && ev.Message.Delayed {
+			return nil // room history
+		}
+		e := Event{
+			Event:   config.HookMessage,
+			Account: ev.Account,
+			From:    ev.Message.From,
+			Nick:    ev.Message.Nick,
+			Text:    ev.Message.Text,
+			Time:    ev.Message.Time,
+		}
+		if !r.mentions(ev.Account, ev.Message) {
+			return []Event{e}
+		}
+		m := e
+		m.Event = config.HookMention
+		return []Event{e, m}
+	case xmpp.OccupantEvent:
+		r.trackNick(ev.Account, ev.Occupant)
+	case xmpp.PresenceEvent:
+		if ev.Presence.Type == "subscribe" {
+			return []Event{{
+				Event:   config.HookSubscription,
+				Account: ev.Account,
+				From:    ev.Presence.From,
+				Time:    time.Now(),
+			}}
+		}
+	case xmpp.DisconnectEvent:
+		return []Event{{
+			Event:   config.HookDisconnect,
+			Account: ev.Account,
+			Error:   ev.Error,
+			Time:    time.Now(),
+		}}
+	}
+	return nil
+}
+
+// Handle runs all hooks defined for the XMPP event ev in the background.
+// If the maximum number of hooks is already running, the hook is skipped.
+func (r *Runner) Handle(ev xmpp.Event) {
+	for _, e := range r.events(&ev) {
+		for i := range r.hooks {
+			h := &r.hooks[i]
+			if !h.Runs(e.Event) {
+				continue
+			}
+			he := e
+			if !h.Content {
+				he.Text = ""
+			}
+			data, err := json.Marshal(&he)
+			if err != nil {
+				log.Printf("hook %d: %v", i, err)
+				continue
+			}
+			select {
+			case r.sem <- struct{}{}:
+			default:
+				log.Printf("hook %d: too many running hooks, skipping %s event", i, e.Event)
+				continue
+			}
+			r.wg.Add(1)
+			go func(i int, h *config.Hook, data []byte) {
+				defer func() {
+					<-r.sem
+					r.wg.Done()
+				}()
+				if err := run(h, data); err != nil {
+					log.Printf("hook %d: %v", i, err)
+				}
+			}(i, h, data)
+		}
+	}
+}
+
+// Wait until all running hooks have finished.
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+// run hook h with the JSON encoded event data.
+func run(h *config.Hook, data []byte) error {
+	if err := h.Validate(); err != nil {
+		return err // invalid hooks in the hill file are only warned about
+	}
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = config.DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(timeout)*time.Second)
+	defer cancel()
+	if h.URL != "" {
+		req, err := http.NewRequest("POST", h.URL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook returned status %s", resp.Status)
+		}
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: %v", h.Command[0], ctx.Err())
+		}
+		return fmt.Errorf("%s: %v: %s", h.Command[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package hook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// TestHelperProcess is run as hook command by the tests: it copies stdin to
+// the file given in $MOLE_HOOK_OUT or sleeps if $MOLE_HOOK_SLEEP is set.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("MOLE_HOOK_HELPER") != "1" {
+		return
+	}
+	if os.Getenv("MOLE_HOOK_SLEEP") != "" {
+		time.Sleep(time.Minute)
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(os.Getenv("MOLE_HOOK_OUT"), data, 0600); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func helperCommand() []string {
+	return []string{os.Args[0], "-test.run=TestHelperProcess"}
+}
+
+func message(text string) xmpp.Event {
+	return xmpp.Event{
+		Kind:    xmpp.MessageEvent,
+		Account: "alice@example.com",
+		Message: &xmpp.Message{From: "bob@example.com", Text: text},
+	}
+}
+
+func readEvent(t *testing.T, filename string) *Event {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("hook output missing: %v", err)
+	}
+	var e Event
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	return &e
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("MOLE_HOOK_HELPER", "1")
+	defer os.Unsetenv("MOLE_HOOK_HELPER")
+	os.Setenv("MOLE_HOOK_OUT", filepath.Join(dir, "message.json"))
+	r := New(&config.Settings{Hooks: []config.Hook{
+		{Events: []string{config.HookMessage}, Command: helperCommand()},
+	}})
+	r.Handle(message("secret text"))
+	r.Wait()
+	e := readEvent(t, filepath.Join(dir, "message.json"))
+	if e.Event != config.HookMessage || e.From != "bob@example.com" {
+		t.Errorf("hook got event %+v", e)
+	}
+	if e.Text != "" {
+		t.Error("message text passed to hook without Content")
+	}
+	// with content, on mention only
+	os.Setenv("MOLE_HOOK_OUT", filepath.Join(dir, "mention.json"))
+	r = New(&config.Settings{Hooks: []config.Hook{
+		{Events: []string{config.HookMention}, Command: helperCommand(), Content: true},
+	}})
+	r.Handle(message("no mention"))
+	r.Wait()
+	if _, err := os.Stat(filepath.Join(dir, "mention.json")); !os.IsNotExist(err) {
+		t.Fatal("mention hook run for message without mention")
+	}
+	r.Handle(message("hey Alice"))
+	r.Wait()
+	e = readEvent(t, filepath.Join(dir, "mention.json"))
+	if e.Event != config.HookMention || e.Text != "hey Alice" {
+		t.Errorf("hook got event %+v", e)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	r := New(&config.Settings{Hooks: []config.Hook{
+		{Events: []string{config.HookMessage}}, // neither command nor URL
+	}})
+	r.Handle(message("hi"))
+	r.Wait()
+	err := run(&config.Hook{Events: []string{config.HookMessage}}, nil)
+	if err == nil {
+		t.Error("run() should fail for hook without command and URL")
+	}
+}
+
+func TestMentions(t *testing.T) {
+	r := New(&config.Settings{})
+	const room = "ops@conference.example.com"
+	joined := func(o xmpp.Occupant) {
+		o.Room, o.Self = room, true
+		r.Handle(xmpp.Event{Kind: xmpp.OccupantEvent, Account: "alice@example.com", Occupant: &o})
+	}
+	check := func(msg xmpp.Message, mention bool) {
+		t.Helper()
+		if m := r.mentions("alice@example.com", &msg); m != mention {
+			t.Errorf("mentions(%q) = %v", msg.Text, m)
+		}
+	}
+	check(xmpp.Message{From: "bob@example.com", Text: "hey Alice!"}, true)
+	check(xmpp.Message{From: "bob@example.com", Text: "alice's turn"}, true)
+	check(xmpp.Message{From: "bob@example.com", Text: "hey alicex"}, false)
+	joined(xmpp.Occupant{Nick: "al"})
+	check(xmpp.Message{From: room, Type: xmpp.Groupchat, Text: "al: ping"}, true)
+	check(xmpp.Message{From: room, Type: xmpp.Groupchat, Text: "also alice"}, false)
+	joined(xmpp.Occupant{Nick: "al", NewNick: "ally"})
+	check(xmpp.Message{From: room, Type: xmpp.Groupchat, Text: "al: ping"}, false)
+	check(xmpp.Message{From: room, Type: xmpp.Groupchat, Text: "thanks, Ally"}, true)
+}
+
+func TestTimeoutAndConcurrency(t *testing.T) {
+	os.Setenv("MOLE_HOOK_HELPER", "1")
+	defer os.Unsetenv("MOLE_HOOK_HELPER")
+	os.Setenv("MOLE_HOOK_SLEEP", "1")
+	defer os.Unsetenv("MOLE_HOOK_SLEEP")
+	r := New(&config.Settings{
+		Hooks: []config.Hook{
+			{Events: []string{config.HookDisconnect}, Command: helperCommand(), Timeout: 1},
+		},
+		MaxHooks: 1,
+	})
+	start := time.Now()
+	ev := xmpp.Event{Kind: xmpp.DisconnectEvent, Account: "alice@example.com", Error: "EOF"}
+	r.Handle(ev)
+	if len(r.sem) != 1 {
+		t.Fatalf("%d hooks running, want 1", len(r.sem))
+	}
+	r.Handle(ev) // skipped
+	r.Wait()
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("hook was not killed after timeout (%v)", d)
+	}
+	if len(r.sem) != 0 {
+		t.Errorf("%d hooks still running", len(r.sem))
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	events := make(chan Event, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var e Event
+		if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+			t.Errorf("webhook got invalid JSON: %v", err)
+		}
+		events <- e
+	}))
+	defer ts.Close()
+	r := New(&config.Settings{Hooks: []config.Hook{
+		{Events: []string{config.HookSubscription}, URL: ts.URL},
+	}})
+	r.Handle(xmpp.Event{
+		Kind:     xmpp.PresenceEvent,
+		Account:  "alice@example.com",
+		Presence: &xmpp.Presence{From: "bob@example.com", Type: "subscribe"},
+	})
+	r.Handle(xmpp.Event{
+		Kind:     xmpp.PresenceEvent,
+		Account:  "alice@example.com",
+		Presence: &xmpp.Presence{From: "bob@example.com", Show: "away"},
+	})
+	r.Wait()
+	close(events)
+	var n int
+	for e := range events {
+		n++
+		if e.Event != config.HookSubscription || e.From != "bob@example.com" {
+			t.Errorf("webhook got event %+v", e)
+		}
+	}
+	if n != 1 {
+		t.Errorf("webhook called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,207 @@
+// Package jid implements parsing and normalization of XMPP addresses (JIDs)
+// according to RFC 7622.
+//
+// The localpart is case folded to lower case and the domainpart is converted
+// to its ASCII form (IDNA A-labels), so normalized JIDs can be compared with
+// ==. Unicode normalization (NFC) is not performed.
+package jid
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPartLen is the maximum length of each part of a JID in bytes.
+const maxPartLen = 1023
+
+// maxLabelLen is the maximum length of a domain label in bytes.
+const maxLabelLen = 63
+
+// JID is a parsed and normalized XMPP address.
+type JID struct {
+	Local    string // localpart (optional)
+	Domain   string // domainpart
+	Resource string // resourcepart (optional)
+}
+
+// Parse s as a JID and normalize it.
+func Parse(s string) (JID, error) {
+	var j JID
+	if !utf8.ValidString(s) {
+		return j, errors.New("jid: not valid UTF-8")
+	}
+	rest := s
+	hasResource := false
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		j.Resource = rest[i+1:]
+		rest = rest[:i]
+		hasResource = true
+	}
+	hasLocal := false
+	if i := strings.IndexByte(rest, '@'); i >= 0 {
+		j.Local = rest[:i]
+		rest = rest[i+1:]
+		hasLocal = true
+	}
+	j.Domain = rest
+	var err error
+	if hasLocal {
+		if j.Local, err = normalizeLocal(j.Local); err != nil {
+			return JID{}, fmt.Errorf("jid: '%s': %v", s, err)
+		}
+	}
+	if j.Domain, err = normalizeDomain(j.Domain); err != nil {
+		return JID{}, fmt.Errorf("jid: '%s': %v", s, err)
+	}
+	if hasResource {
+		if j.Resource, err = normalizeResource(j.Resource); err != nil {
+			return JID{}, fmt.Errorf("jid: '%s': %v", s, err)
+		}
+	}
+	return j, nil
+}
+
+// MustParse is like Parse but panics if s cannot be parsed.
+func MustParse(s string) JID {
+	j, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return j
+}
+
+// Normalize parses s as a JID and returns its normalized string
+// representation.
+func Normalize(s string) (string, error) {
+	j, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return j.String(), nil
+}
+
+// String returns the string representation of j.
+func (j JID) String() string {
+	s := j.Domain
+	if j.Local != "" {
+		s = j.Local + "@" + s
+	}
+	if j.Resource != "" {
+		s += "/" + j.Resource
+	}
+	return s
+}
+
+// Bare returns j without resource.
+func (j JID) Bare() JID {
+	return JID{Local: j.Local, Domain: j.Domain}
+}
+
+// IsBare reports whether j has no resource.
+func (j JID) IsBare() bool {
+	return j.Resource == ""
+}
+
+// checkPart checks the length of a JID part and rejects control characters.
+func checkPart(name, part string) error {
+	if part == "" {
+		return fmt.Errorf("%s is empty", name)
+	}
+	if len(part) > maxPartLen {
+		return fmt.Errorf("%s is longer than %d bytes", name, maxPartLen)
+	}
+	for _, r := range part {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return fmt.Errorf("%s contains control character %U", name, r)
+		}
+	}
+	return nil
+}
+
+// normalizeLocal checks and case folds a localpart (RFC 7622 section 3.3).
+func normalizeLocal(local string) (string, error) {
+	if err := checkPart("localpart", local); err != nil {
+		return "", err
+	}
+	for _, r := range local {
+		if unicode.IsSpace(r) || strings.ContainsRune(`"&'/:<>@`, r) {
+			return "", fmt.Errorf("localpart contains forbidden character %q", r)
+		}
+	}
+	local = strings.ToLower(local)
+	if len(local) > maxPartLen {
+		return "", fmt.Errorf("localpart is longer than %d bytes", maxPartLen)
+	}
+	return local, nil
+}
+
+// normalizeDomain checks a domainpart and converts it to lower case ASCII
+// (RFC 7622 section 3.2).
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if err := checkPart("domainpart", domain); err != nil {
+		return "", err
+	}
+	// IP literals
+	if strings.HasPrefix(domain, "[") {
+		if !strings.HasSuffix(domain, "]") || strings.ContainsAny(domain[1:len(domain)-1], "[]") {
+			return "", errors.New("domainpart is an invalid IP literal")
+		}
+		return strings.ToLower(domain), nil
+	}
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", errors.New("domainpart contains empty label")
+		}
+		for _, r := range label {
+			if unicode.IsSpace(r) || strings.ContainsRune(`"&'/:<>@[]\`, r) {
+				return "", fmt.Errorf("domainpart contains forbidden character %q", r)
+			}
+		}
+		label = strings.ToLower(label)
+		if !isASCII(label) {
+			encoded, err := punycode(label)
+			if err != nil {
+				return "", err
+			}
+			label = "xn--" + encoded
+		}
+		if len(label) > maxLabelLen {
+			return "", fmt.Errorf("domain label is longer than %d bytes", maxLabelLen)
+		}
+		labels[i] = label
+	}
+	domain = strings.Join(labels, ".")
+	if len(domain) > maxPartLen {
+		return "", fmt.Errorf("domainpart is longer than %d bytes", maxPartLen)
+	}
+	return domain, nil
+}
+
+// normalizeResource checks a resourcepart (RFC 7622 section 3.4). Non-ASCII
+// spaces are mapped to ASCII spaces, case is preserved.
+func normalizeResource(resource string) (string, error) {
+	if err := checkPart("resourcepart", resource); err != nil {
+		return "", err
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, resource), nil
+}
+
+// isASCII reports whether s contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package jid
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"juliet@example.com", "juliet@example.com"},
+		{"Juliet@Example.COM", "juliet@example.com"},
+		{"juliet@example.com.", "juliet@example.com"},
+		{"juliet@example.com/Foo Bar", "juliet@example.com/Foo Bar"},
+		{"juliet@example.com/foo@bar/baz", "juliet@example.com/foo@bar/baz"},
+		{"example.com", "example.com"},
+		{"example.com/resource", "example.com/resource"},
+		{"ÖSTERREICH@bücher.example", "österreich@xn--bcher-kva.example"},
+		{"user@[::1]", "user@[::1]"},
+		{"user@例え.テスト", "user@xn--r8jz45g.xn--zckzah"},
+	}
+	for _, test := range tests {
+		j, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.in, err)
+			continue
+		}
+		if j.String() != test.out {
+			t.Errorf("Parse(%q) = %q, expected %q", test.in, j.String(), test.out)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"@example.com",
+		"juliet@",
+		"juliet@example.com/",
+		"jul iet@example.com",
+		"jul<iet@example.com",
+		"juliet@exa mple.com",
+		"juliet@example..com",
+		"juliet@example.com/\x00",
+		"juliet@[::1",
+		"\xff@example.com",
+	} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) should fail", in)
+		}
+	}
+}
+
+func TestBare(t *testing.T) {
+	j := MustParse("juliet@example.com/balcony")
+	if j.IsBare() {
+		t.Error("IsBare() should be false")
+	}
+	if j.Bare().String() != "juliet@example.com" {
+		t.Errorf("Bare() = %q", j.Bare())
+	}
+}
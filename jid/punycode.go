@@ -0,0 +1,109 @@
+package jid
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Punycode parameters, see RFC 3492 section 5.
+const (
+	base        = 36
+	tMin        = 1
+	tMax        = 26
+	skew        = 38
+	damp        = 700
+	initialBias = 72
+	initialN    = 128
+)
+
+var errOverflow = errors.New("jid: punycode overflow")
+
+// adapt the bias, see RFC 3492 section 6.1.
+func adapt(delta, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((base-tMin)*tMax)/2 {
+		delta /= base - tMin
+		k += base
+	}
+	return k + (base-tMin+1)*delta/(delta+skew)
+}
+
+// encodeDigit encodes the punycode digit d (0 <= d < base).
+func encodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// punycode encodes the Unicode string s with the punycode algorithm, see RFC
+// 3492 section 6.3.
+func punycode(s string) (string, error) {
+	var out strings.Builder
+	runes := []rune(s)
+	b := 0
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out.WriteByte(byte(r))
+			b++
+		}
+	}
+	h := b
+	if b > 0 {
+		out.WriteByte('-')
+	}
+	n := initialN
+	delta := 0
+	bias := initialBias
+	for h < len(runes) {
+		m := int(^uint(0) >> 1)
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		if (m - n) > (int(^uint(0)>>1)-delta)/(h+1) {
+			return "", errOverflow
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+				if delta < 0 {
+					return "", errOverflow
+				}
+			}
+			if int(r) == n {
+				q := delta
+				for k := base; ; k += base {
+					t := k - bias
+					if t < tMin {
+						t = tMin
+					} else if t > tMax {
+						t = tMax
+					}
+					if q < t {
+						break
+					}
+					out.WriteByte(encodeDigit(t + (q-t)%(base-t)))
+					q = (q - t) / (base - t)
+				}
+				out.WriteByte(encodeDigit(q))
+				bias = adapt(delta, h+1, h == b)
+				delta = 0
+				h++
+			}
+		}
+		delta++
+		n++
+	}
+	return out.String(), nil
+}
@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/frankbraun/codechain/util/home"
-	"github.com/frankbraun/codechain/util/lockfile"
 	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/command"
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/daemon"
+	"github.com/frankbraun/mole/storage"
 	"github.com/frankbraun/mole/ui"
 	"github.com/frankbraun/mole/util"
 	"github.com/mattn/go-xmpp"
@@ -43,21 +47,51 @@ func prepareHillDir(hillFile string) error {
 	return nil
 }
 
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: %s [options]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s [options] [command [arguments]]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Without command the terminal user interface is started.\n")
+	fmt.Fprintf(os.Stderr, "Commands (see '%s command -h' for details):\n", os.Args[0])
+	for _, name := range command.Names() {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	fmt.Fprintf(os.Stderr, "The hill passphrase is read from the terminal or from $%s.\n",
+		command.PassphraseEnv)
+	fmt.Fprintf(os.Stderr, "Options:\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
 func moleMain() error {
 	// option parsing
-	dump := flag.Bool("d", false, "dump hill file after decryption")
+	attach := flag.Bool("a", false, "attach user interface to running daemon")
+	dump := flag.Bool("d", false, "dump hill file after decryption (secrets are redacted)")
 	hillFile := flag.String("f", defaultHillFile, "set hill file")
 	logFile := flag.String("l", "", "set log file (for debugging only, might leak sensitive data!)")
+	section := flag.String("s", "", "dump only section of hill file ("+strings.Join(config.Sections, ", ")+")")
+	unredacted := flag.Bool("u", false, "dump secrets unredacted (asks for confirmation)")
 	xmppDebug := flag.Bool("x", false, "enable XMPP debugging")
+	flag.Usage = usage
 	flag.Parse()
-	if flag.NArg() != 0 {
-		usage()
+	if *attach && (*dump || flag.NArg() != 0) {
+		return fmt.Errorf("option -a cannot be combined with option -d or a command")
+	}
+	if *dump && flag.NArg() != 0 {
+		return fmt.Errorf("option -d cannot be combined with a command")
+	}
+	if (*section != "" || *unredacted) && !*dump {
+		return fmt.Errorf("options -s and -u require option -d")
+	}
+	if *section != "" && !contains(config.Sections, *section) {
+		return fmt.Errorf("option -s: unknown section '%s'", *section)
 	}
 	// initialize logging framework
 	if *logFile != "" {
@@ -80,19 +114,48 @@ func moleMain() error {
 	if err := prepareHillDir(*hillFile); err != nil {
 		return err
 	}
-	// create lock anchored at .hill file
-	lock, err := lockfile.Create(*hillFile)
-	if err != nil {
+	socket := *hillFile + ".sock"
+	// attach to running daemon (which holds the lock of the .hill file)
+	if *attach {
+		client, err := daemon.Dial(socket)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		return ui.Attach(client)
+	}
+	// lock .hill file
+	backend := storage.NewFileBackend(*hillFile)
+	if err := backend.Lock(); err != nil {
 		return err
 	}
-	defer lock.Release()
+	defer backend.Unlock()
+	// run non-interactive command
+	if flag.NArg() != 0 {
+		opts := &command.Options{
+			Backend:   backend,
+			Socket:    socket,
+			XMPPDebug: *xmppDebug,
+		}
+		return command.Run(os.Args[0], opts, flag.Args())
+	}
 	// start UI event loop
-	return ui.Run(*hillFile, *dump, *xmppDebug)
+	var dumpOpts *ui.DumpOptions
+	if *dump {
+		dumpOpts = &ui.DumpOptions{
+			Section:    *section,
+			Unredacted: *unredacted,
+		}
+	}
+	return ui.Run(backend, dumpOpts, *xmppDebug)
 }
 
 func main() {
 	// work around defer not working after os.Exit()
 	if err := moleMain(); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
 		log.Printf("fatal(): %v", err)
 		util.Fatal(err)
 	}
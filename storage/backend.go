@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/frankbraun/codechain/util/file"
+	"github.com/frankbraun/codechain/util/lockfile"
+)
+
+// Backend defines the interface of a storage backend which holds the
+// encrypted data of a State.
+type Backend interface {
+	// Name returns a human readable name of the backend (e.g., a filename).
+	Name() string
+
+	// Exists reports whether the backend contains data already.
+	Exists() (bool, error)
+
+	// Read returns the entire (encrypted) data stored in the backend.
+	Read() ([]byte, error)
+
+	// Replace atomically replaces the data stored in the backend.
+	Replace(data []byte) error
+
+	// Lock the backend for exclusive use by the calling process.
+	Lock() error
+
+	// Unlock the backend again.
+	Unlock() error
+}
+
+// FileBackend is a Backend which stores data in a single file.
+type FileBackend struct {
+	filename string
+	lock     lockfile.Lock
+}
+
+// NewFileBackend returns a new FileBackend for filename.
+func NewFileBackend(filename string) *FileBackend {
+	return &FileBackend{filename: filename}
+}
+
+// Name returns the filename of the backend.
+func (b *FileBackend) Name() string {
+	return b.filename
+}
+
+// Exists reports whether the backend file exists.
+func (b *FileBackend) Exists() (bool, error) {
+	return file.Exists(b.filename)
+}
+
+// Read the backend file.
+func (b *FileBackend) Read() ([]byte, error) {
+	return ioutil.ReadFile(b.filename)
+}
+
+// Replace the backend file by writing data to a temporary file which is then
+// moved in place.
+func (b *FileBackend) Replace(data []byte) error {
+	tmpfile := b.filename + ".new"
+	os.Remove(tmpfile) // ignore error
+	// open file
+	fp, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+	// write data
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return err
+	}
+	// write temp. file
+	if err := fp.Close(); err != nil {
+		os.Remove(fp.Name())
+		return err
+	}
+	// move temp. file in place
+	return os.Rename(tmpfile, b.filename)
+}
+
+// Lock the backend file with a lockfile anchored at it.
+func (b *FileBackend) Lock() error {
+	lock, err := lockfile.Create(b.filename)
+	if err != nil {
+		return err
+	}
+	b.lock = lock
+	return nil
+}
+
+// Unlock the backend file by releasing the lockfile.
+func (b *FileBackend) Unlock() error {
+	return b.lock.Release()
+}
+
+// MemoryBackend is a Backend which keeps data in memory (mainly for testing).
+type MemoryBackend struct {
+	mutex  sync.Mutex
+	name   string
+	data   []byte
+	locked bool
+}
+
+// NewMemoryBackend returns a new empty MemoryBackend with the given name.
+func NewMemoryBackend(name string) *MemoryBackend {
+	return &MemoryBackend{name: name}
+}
+
+// Name returns the name of the backend.
+func (b *MemoryBackend) Name() string {
+	return b.name
+}
+
+// Exists reports whether data has been written to the backend.
+func (b *MemoryBackend) Exists() (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.data != nil, nil
+}
+
+// Read returns a copy of the data stored in the backend.
+func (b *MemoryBackend) Read() ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.data == nil {
+		return nil, fmt.Errorf("storage: memory backend '%s' is empty", b.name)
+	}
+	return append([]byte{}, b.data...), nil
+}
+
+// Replace the data stored in the backend with a copy of data.
+func (b *MemoryBackend) Replace(data []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.data = append([]byte{}, data...)
+	return nil
+}
+
+// Lock the backend.
+func (b *MemoryBackend) Lock() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.locked {
+		return errors.New("storage: memory backend is locked already")
+	}
+	b.locked = true
+	return nil
+}
+
+// Unlock the backend.
+func (b *MemoryBackend) Unlock() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.locked = false
+	return nil
+}
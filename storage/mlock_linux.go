@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"syscall"
+)
+
+// mlock locks b in memory to prevent it from being swapped to disk.
+func mlock(b []byte) error {
+	return syscall.Mlock(b)
+}
+
+// munlock unlocks b again.
+func munlock(b []byte) {
+	syscall.Munlock(b) // ignore error
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package storage
+
+// mlock is not supported on this platform.
+func mlock(b []byte) error {
+	return nil
+}
+
+// munlock is not supported on this platform.
+func munlock(b []byte) {}
@@ -1,14 +1,16 @@
-// Package storage provides encrypted file storage.
+// Package storage provides encrypted storage on pluggable backends.
 package storage
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 
-	"github.com/frankbraun/codechain/util/file"
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/util"
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/nacl/secretbox"
 )
@@ -16,48 +18,63 @@ import (
 // Version of storage format.
 const Version = 0x01
 
+var (
+	// ErrTruncated is returned if a storage file is too short to contain a
+	// complete header and an authenticated payload.
+	ErrTruncated = errors.New("storage: file is truncated")
+
+	// ErrUnsupportedVersion is returned if a storage file has an unknown
+	// version byte.
+	ErrUnsupportedVersion = errors.New("storage: unsupported file version")
+
+	// ErrAuthentication is returned if the payload of a storage file cannot
+	// be decrypted, either because the passphrase is wrong or because the
+	// payload has been corrupted.
+	ErrAuthentication = errors.New("storage: cannot decrypt (wrong passphrase or corrupted file)")
+)
+
 // State of storage (for later save operations).
 type State struct {
-	filename string   // original filename
-	salt     [32]byte // salt for KDF
-	nonce    [24]byte // nonce for secretbox
-	key      [32]byte // derived key
+	backend Backend  // storage backend
+	salt    [32]byte // salt for KDF
+	nonce   [24]byte // nonce for secretbox
+	key     [32]byte // derived key (locked in memory, if possible)
 }
 
-func (s *State) save(w io.Writer, data []byte) error {
-	// encrypt data
-	enc := secretbox.Seal(nil, data, &s.nonce, &s.key)
-	// write version byte
-	if _, err := w.Write([]byte{Version}); err != nil {
-		return err
+// deriveKey derives the key of s from passphrase and locks it in memory.
+func (s *State) deriveKey(passphrase []byte) {
+	key := argon2.IDKey(passphrase, s.salt[:], 1, 64*1024, 4, 32)
+	copy(s.key[:], key)
+	util.Wipe(key)
+	if err := mlock(s.key[:]); err != nil {
+		log.Printf("storage: cannot lock key in memory: %v", err)
 	}
+}
+
+// Wipe the derived key of s from memory. s cannot be used afterwards.
+func (s *State) Wipe() {
+	util.Wipe(s.key[:])
+	munlock(s.key[:])
+	s.backend = nil
+}
+
+// seal encrypts data and returns it together with the storage header.
+func (s *State) seal(data []byte) []byte {
+	// write version byte
+	buf := []byte{Version}
 	// write salt
-	if _, err := w.Write(s.salt[:]); err != nil {
-		return err
-	}
+	buf = append(buf, s.salt[:]...)
 	// write nonce
-	if _, err := w.Write(s.nonce[:]); err != nil {
-		return err
-	}
+	buf = append(buf, s.nonce[:]...)
 	// write encrypted data
-	if _, err := w.Write(enc); err != nil {
-		return err
-	}
-	return nil
+	return secretbox.Seal(buf, data, &s.nonce, &s.key)
 }
 
-// Create storage file and write encrypte data to it.
-func Create(filename, passphrase string, data []byte) (*State, error) {
-	// make sure keyfile does not exist already
-	exists, err := file.Exists(filename)
-	if err != nil {
-		return nil, err
-	}
-	if exists {
-		return nil, fmt.Errorf("storage: file '%s' exists already", filename)
-	}
+// newState returns a new State for backend with random salt and nonce and a
+// key derived from passphrase.
+func newState(backend Backend, passphrase []byte) (*State, error) {
 	// generate salt
-	s := &State{filename: filename}
+	s := &State{backend: backend}
 	if _, err := io.ReadFull(rand.Reader, s.salt[:]); err != nil {
 		return nil, err
 	}
@@ -66,105 +83,116 @@ func Create(filename, passphrase string, data []byte) (*State, error) {
 		return nil, err
 	}
 	// compute derived key from passphrase
-	key := argon2.IDKey([]byte(passphrase), s.salt[:], 1, 64*1024, 4, 32)
-	copy(s.key[:], key)
-	// open file
-	fp, err := os.Create(s.filename)
+	s.deriveKey(passphrase)
+	return s, nil
+}
+
+// Create storage in backend and write encrypted data to it.
+func Create(backend Backend, passphrase []byte, data []byte) (*State, error) {
+	// make sure backend does not contain data already
+	exists, err := backend.Exists()
 	if err != nil {
 		return nil, err
 	}
-	// sava encrypted data
-	if err := s.save(fp, data); err != nil {
-		fp.Close()
-		os.Remove(fp.Name())
+	if exists {
+		return nil, fmt.Errorf("storage: '%s' exists already", backend.Name())
+	}
+	s, err := newState(backend, passphrase)
+	if err != nil {
 		return nil, err
 	}
-	if err := fp.Close(); err != nil {
-		os.Remove(fp.Name())
+	// save encrypted data
+	if err := backend.Replace(s.seal(data)); err != nil {
+		s.Wipe()
 		return nil, err
 	}
 	return s, nil
 }
 
-// Open encrypted file and return decrypted data.
-func Open(filename, passphrase string) (*State, []byte, error) {
-	// open encrypted file
-	fp, err := os.Open(filename)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer fp.Close()
-	s := &State{filename: filename}
+// parse the header of encrypted storage data read from r into s and return
+// the remaining encrypted payload.
+func (s *State) parse(r io.Reader) ([]byte, error) {
 	// read version byte
 	var version [1]byte
-	if _, err := fp.Read(version[:]); err != nil {
-		return nil, nil, err
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return nil, readErr(err)
 	}
 	if version[0] != Version {
-		return nil, nil,
-			fmt.Errorf("storage: read version %d incompatible with expected version %d",
-				version[0], Version)
+		return nil, ErrUnsupportedVersion
 	}
 	// read salt
-	if _, err := fp.Read(s.salt[:]); err != nil {
-		return nil, nil, err
+	if _, err := io.ReadFull(r, s.salt[:]); err != nil {
+		return nil, readErr(err)
 	}
 	// read nonce
-	if _, err := fp.Read(s.nonce[:]); err != nil {
-		return nil, nil, err
+	if _, err := io.ReadFull(r, s.nonce[:]); err != nil {
+		return nil, readErr(err)
 	}
-	// derive key
-	key := argon2.IDKey([]byte(passphrase), s.salt[:], 1, 64*1024, 4, 32)
-	copy(s.key[:], key)
 	// read encrypted data
-	enc, err := ioutil.ReadAll(fp)
+	enc, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc) < secretbox.Overhead {
+		return nil, ErrTruncated
+	}
+	return enc, nil
+}
+
+// readErr maps short reads to ErrTruncated.
+func readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// Open encrypted storage in backend and return decrypted data.
+//
+// If the storage is too short to contain a valid header and payload
+// ErrTruncated is returned, if it has an unknown version byte
+// ErrUnsupportedVersion, and if decryption fails (wrong passphrase or
+// corrupted payload) ErrAuthentication.
+func Open(backend Backend, passphrase []byte) (*State, []byte, error) {
+	// read encrypted data
+	buf, err := backend.Read()
 	if err != nil {
 		return nil, nil, err
 	}
+	s := &State{backend: backend}
+	// parse header
+	enc, err := s.parse(bytes.NewReader(buf))
+	if err != nil {
+		return nil, nil, err
+	}
+	// derive key
+	s.deriveKey(passphrase)
 	// decrypt data
 	data, verify := secretbox.Open(nil, enc, &s.nonce, &s.key)
 	if !verify {
-		return nil, nil, fmt.Errorf("storage: cannot decrypt '%s'", filename)
+		s.Wipe()
+		return nil, nil, ErrAuthentication
 	}
 	return s, data, nil
 }
 
 // Save new data, overwriting old!
 func (s *State) Save(data []byte) error {
-	tmpfile := s.filename + ".new"
-	os.Remove(tmpfile) // ignore error
-	// open file
-	fp, err := os.Create(tmpfile)
-	if err != nil {
-		return err
-	}
-	// sava encrypted data
-	if err := s.save(fp, data); err != nil {
-		fp.Close()
-		os.Remove(fp.Name())
-		return err
-	}
-	// write temp. file
-	if err := fp.Close(); err != nil {
-		os.Remove(fp.Name())
-		return err
-	}
-	// move temp. file in place
-	return os.Rename(tmpfile, s.filename)
+	return s.backend.Replace(s.seal(data))
 }
 
-// Rekey file.
-func Rekey(filename, oldPassphrase, newPassphrase string) error {
-	_, data, err := Open(filename, oldPassphrase)
+// Rekey storage in backend.
+func Rekey(backend Backend, oldPassphrase, newPassphrase []byte) error {
+	old, data, err := Open(backend, oldPassphrase)
 	if err != nil {
 		return err
 	}
-	tmpfile := filename + ".new"
-	os.Remove(tmpfile) // ignore error
-	_, err = Create(tmpfile, newPassphrase, data)
+	old.Wipe()
+	defer util.Wipe(data)
+	s, err := newState(backend, newPassphrase)
 	if err != nil {
 		return err
 	}
-	// move temp. file in place
-	return os.Rename(tmpfile, filename)
+	defer s.Wipe()
+	return backend.Replace(s.seal(data))
 }
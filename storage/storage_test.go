@@ -8,14 +8,11 @@ import (
 	"testing"
 )
 
-const (
-	passphrase    = "Staatsgeheimnis"
-	newPassphrase = "Nur für den Dienstgebrauch"
-)
-
 var (
-	data   = []byte("cleartext")
-	update = []byte("updated cleartext")
+	passphrase    = []byte("Staatsgeheimnis")
+	newPassphrase = []byte("Nur für den Dienstgebrauch")
+	data          = []byte("cleartext")
+	update        = []byte("updated cleartext")
 )
 
 func TestCreateOpenEmpty(t *testing.T) {
@@ -25,11 +22,11 @@ func TestCreateOpenEmpty(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 	filename := filepath.Join(tmpdir, "storage_test")
-	_, err = Create(filename, passphrase, nil)
+	_, err = Create(NewFileBackend(filename), passphrase, nil)
 	if err != nil {
 		t.Fatalf("Create() failed: %v", err)
 	}
-	_, _, err = Open(filename, passphrase)
+	_, _, err = Open(NewFileBackend(filename), passphrase)
 	if err != nil {
 		t.Fatalf("Open() failed: %v", err)
 	}
@@ -42,11 +39,11 @@ func TestCreateOpenData(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 	filename := filepath.Join(tmpdir, "storage_test")
-	_, err = Create(filename, passphrase, data)
+	_, err = Create(NewFileBackend(filename), passphrase, data)
 	if err != nil {
 		t.Fatalf("Create() failed: %v", err)
 	}
-	_, out, err := Open(filename, passphrase)
+	_, out, err := Open(NewFileBackend(filename), passphrase)
 	if err != nil {
 		t.Fatalf("Open() failed: %v", err)
 	}
@@ -62,7 +59,7 @@ func TestCreateSaveOpen(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 	filename := filepath.Join(tmpdir, "storage_test")
-	s, err := Create(filename, passphrase, data)
+	s, err := Create(NewFileBackend(filename), passphrase, data)
 	if err != nil {
 		t.Fatalf("Create() failed: %v", err)
 	}
@@ -70,7 +67,7 @@ func TestCreateSaveOpen(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Save() failed: %v", err)
 	}
-	_, out, err := Open(filename, passphrase)
+	_, out, err := Open(NewFileBackend(filename), passphrase)
 	if err != nil {
 		t.Fatalf("Open() failed: %v", err)
 	}
@@ -86,19 +83,19 @@ func TestCreateRekeyOpen(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 	filename := filepath.Join(tmpdir, "storage_test")
-	_, err = Create(filename, passphrase, data)
+	_, err = Create(NewFileBackend(filename), passphrase, data)
 	if err != nil {
 		t.Fatalf("Create() failed: %v", err)
 	}
-	err = Rekey(filename, passphrase, newPassphrase)
+	err = Rekey(NewFileBackend(filename), passphrase, newPassphrase)
 	if err != nil {
 		t.Fatalf("Save() failed: %v", err)
 	}
-	_, _, err = Open(filename, passphrase)
+	_, _, err = Open(NewFileBackend(filename), passphrase)
 	if err == nil {
 		t.Error("Open() should fail")
 	}
-	_, out, err := Open(filename, newPassphrase)
+	_, out, err := Open(NewFileBackend(filename), newPassphrase)
 	if err != nil {
 		t.Fatalf("Open() failed: %v", err)
 	}
@@ -106,3 +103,110 @@ func TestCreateRekeyOpen(t *testing.T) {
 		t.Error("out != data")
 	}
 }
+
+func TestOpenErrors(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "storage_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := filepath.Join(tmpdir, "storage_test")
+	_, err = Create(NewFileBackend(filename), passphrase, data)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	valid, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	corrupted := append([]byte(nil), valid...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	badVersion := append([]byte(nil), valid...)
+	badVersion[0] = Version + 1
+	tests := []struct {
+		name       string
+		content    []byte
+		passphrase []byte
+		err        error
+	}{
+		{"empty", nil, passphrase, ErrTruncated},
+		{"version only", valid[:1], passphrase, ErrTruncated},
+		{"short salt", valid[:16], passphrase, ErrTruncated},
+		{"short nonce", valid[:1+32+12], passphrase, ErrTruncated},
+		{"header only", valid[:1+32+24], passphrase, ErrTruncated},
+		{"short payload", valid[:len(valid)-len(data)-1], passphrase, ErrTruncated},
+		{"unsupported version", badVersion, passphrase, ErrUnsupportedVersion},
+		{"wrong passphrase", valid, newPassphrase, ErrAuthentication},
+		{"corrupted payload", corrupted, passphrase, ErrAuthentication},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filename, test.content, 0600); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		_, _, err := Open(NewFileBackend(filename), test.passphrase)
+		if err != test.err {
+			t.Errorf("%s: Open() returned %v, expected %v", test.name, err, test.err)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{Version})
+	f.Add(make([]byte, 1+32+24+16))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		var s State
+		enc, err := s.parse(bytes.NewReader(in))
+		if err != nil {
+			if err != ErrTruncated && err != ErrUnsupportedVersion {
+				t.Fatalf("parse() returned unexpected error: %v", err)
+			}
+			return
+		}
+		if in[0] != Version {
+			t.Fatalf("parse() accepted version %d", in[0])
+		}
+		if !bytes.Equal(s.salt[:], in[1:33]) {
+			t.Fatal("parse() returned wrong salt")
+		}
+		if !bytes.Equal(s.nonce[:], in[33:57]) {
+			t.Fatal("parse() returned wrong nonce")
+		}
+		if !bytes.Equal(enc, in[57:]) {
+			t.Fatal("parse() returned wrong payload")
+		}
+	})
+}
+
+func TestMemoryBackend(t *testing.T) {
+	backend := NewMemoryBackend("storage_test")
+	if err := backend.Lock(); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := backend.Lock(); err == nil {
+		t.Error("second Lock() should fail")
+	}
+	s, err := Create(backend, passphrase, data)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if _, err := Create(backend, passphrase, data); err == nil {
+		t.Error("second Create() should fail")
+	}
+	if err := s.Save(update); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if err := Rekey(backend, passphrase, newPassphrase); err != nil {
+		t.Fatalf("Rekey() failed: %v", err)
+	}
+	_, out, err := Open(backend, newPassphrase)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if !bytes.Equal(out, update) {
+		t.Error("out != update")
+	}
+	if err := backend.Unlock(); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+}
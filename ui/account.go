@@ -5,23 +5,26 @@ import (
 
 	"github.com/frankbraun/codechain/util/log"
 	"github.com/frankbraun/mole/config"
-	"github.com/frankbraun/mole/xmpp"
 	"github.com/rivo/tview"
 )
 
-func (s *state) accountAdd(xmppDebug bool) {
+func (s *state) accountAdd() {
 	log.Println("accountAdd()")
-	var account config.Account
+	var (
+		account config.Account
+		contact string
+	)
 	form := tview.NewForm().
 		AddInputField("Username", "user@example.com", 0, nil, func(text string) {
 			account.Username = text
 		}).
 		// TODO: make into password fix (after pasting problem has been fixed)
 		AddInputField("Password", "", 0, nil, func(text string) {
-			account.Password = text
+			account.Password.Wipe()
+			account.Password = config.Secret(text)
 		}).
 		AddInputField("Contact", "contact@example.com", 0, nil, func(text string) {
-			account.Contact = text
+			contact = text
 		})
 
 	formFrame := tview.NewFrame(form).SetBorders(0, 1, 0, 0, 0, 0)
@@ -30,25 +33,35 @@ func (s *state) accountAdd(xmppDebug bool) {
 	formFrame.AddText("", false, tview.AlignLeft,
 		tview.Styles.SecondaryTextColor)
 
+	showError := func(err error) {
+		formFrame.Clear()
+		formFrame.AddText(mole, true, tview.AlignCenter,
+			tview.Styles.TertiaryTextColor)
+		log.Println(err)
+		formFrame.AddText(err.Error(), false,
+			tview.AlignLeft, tview.Styles.SecondaryTextColor)
+		s.app.Draw()
+	}
+
 	form.AddButton("Save", func() {
-		// TODO: check account.Username
-		if account.Password == "" {
-			formFrame.Clear()
-			formFrame.AddText(mole, true, tview.AlignCenter,
-				tview.Styles.TertiaryTextColor)
-			log.Println("passphrase is empty")
-			formFrame.AddText("passphrase is empty", false,
-				tview.AlignLeft, tview.Styles.SecondaryTextColor)
-			s.app.Draw()
+		if err := s.hill.AddAccount(account); err != nil {
+			showError(err)
 			return
 		}
-		s.hill.Accounts = append(s.hill.Accounts, account)
-		if err := s.state.Save(s.hill.Marshal()); err != nil {
-			s.fatal(err)
+		account := s.hill.LastAccount()
+		if contact != "" {
+			err := s.hill.AddContact(config.Contact{
+				Remote: contact,
+				Local:  account.Username,
+			})
+			if err != nil {
+				// remove account again, the hill stays unchanged until the contact is fixed
+				s.hill.Accounts = s.hill.Accounts[:len(s.hill.Accounts)-1]
+				showError(err)
+				return
+			}
 		}
-		// TODO: move somewhere else
-		send := make(chan string)
-		recv := make(chan string)
+		s.save()
 		status := fmt.Sprintf("opening XMPP connection for '%s'...", account.Username)
 		formFrame.Clear()
 		formFrame.AddText(mole, true, tview.AlignCenter,
@@ -57,11 +70,7 @@ func (s *state) accountAdd(xmppDebug bool) {
 		formFrame.AddText(status, false,
 			tview.AlignLeft, tview.Styles.SecondaryTextColor)
 		s.app.Draw()
-		if err := xmpp.Start(&account, send, recv, xmppDebug); err != nil {
-			s.fatal(err)
-		}
-		log.Println("established.")
-		s.main(send, recv)
+		s.startXMPP(account)
 	}).
 		AddButton("Quit", func() {
 			s.app.Stop()
@@ -69,5 +78,5 @@ func (s *state) accountAdd(xmppDebug bool) {
 		SetBorder(true).
 		SetTitle("Add account").SetTitleAlign(tview.AlignLeft)
 
-	s.app.SetRoot(formFrame, true).Draw()
+	s.setRoot(formFrame)
 }
@@ -0,0 +1,153 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+)
+
+// bookmarker is a session which supports bookmarks (not attached to a
+// daemon, which synchronizes the bookmarks itself).
+type bookmarker interface {
+	Bookmarks() ([]config.Room, error)
+	PublishBookmark(r *config.Room) error
+	RetractBookmark(room string) error
+}
+
+// bookmarker returns the session as bookmarker.
+func (s *state) bookmarker() (bookmarker, error) {
+	b, ok := s.session.(bookmarker)
+	if !ok || s.backend == nil {
+		return nil, errors.New("bookmarks cannot be changed when attached to a daemon")
+	}
+	return b, nil
+}
+
+// syncBookmarks synchronizes the rooms of account in the hill with its
+// bookmarks and saves the hill, if necessary.
+func (s *state) syncBookmarks(account *config.Account) {
+	b, err := s.bookmarker()
+	if err != nil {
+		return
+	}
+	bookmarks, err := b.Bookmarks()
+	if err != nil {
+		s.showError(fmt.Errorf("fetching bookmarks failed: %v", err))
+		return
+	}
+	_, publish, changed := s.hill.SyncRooms(account.Username, bookmarks)
+	for i := range publish {
+		if s.publishBookmark(b, &publish[i]) {
+			changed = true
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// publishBookmark publishes the bookmark of r and marks the room as
+// bookmarked in the hill. It reports whether the hill has been changed.
+func (s *state) publishBookmark(b bookmarker, r *config.Room) bool {
+	if err := b.PublishBookmark(r); err != nil {
+		s.showError(fmt.Errorf("bookmarking room '%s' failed: %v", r.Room, err))
+		return false
+	}
+	existing := s.hill.Room(r.Local, r.Room)
+	if existing == nil || existing.Bookmarked {
+		return false
+	}
+	existing.Bookmarked = true
+	return true
+}
+
+// retractBookmark removes the bookmark of room r, if it is bookmarked.
+func (s *state) retractBookmark(b bookmarker, r *config.Room) {
+	if !r.Bookmarked {
+		return
+	}
+	if err := b.RetractBookmark(r.Room); err != nil {
+		s.showError(fmt.Errorf("removing bookmark of room '%s' failed: %v", r.Room, err))
+	}
+}
+
+// cmdBookmark implements "/bookmark [<name>]", which bookmarks the current
+// room (under the given name).
+func (s *state) cmdBookmark(args []string) error {
+	b, err := s.bookmarker()
+	if err != nil {
+		return err
+	}
+	room, err := s.currentRoom("/bookmark")
+	if err != nil {
+		return err
+	}
+	account := s.hill.LastAccount()
+	r := s.hill.Room(account.Username, room)
+	if r == nil {
+		nick := localpart(account.Username)
+		s.mutex.Lock()
+		if o := s.rooms[room].self(); o != nil {
+			nick = o.Nick
+		}
+		s.mutex.Unlock()
+		err := s.hill.AddRoom(config.Room{
+			Room:     room,
+			Local:    account.Username,
+			Nick:     nick,
+			History:  config.DefaultRoomHistory,
+			AutoJoin: true,
+		})
+		if err != nil {
+			return err
+		}
+		r = s.hill.Room(account.Username, room)
+	}
+	if len(args) > 0 {
+		r.Name = strings.Join(args, " ")
+	}
+	s.publishBookmark(b, r)
+	s.save()
+	return nil
+}
+
+// cmdUnbookmark implements "/unbookmark [<room>]", which removes the
+// bookmark of the current (or given) room without leaving it.
+func (s *state) cmdUnbookmark(args []string) error {
+	b, err := s.bookmarker()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	room := s.contact
+	s.mutex.Unlock()
+	if len(args) == 1 {
+		rj, err := jid.Parse(args[0])
+		if err != nil {
+			return err
+		}
+		room = rj.Bare().String()
+	}
+	account := s.hill.LastAccount()
+	r := s.hill.Room(account.Username, room)
+	if r == nil {
+		return fmt.Errorf("room '%s' is not bookmarked", room)
+	}
+	s.retractBookmark(b, r)
+	if err := s.hill.RemoveRoom(account.Username, room); err != nil {
+		return err
+	}
+	s.save()
+	return nil
+}
+
+// localpart returns the localpart of the JID j.
+func localpart(j string) string {
+	if parsed, err := jid.Parse(j); err == nil {
+		return parsed.Local
+	}
+	return j
+}
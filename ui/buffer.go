@@ -0,0 +1,229 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/frankbraun/mole/util"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+// buffer is the chat record of a conversation (contact or room), or of the
+// console for lines which do not belong to one.
+type buffer struct {
+	view      *tview.TextView // shows the lines and keeps the scroll position (nil if locked)
+	lines     []chatLine      // lines of the chat record
+	unread    int             // number of messages received while not shown
+	highlight bool            // an unread message is a chat message or mentions us
+	draft     string          // text of the compose area while not shown
+	selected  int             // index of the line replied to (-1: none)
+	history   []string        // sent messages and commands (oldest first)
+	recalled  int             // index of the history entry in the compose area (len(history): none)
+	unsent    string          // text of the compose area before recalling the history
+}
+
+// recordView shows the chat record of the current conversation. The text
+// views of the buffers are swapped instead of rewriting their content, which
+// keeps their scroll positions.
+type recordView struct {
+	*tview.Box
+	mutex   sync.Mutex
+	current *tview.TextView
+}
+
+// newRecordView returns a new record view (showing nothing).
+func newRecordView() *recordView {
+	return &recordView{Box: tview.NewBox()}
+}
+
+// show makes v show view.
+func (v *recordView) show(view *tview.TextView) {
+	v.mutex.Lock()
+	v.current = view
+	v.mutex.Unlock()
+}
+
+// Draw draws the text view currently shown.
+func (v *recordView) Draw(screen tcell.Screen) {
+	v.mutex.Lock()
+	current := v.current
+	v.mutex.Unlock()
+	if current == nil {
+		return
+	}
+	current.SetRect(v.GetRect())
+	current.Draw(screen)
+}
+
+// scroll passes the scroll key event to the text view currently shown.
+func (v *recordView) scroll(event *tcell.EventKey) {
+	v.mutex.Lock()
+	current := v.current
+	v.mutex.Unlock()
+	if current != nil {
+		current.InputHandler()(event, func(tview.Primitive) {})
+	}
+}
+
+// newView returns a new text view for a buffer of the main view.
+func (s *state) newView() *tview.TextView {
+	view := tview.NewTextView()
+	view.SetBorder(true)
+	view.SetDynamicColors(true)
+	view.SetChangedFunc(func() {
+		s.app.Draw()
+	})
+	return view
+}
+
+// lineConversation returns the conversation a message line belongs to (a
+// contact or room).
+func lineConversation(l *chatLine) string {
+	if l.from == "" {
+		return l.to
+	}
+	return strings.SplitN(l.from, "/", 2)[0]
+}
+
+// buffer returns the buffer of conversation, which is created (and added
+// to the contact list) if necessary. s.mutex must be held.
+func (s *state) buffer(conversation string) *buffer {
+	b := s.buffers[conversation]
+	if b != nil {
+		return b
+	}
+	b = &buffer{selected: -1}
+	if s.chatRecord != nil {
+		b.view = s.newView()
+	}
+	s.buffers[conversation] = b
+	s.listed(conversation)
+	return b
+}
+
+// current returns the buffer of the current conversation.
+// s.mutex must be held.
+func (s *state) current() *buffer {
+	return s.buffer(s.contact)
+}
+
+// position returns the position of conversation in the contact list (-1 if
+// it is not listed). s.mutex must be held.
+func (s *state) position(conversation string) int {
+	for i, c := range s.order {
+		if c == conversation {
+			return i
+		}
+	}
+	return -1
+}
+
+// listed adds conversation to the contact list, if it is not listed yet.
+// s.mutex must be held.
+func (s *state) listed(conversation string) {
+	if conversation != "" && s.position(conversation) < 0 {
+		s.order = append(s.order, conversation)
+		s.updateContactList()
+	}
+}
+
+// updateContactList shows the conversations in the contact list with their
+// numbers, the number of unread messages, and highlighted if one of them is
+// addressed to us. s.mutex must be held.
+func (s *state) updateContactList() {
+	if s.contactList == nil {
+		return
+	}
+	s.contactList.Clear()
+	for i, c := range s.order {
+		item := fmt.Sprintf("%d %s", i+1, sanitizeLine(c))
+		if b := s.buffers[c]; b != nil && b.unread > 0 {
+			item += fmt.Sprintf(" (%d)", b.unread)
+			if b.highlight {
+				item = "[yellow]" + item + "[-]"
+			}
+		}
+		s.contactList.AddItem(item, "", 0, nil)
+	}
+	if i := s.position(s.contact); i >= 0 {
+		s.contactList.SetCurrentItem(i)
+	}
+}
+
+// countUnread counts the message l as unread in conversation, if it is not
+// shown. Chat messages and room messages mentioning our nickname are
+// highlighted. s.mutex must be held.
+func (s *state) countUnread(conversation string, l *chatLine) {
+	if l.from == "" || s.chatRecord != nil && conversation == s.contact {
+		return
+	}
+	b := s.buffer(conversation)
+	b.unread++
+	if r := s.rooms[conversation]; r == nil {
+		b.highlight = true
+	} else if o := r.self(); o != nil && util.Mentions(l.text, o.Nick) {
+		b.highlight = true
+	}
+	s.updateContactList()
+}
+
+// cycle switches to the next (or previous, if back) conversation of the
+// contact list.
+func (s *state) cycle(back bool) {
+	s.mutex.Lock()
+	n := len(s.order)
+	i := s.position(s.contact)
+	s.mutex.Unlock()
+	if n == 0 {
+		return
+	}
+	switch {
+	case i < 0:
+		i = 0
+	case back:
+		i = (i + n - 1) % n
+	default:
+		i = (i + 1) % n
+	}
+	s.switchToNumber(i)
+}
+
+// switchToNumber switches to the conversation at position i of the contact
+// list, if there is one.
+func (s *state) switchToNumber(i int) {
+	s.mutex.Lock()
+	var target string
+	ok := i >= 0 && i < len(s.order)
+	if ok {
+		target = s.order[i]
+	}
+	s.mutex.Unlock()
+	if ok {
+		s.switchTo(target)
+	}
+}
+
+// redraw writes all lines of b to its view again, after one of them
+// changed, and marks the selected message. s.mutex must be held.
+func (s *state) redraw(b *buffer) {
+	if b.view == nil {
+		return
+	}
+	var text strings.Builder
+	for i := range b.lines {
+		if i > 0 {
+			text.WriteString("\n")
+		}
+		if i == b.selected {
+			text.WriteString("▶ ")
+		}
+		text.WriteString(b.lines[i].render())
+	}
+	b.view.Clear()
+	if _, err := io.WriteString(b.view, text.String()); err != nil {
+		s.fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// pauseDelay is the time without typing after which the chat state changes
+// from composing to paused.
+const pauseDelay = 5 * time.Second
+
+// sendsChatStates reports whether chat states are sent to contact, which
+// is disabled per contact in the hill and never done for rooms.
+// s.mutex must be held.
+func (s *state) sendsChatStates(contact string) bool {
+	return contact != "" && s.hill != nil && !s.isRoom(contact) &&
+		s.hill.SendChatStates(s.username, contact)
+}
+
+// setChatState sends the chat state to contact, if it changed.
+// s.mutex must be held (and released with unlock).
+func (s *state) setChatState(contact, state string) {
+	if s.send == nil || s.chatStates[contact] == state || !s.sendsChatStates(contact) {
+		return
+	}
+	s.chatStates[contact] = state
+	s.queue(xmpp.Message{To: contact, ChatState: state})
+}
+
+// stopPauseTimer stops the timer which changes composing to paused.
+// s.mutex must be held.
+func (s *state) stopPauseTimer() {
+	if s.pauseTimer != nil {
+		s.pauseTimer.Stop()
+		s.pauseTimer = nil
+	}
+}
+
+// typed updates our chat state in the current conversation after the text
+// of the compose area changed. Typing a message is composing (paused after
+// pauseDelay), clearing the compose area or typing a command is active.
+// Clearing the compose area also cancels the correction of a message.
+// Restoring the draft of a conversation is not typing.
+func (s *state) typed(text string) {
+	s.mutex.Lock()
+	defer s.unlock()
+	if s.restoring {
+		return
+	}
+	contact := s.contact
+	if text == "" {
+		s.correcting = ""
+	}
+	if text == "" || strings.HasPrefix(text, "/") && !strings.HasPrefix(text, "//") {
+		s.stopPauseTimer()
+		if state := s.chatStates[contact]; state == xmpp.Composing || state == xmpp.Paused {
+			s.setChatState(contact, xmpp.Active)
+		}
+		return
+	}
+	s.setChatState(contact, xmpp.Composing)
+	s.stopPauseTimer()
+	s.pauseTimer = time.AfterFunc(pauseDelay, func() {
+		s.mutex.Lock()
+		defer s.unlock()
+		if s.chatStates[contact] == xmpp.Composing {
+			s.setChatState(contact, xmpp.Paused)
+		}
+	})
+}
+
+// leaveConversation sets our chat state in the conversation with contact
+// to state (Inactive or Gone), if we have been taking part in it.
+// s.mutex must be held (and released with unlock).
+func (s *state) leaveConversation(contact, state string) {
+	switch s.chatStates[contact] {
+	case xmpp.Active, xmpp.Composing, xmpp.Paused:
+		s.stopPauseTimer()
+		s.setChatState(contact, state)
+	}
+}
+
+// leaveConversations ends all conversations we have been taking part in
+// (before the UI is locked). s.mutex must be held (and released with
+// unlock).
+func (s *state) leaveConversations() {
+	for contact := range s.chatStates {
+		s.leaveConversation(contact, xmpp.Gone)
+	}
+	s.chatStates = make(map[string]string)
+}
+
+// chatStateEvent records the chat state cs of a contact and shows it, if it
+// is the current conversation. s.mutex must be held.
+func (s *state) chatStateEvent(cs *xmpp.ChatState) {
+	s.typing[cs.From] = cs.State
+	if cs.From == s.contact {
+		s.updateHeader()
+	}
+}
+
+// typingInfo returns the information shown for the chat state of the
+// current conversation (empty if there is nothing to show).
+// s.mutex must be held.
+func (s *state) typingInfo() string {
+	switch s.typing[s.contact] {
+	case xmpp.Composing:
+		return sanitizeLine(s.contact) + " is typing…"
+	case xmpp.Gone:
+		return sanitizeLine(s.contact) + " has left the conversation"
+	}
+	return ""
+}
+
+// cmdChatStates implements "/chatstates [on|off]", which shows or sets
+// whether chat states are sent to the current contact.
+func (s *state) cmdChatStates(args []string) error {
+	if len(args) == 1 && args[0] != "on" && args[0] != "off" {
+		return errors.New("usage: /chatstates [on|off]")
+	}
+	s.mutex.Lock()
+	contact := s.contact
+	c := s.hill.Contact(s.username, contact)
+	s.mutex.Unlock()
+	if c == nil {
+		return fmt.Errorf("/chatstates: '%s' is not a contact", contact)
+	}
+	if len(args) == 1 {
+		// the hill cannot be changed when attached to a daemon
+		if s.backend == nil {
+			return errors.New("/chatstates: cannot change contacts when attached to daemon")
+		}
+		c.NoChatStates = args[0] == "off"
+		s.save()
+	}
+	state := "on"
+	if c.NoChatStates {
+		state = "off"
+	}
+	s.show(fmt.Sprintf("chat states for %s: %s", sanitizeLine(contact), state))
+	return nil
+}
@@ -0,0 +1,465 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+	"github.com/rivo/tview"
+)
+
+// command is a slash command of the input field.
+type command struct {
+	name     string      // name including the slash
+	args     string      // arguments shown in the usage
+	help     string      // short description shown by /help
+	min, max int         // minimum and maximum number of arguments
+	text     bool        // the last argument is the rest of the line
+	secret   int         // number of the secret argument, not kept in the input history (0: none)
+	complete []completer // completers of the arguments (if any)
+	run      func(s *state, args []string) error
+}
+
+// completer returns the candidates to complete an argument with.
+// s.mutex must be held.
+type completer func(s *state) []string
+
+// commands is the registry of all slash commands, in the order they are
+// listed by /help.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "/msg", args: "<jid> [<text>]", help: "switch to a conversation (and send text)",
+			min: 1, max: 2, text: true, complete: []completer{conversations},
+			run: (*state).cmdMsg},
+		{name: "/me", args: "<action>", help: "send an action",
+			min: 1, max: 1, text: true, run: (*state).cmdMe},
+		{name: "/clear", help: "clear the chat record of the current conversation",
+			run: (*state).cmdClear},
+		{name: "/add", args: "<jid>", help: "add a contact",
+			min: 1, max: 1, complete: []completer{conversations}, run: (*state).cmdAdd},
+		{name: "/remove", args: "<jid>", help: "remove a contact",
+			min: 1, max: 1, complete: []completer{contacts}, run: (*state).cmdRemove},
+		{name: "/status", args: "<show> [<message>]", min: 1, max: 2, text: true,
+			help:     "set our presence (available, away, chat, dnd, or xa)",
+			complete: []completer{values("available", "away", "chat", "dnd", "xa")},
+			run:      (*state).cmdStatus},
+		{name: "/join", args: "<room> [<nick> [<password>]]", help: "join a room",
+			min: 1, max: 3, secret: 3, complete: []completer{hillRooms}, run: (*state).cmdJoin},
+		{name: "/leave", args: "[<room>]", help: "leave the current (or given) room",
+			max: 1, complete: []completer{joinedRooms}, run: (*state).cmdLeave},
+		{name: "/part", args: "[<room>]", help: "same as /leave",
+			max: 1, complete: []completer{joinedRooms}, run: (*state).cmdLeave},
+		{name: "/nick", args: "<nick>", help: "change our nickname in the current room",
+			min: 1, max: 1, run: (*state).cmdNick},
+		{name: "/topic", args: "[<subject>]", help: "set the subject of the current room",
+			max: 1, text: true, run: (*state).cmdTopic},
+		{name: "/who", help: "list the occupants of the current room", run: (*state).cmdWho},
+		roleCommand("/kick", "none", "kick an occupant"),
+		roleCommand("/voice", "participant", "grant voice to an occupant"),
+		roleCommand("/devoice", "visitor", "revoke voice from an occupant"),
+		roleCommand("/moderator", "moderator", "grant the moderator role to an occupant"),
+		affiliationCommand("/ban", "outcast", "ban a user"),
+		affiliationCommand("/member", "member", "make a user a member"),
+		affiliationCommand("/admin", "admin", "make a user an admin"),
+		affiliationCommand("/owner", "owner", "make a user an owner"),
+		affiliationCommand("/revoke", "none", "revoke the affiliation of a user"),
+		inviteCommand("/invite", false, "invite a user (mediated by the room)"),
+		inviteCommand("/dinvite", true, "invite a user directly (with the room password)"),
+		{name: "/config", help: "configure the current room", run: (*state).cmdConfig},
+		{name: "/bookmark", args: "[<name>]", help: "bookmark the current room",
+			max: 1, text: true, run: (*state).cmdBookmark},
+		{name: "/unbookmark", args: "[<room>]", help: "remove the bookmark of a room",
+			max: 1, complete: []completer{hillRooms}, run: (*state).cmdUnbookmark},
+		{name: "/chatstates", args: "[on|off]", help: "show or set sending chat states to the contact",
+			max: 1, complete: []completer{values("on", "off")}, run: (*state).cmdChatStates},
+		{name: "/correct", args: "<text>", help: "correct our last message",
+			min: 1, max: 1, text: true, run: (*state).cmdCorrect},
+		{name: "/edits", help: "show the previous versions of corrected messages",
+			run: (*state).cmdEdits},
+		{name: "/retract", help: "retract our last message", run: (*state).cmdRetract},
+		{name: "/moderate", args: "<nick> [<reason>]", help: "retract the last message of an occupant",
+			min: 1, max: 2, text: true, complete: []completer{nicks},
+			run: (*state).cmdModerate},
+		{name: "/react", args: "<reaction>", help: "react to the selected (or last) message",
+			min: 1, max: 1, run: (*state).cmdReact},
+		{name: "/help", args: "[<command>]", help: "show the commands (or the usage of one)",
+			max: 1, complete: []completer{commandNames}, run: (*state).cmdHelp},
+		{name: "/quit", help: "quit Mole", run: (*state).cmdQuit},
+	}
+}
+
+// roleCommand returns the command which sets the role of an occupant of the
+// current room.
+func roleCommand(name, role, help string) *command {
+	return &command{name: name, args: "<nick> [<reason>]", help: help,
+		min: 1, max: 2, text: true, complete: []completer{nicks},
+		run: func(s *state, args []string) error {
+			return s.cmdRole(name, role, args)
+		},
+	}
+}
+
+// affiliationCommand returns the command which sets the affiliation of a
+// user with the current room.
+func affiliationCommand(name, affiliation, help string) *command {
+	return &command{name: name, args: "<nick|jid> [<reason>]", help: help,
+		min: 1, max: 2, text: true, complete: []completer{nicks},
+		run: func(s *state, args []string) error {
+			return s.cmdAffiliation(name, affiliation, args)
+		},
+	}
+}
+
+// inviteCommand returns the command which invites a user to the current
+// room.
+func inviteCommand(name string, direct bool, help string) *command {
+	return &command{name: name, args: "<jid> [<reason>]", help: help,
+		min: 1, max: 2, text: true, complete: []completer{contacts},
+		run: func(s *state, args []string) error {
+			return s.cmdInvite(name, direct, args)
+		},
+	}
+}
+
+// usage returns the usage of c.
+func (c *command) usage() string {
+	if c.args == "" {
+		return c.name
+	}
+	return c.name + " " + c.args
+}
+
+// findCommand returns the command with the given name (with or without the
+// slash), or nil if there is none.
+func findCommand(name string) *command {
+	name = "/" + strings.TrimPrefix(name, "/")
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// isCommand reports whether the input line is a command. Lines starting
+// with two slashes are messages starting with one.
+func isCommand(line string) bool {
+	return strings.HasPrefix(line, "/") && !strings.HasPrefix(line, "//")
+}
+
+// parseCommand returns the command of the input line and its arguments, or
+// an error if the command is unknown or the number of arguments is wrong.
+func parseCommand(line string) (*command, []string, error) {
+	rest := strings.TrimLeftFunc(line, unicode.IsSpace)
+	name, rest := splitWord(rest)
+	c := findCommand(name)
+	if c == nil {
+		return nil, nil, fmt.Errorf("unknown command '%s' (see /help)", name)
+	}
+	var args []string
+	for rest != "" {
+		if c.text && len(args) == c.max-1 {
+			args = append(args, rest)
+			break
+		}
+		var arg string
+		arg, rest = splitWord(rest)
+		args = append(args, arg)
+	}
+	if len(args) < c.min || len(args) > c.max {
+		return nil, nil, errors.New("usage: " + c.usage())
+	}
+	return c, args, nil
+}
+
+// splitWord splits the first word from text, which must not start with
+// white space, and returns it and the rest of text without leading white
+// space.
+func splitWord(text string) (word, rest string) {
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimSpace(text[i:])
+}
+
+// command executes the command c with args and shows errors in the chat
+// record.
+func (s *state) command(c *command, args []string) {
+	if err := c.run(s, args); err != nil {
+		s.showError(err)
+	}
+}
+
+// complete completes the last word of the input text: command names, the
+// arguments of commands, and nicknames in messages to rooms. It returns the
+// completed text and the candidates, if there are several.
+func (s *state) complete(text string) (string, []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i := strings.LastIndexFunc(text, unicode.IsSpace) + 1
+	prefix, word := text[:i], text[i:]
+	var complete completer
+	suffix := " "
+	switch fields := strings.Fields(prefix); {
+	case !isCommand(text):
+		complete = nicks
+		if len(fields) == 0 {
+			suffix = ": "
+		}
+	case len(fields) == 0:
+		complete = commandNames
+		word = strings.TrimPrefix(word, "/")
+		prefix = "/"
+	default:
+		c := findCommand(fields[0])
+		n := len(fields) - 1 // index of completed argument
+		if c == nil || n >= len(c.complete) {
+			return text, nil
+		}
+		complete = c.complete[n]
+	}
+	var candidates []string
+	for _, candidate := range complete(s) {
+		if strings.HasPrefix(candidate, word) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return text, nil
+	case 1:
+		return prefix + candidates[0] + suffix, nil
+	}
+	return prefix + commonPrefix(candidates), candidates
+}
+
+// commonPrefix returns the longest common prefix of list.
+func commonPrefix(list []string) string {
+	common := []rune(list[0])
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, string(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+	return string(common)
+}
+
+// commandNames completes the names of commands (without the slash).
+func commandNames(s *state) []string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, strings.TrimPrefix(c.name, "/"))
+	}
+	return names
+}
+
+// conversations completes the JIDs of the contact list.
+func conversations(s *state) []string {
+	return s.order
+}
+
+// contacts completes the JIDs of the contacts of the account.
+func contacts(s *state) []string {
+	var jids []string
+	for _, c := range s.hill.AccountContacts(s.username) {
+		jids = append(jids, c.Remote)
+	}
+	return jids
+}
+
+// hillRooms completes the JIDs of the rooms of the account in the hill.
+func hillRooms(s *state) []string {
+	var jids []string
+	for _, r := range s.hill.AccountRooms(s.username) {
+		jids = append(jids, r.Room)
+	}
+	return jids
+}
+
+// joinedRooms completes the JIDs of the joined rooms.
+func joinedRooms(s *state) []string {
+	var jids []string
+	for room := range s.rooms {
+		jids = append(jids, room)
+	}
+	sort.Strings(jids)
+	return jids
+}
+
+// nicks completes the nicknames of the occupants of the current room.
+func nicks(s *state) []string {
+	r := s.rooms[s.contact]
+	if r == nil {
+		return nil
+	}
+	var nicks []string
+	for nick, o := range r.occupants {
+		if !o.Self {
+			nicks = append(nicks, nick)
+		}
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// values returns a completer for the given values.
+func values(values ...string) completer {
+	return func(s *state) []string {
+		return values
+	}
+}
+
+// cmdMsg implements "/msg <jid> [<text>]", which switches to the
+// conversation with jid and sends text to it.
+func (s *state) cmdMsg(args []string) error {
+	j, err := jid.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	s.switchTo(j.Bare().String())
+	if len(args) > 1 {
+		return s.sendMessage(args[1])
+	}
+	return nil
+}
+
+// cmdMe implements "/me <action>", which sends the action to the current
+// conversation.
+func (s *state) cmdMe(args []string) error {
+	return s.sendMessage(mePrefix + args[0])
+}
+
+// cmdClear implements "/clear", which clears the chat record of the
+// current conversation.
+func (s *state) cmdClear(args []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b := s.current()
+	b.lines = nil
+	b.selected = -1
+	s.redraw(b)
+	s.updateHeader()
+	return nil
+}
+
+// cmdAdd implements "/add <jid>", which adds a contact to the account.
+func (s *state) cmdAdd(args []string) error {
+	// the hill cannot be changed when attached to a daemon
+	if s.backend == nil {
+		return errors.New("/add: cannot change contacts when attached to daemon")
+	}
+	j, err := jid.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	contact := j.Bare().String()
+	account := s.hill.LastAccount()
+	err = s.hill.AddContact(config.Contact{Remote: contact, Local: account.Username})
+	if err != nil {
+		return err
+	}
+	s.save()
+	s.mutex.Lock()
+	s.listed(contact)
+	s.writeChat(fmt.Sprintf("added contact %s", contact))
+	s.mutex.Unlock()
+	return nil
+}
+
+// cmdRemove implements "/remove <jid>", which removes a contact of the
+// account. The conversation stays in the contact list.
+func (s *state) cmdRemove(args []string) error {
+	// the hill cannot be changed when attached to a daemon
+	if s.backend == nil {
+		return errors.New("/remove: cannot change contacts when attached to daemon")
+	}
+	account := s.hill.LastAccount()
+	err := s.hill.RemoveContact(config.Contact{Remote: args[0], Local: account.Username})
+	if err != nil {
+		return err
+	}
+	s.save()
+	s.show(fmt.Sprintf("removed contact %s", args[0]))
+	return nil
+}
+
+// cmdStatus implements "/status <show> [<message>]", which sets our
+// presence.
+func (s *state) cmdStatus(args []string) error {
+	show := args[0]
+	switch show {
+	case "available":
+		show = ""
+	case "away", "chat", "dnd", "xa":
+	default:
+		return fmt.Errorf("/status: unknown show '%s'", show)
+	}
+	var status string
+	if len(args) > 1 {
+		status = args[1]
+	}
+	if err := s.session.SetPresence(show, status); err != nil {
+		return err
+	}
+	line := "status: " + args[0]
+	if status != "" {
+		line += " (" + tview.Escape(status) + ")"
+	}
+	s.show(line)
+	return nil
+}
+
+// cmdNick implements "/nick <nick>", which changes our nickname in the
+// current room (and in the hill, for the next time the room is joined).
+func (s *state) cmdNick(args []string) error {
+	room, err := s.currentRoom("/nick")
+	if err != nil {
+		return err
+	}
+	if err := s.session.ChangeNick(room, args[0]); err != nil {
+		return err
+	}
+	if r := s.hill.Room(s.hill.LastAccount().Username, room); s.backend != nil && r != nil {
+		r.Nick = args[0]
+		s.save()
+	}
+	return nil
+}
+
+// cmdHelp implements "/help [<command>]", which lists the commands (or shows
+// the usage of the given one).
+func (s *state) cmdHelp(args []string) error {
+	list := commands
+	if len(args) == 1 {
+		c := findCommand(args[0])
+		if c == nil {
+			return fmt.Errorf("/help: unknown command '%s'", args[0])
+		}
+		list = []*command{c}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range list {
+		s.writeChat(fmt.Sprintf("%s [gray]— %s[-]", tview.Escape(c.usage()), c.help))
+	}
+	return nil
+}
+
+// cmdQuit implements "/quit", which leaves all conversations, closes the
+// XMPP session (or detaches from the daemon), and stops the UI.
+func (s *state) cmdQuit(args []string) error {
+	s.stopIdleTimer()
+	s.mutex.Lock()
+	s.leaveConversations()
+	s.unlock()
+	s.stopXMPP()
+	s.app.Stop()
+	return nil
+}
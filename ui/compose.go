@@ -0,0 +1,250 @@
+package ui
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+// maxHistory is the number of input lines kept per conversation.
+const maxHistory = 100
+
+// composeArea is the input area of the main view: the input field for the
+// last line of the message below the lines entered before it (with Alt-Enter
+// or Ctrl-J).
+type composeArea struct {
+	*tview.Box
+	input *tview.InputField
+	mutex sync.Mutex
+	lines []string // lines before the one in the input field
+}
+
+// newComposeArea returns a new compose area with an empty input field.
+func newComposeArea() *composeArea {
+	return &composeArea{Box: tview.NewBox(), input: tview.NewInputField()}
+}
+
+// height returns the number of lines of c.
+func (c *composeArea) height() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.lines) + 1
+}
+
+// text returns the text of c.
+func (c *composeArea) text() string {
+	c.mutex.Lock()
+	lines := append(append([]string(nil), c.lines...), c.input.GetText())
+	c.mutex.Unlock()
+	return strings.Join(lines, "\n")
+}
+
+// setText replaces the text of c.
+func (c *composeArea) setText(text string) {
+	lines := strings.Split(text, "\n")
+	c.mutex.Lock()
+	c.lines = lines[:len(lines)-1]
+	c.mutex.Unlock()
+	c.input.SetText(lines[len(lines)-1])
+}
+
+// newline starts a new line after the text of the input field.
+func (c *composeArea) newline() {
+	c.mutex.Lock()
+	c.lines = append(c.lines, c.input.GetText())
+	c.mutex.Unlock()
+	c.input.SetText("")
+}
+
+// joinLine moves the previous line back to the empty input field and
+// reports whether there was one.
+func (c *composeArea) joinLine() bool {
+	c.mutex.Lock()
+	n := len(c.lines)
+	if n == 0 || c.input.GetText() != "" {
+		c.mutex.Unlock()
+		return false
+	}
+	last := c.lines[n-1]
+	c.lines = c.lines[:n-1]
+	c.mutex.Unlock()
+	c.input.SetText(last)
+	return true
+}
+
+// Draw draws the previous lines (as many as fit) and the input field below
+// them.
+func (c *composeArea) Draw(screen tcell.Screen) {
+	x, y, width, height := c.GetRect()
+	if height < 1 {
+		return
+	}
+	c.mutex.Lock()
+	lines := c.lines
+	if len(lines) > height-1 {
+		lines = lines[len(lines)-(height-1):]
+	}
+	for i, line := range lines {
+		tview.Print(screen, tview.Escape(line), x, y+i, width, tview.AlignLeft,
+			tview.Styles.PrimaryTextColor)
+	}
+	c.mutex.Unlock()
+	c.input.SetRect(x, y+height-1, width, 1)
+	c.input.Draw(screen)
+}
+
+// Focus passes the focus to the input field.
+func (c *composeArea) Focus(delegate func(p tview.Primitive)) {
+	delegate(c.input)
+}
+
+// mainLayout shows the main view above the compose area, which grows with
+// the number of lines (up to half of the screen).
+type mainLayout struct {
+	*tview.Box
+	main    tview.Primitive
+	compose *composeArea
+}
+
+// newMainLayout returns the layout of main above compose.
+func newMainLayout(main tview.Primitive, compose *composeArea) *mainLayout {
+	return &mainLayout{Box: tview.NewBox(), main: main, compose: compose}
+}
+
+// Draw draws the main view and the compose area.
+func (l *mainLayout) Draw(screen tcell.Screen) {
+	x, y, width, height := l.GetRect()
+	n := l.compose.height()
+	if n > height/2 {
+		n = height / 2
+	}
+	if n < 1 {
+		n = 1
+	}
+	l.main.SetRect(x, y, width, height-n)
+	l.compose.SetRect(x, y+height-n, width, n)
+	l.main.Draw(screen)
+	l.compose.Draw(screen)
+}
+
+// Focus passes the focus to the compose area.
+func (l *mainLayout) Focus(delegate func(p tview.Primitive)) {
+	l.compose.Focus(delegate)
+}
+
+// remember adds text to the input history of the current conversation.
+// s.mutex must be held.
+func (s *state) remember(text string) {
+	b := s.current()
+	if n := len(b.history); n == 0 || b.history[n-1] != text {
+		b.history = append(b.history, text)
+		if len(b.history) > maxHistory {
+			b.history = b.history[1:]
+		}
+	}
+	b.recalled = len(b.history)
+	b.unsent = ""
+}
+
+// historyEntry returns the input line msg (the command c with args, if not
+// nil) as kept in the input history: without the secret argument of c.
+func historyEntry(msg string, c *command, args []string) string {
+	if c == nil || c.secret == 0 || len(args) < c.secret {
+		return msg
+	}
+	return strings.Join(append([]string{c.name}, args[:c.secret-1]...), " ")
+}
+
+// forgetHistory removes the entries which sent one of texts (as message,
+// action, or correction) from the input history of b.
+// Recalling starts again from the end if entries have been removed.
+// s.mutex must be held.
+func forgetHistory(b *buffer, texts []string) {
+	history := b.history[:0]
+	for _, entry := range b.history {
+		if !sentText(entry, texts) {
+			history = append(history, entry)
+		}
+	}
+	if len(history) < len(b.history) {
+		b.history = history
+		b.recalled = len(history)
+	}
+}
+
+// sentText reports whether the input history entry sent one of texts.
+func sentText(entry string, texts []string) bool {
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		if entry == text || entry == "/"+text || entry == "/correct "+text {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetRecall stops recalling the input history of the current
+// conversation. s.mutex must be held.
+func (s *state) forgetRecall() {
+	b := s.current()
+	b.recalled = len(b.history)
+	b.unsent = ""
+}
+
+// recalling reports whether an entry of the input history of the current
+// conversation has been recalled.
+func (s *state) recalling() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b := s.current()
+	return b.recalled < len(b.history)
+}
+
+// recall replaces the text of the compose area with the previous (or next,
+// if forward) entry of the input history of the current conversation.
+// Going forward from the last entry restores the text entered before
+// recalling.
+func (s *state) recall(forward bool) {
+	s.mutex.Lock()
+	b := s.current()
+	compose := s.compose
+	i := b.recalled
+	if forward && i >= len(b.history) || !forward && i == 0 {
+		s.mutex.Unlock()
+		return
+	}
+	if i == len(b.history) {
+		b.unsent = compose.text()
+	}
+	if forward {
+		i++
+	} else {
+		i--
+	}
+	text := b.unsent
+	if i < len(b.history) {
+		text = b.history[i]
+	} else {
+		b.unsent = ""
+	}
+	b.recalled = i
+	s.mutex.Unlock()
+	compose.setText(text)
+}
+
+// editCompose replaces the text of the compose area with the result of
+// editing it in the external editor. The idle timer is stopped meanwhile.
+func (s *state) editCompose() {
+	s.stopIdleTimer()
+	text, err := s.editor(s.compose.text())
+	s.startIdleTimer()
+	if err != nil {
+		s.showError(err)
+		return
+	}
+	s.compose.setText(text)
+}
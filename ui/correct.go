@@ -0,0 +1,88 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// correct replaces the text of the message with the ID replace by the text
+// of l, if it is shown, and reports whether it has been found. Only the
+// sender of a message can correct it. The previous text is kept in the edit
+// history of the line. s.mutex must be held.
+func (s *state) correct(l chatLine, replace string) bool {
+	conversation := lineConversation(&l)
+	old := s.findLine(conversation, l.from, replace)
+	if old == nil || old.retracted != "" {
+		return false
+	}
+	old.edits = append(old.edits, old.text)
+	old.text = l.text
+	old.lastID = l.id
+	old.state = l.state
+	s.redraw(s.buffer(conversation))
+	return true
+}
+
+// lastSent returns the line of our last message sent to contact (nil if
+// there is none). s.mutex must be held.
+func (s *state) lastSent(contact string) *chatLine {
+	lines := s.buffer(contact).lines
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := &lines[i]
+		if l.from == "" && l.id != "" && l.retracted == "" {
+			return l
+		}
+	}
+	return nil
+}
+
+// editLast starts the correction of our last message in the current
+// conversation and returns its text (empty if there is none).
+func (s *state) editLast() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := s.lastSent(s.contact)
+	if l == nil {
+		return ""
+	}
+	s.correcting = l.id
+	return l.text
+}
+
+// cmdCorrect implements "/correct <text>", which replaces our last message
+// in the current conversation with text.
+func (s *state) cmdCorrect(args []string) error {
+	s.mutex.Lock()
+	err := s.sendable(s.contact)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if s.editLast() == "" {
+		return errors.New("/correct: no message to correct")
+	}
+	return s.sendMessage(args[0])
+}
+
+// cmdEdits implements "/edits", which shows the previous versions of the
+// corrected messages in the current conversation.
+func (s *state) cmdEdits(args []string) error {
+	s.mutex.Lock()
+	var lines []string
+	for _, l := range s.current().lines {
+		if len(l.edits) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s%s (was: %s)", sanitizeLine(l.prefix),
+			sanitizeLine(l.text), sanitizeLine(strings.Join(l.edits, " | "))))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no corrected messages")
+	}
+	for _, line := range lines {
+		s.writeChat(line)
+	}
+	s.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,44 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/frankbraun/mole/util"
+)
+
+// DumpOptions defines what is dumped after the hill has been decrypted.
+type DumpOptions struct {
+	Section    string // dump only the given section (see config.Sections)
+	Unredacted bool   // dump secrets unredacted (asks for confirmation)
+}
+
+// confirm asks the user on stderr to confirm question with "yes".
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s (type 'yes' to confirm): ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// dump the hill according to opts to stdout and exit.
+func (s *state) dump(opts *DumpOptions) {
+	s.app.Suspend(func() {
+		if opts.Unredacted &&
+			!confirm("WARNING: the dump will contain all secrets (like passwords) in clear. Continue?") {
+			fmt.Fprintln(os.Stderr, "aborted.")
+			os.Exit(1)
+		}
+		jsn, err := s.hill.Dump(opts.Section, opts.Unredacted)
+		if err != nil {
+			util.Fatal(err)
+		}
+		fmt.Println(string(jsn))
+		util.Wipe(jsn)
+		os.Exit(0)
+	})
+}
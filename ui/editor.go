@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/util"
+)
+
+// editExternal edits text with runEditor while the UI is suspended.
+func (s *state) editExternal(text string) (string, error) {
+	edited, err := "", errors.New("cannot suspend user interface")
+	s.app.Suspend(func() {
+		edited, err = runEditor(text)
+	})
+	return edited, err
+}
+
+// runEditor lets the user edit text with the editor given by $EDITOR (vi by
+// default) and returns the edited text without trailing newlines. The text
+// is stored in a file (mode 0600) in a private temporary directory (mode
+// 0700), which is wiped and removed afterwards.
+func runEditor(text string) (string, error) {
+	dir, err := ioutil.TempDir("", "mole")
+	if err != nil {
+		return "", err
+	}
+	defer wipeDir(dir)
+	filename := filepath.Join(dir, "message.txt")
+	data := []byte(text)
+	err = ioutil.WriteFile(filename, data, 0600)
+	util.Wipe(data)
+	if err != nil {
+		return "", err
+	}
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], filename)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor '%s' failed: %v", editor[0], err)
+	}
+	data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	defer util.Wipe(data)
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// wipeDir overwrites all regular files in dir (including backup and swap
+// files of the editor) with zeros and removes dir.
+func wipeDir(dir string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			if err := wipeFile(path, info.Size()); err != nil {
+				log.Printf("wiping '%s' failed: %v", path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("wiping '%s' failed: %v", dir, err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("removing '%s' failed: %v", dir, err)
+	}
+}
+
+// wipeFile overwrites the file filename of the given size with zeros.
+func wipeFile(filename string, size int64) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(make([]byte, size)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,69 @@
+package ui
+
+import (
+	"time"
+
+	"github.com/frankbraun/codechain/util/log"
+)
+
+// touch records user activity.
+func (s *state) touch() {
+	s.mutex.Lock()
+	s.lastActivity = time.Now()
+	s.mutex.Unlock()
+}
+
+// startIdleTimer (re)starts the timer which locks the UI after the idle
+// timeout configured in the settings.
+func (s *state) startIdleTimer() {
+	s.stopIdleTimer()
+	timeout := time.Duration(s.hill.Settings.IdleTimeout) * time.Minute
+	if timeout <= 0 {
+		return
+	}
+	s.touch()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.idleTimer = time.AfterFunc(timeout, func() {
+		s.checkIdle(timeout)
+	})
+}
+
+// checkIdle locks the UI if there was no user activity during timeout.
+// Otherwise, the idle timer is rescheduled. It is called by the idle timer,
+// the UI is locked in the event loop of the application.
+func (s *state) checkIdle(timeout time.Duration) {
+	s.mutex.Lock()
+	if s.idleTimer == nil {
+		// timer has been stopped in the meantime
+		s.mutex.Unlock()
+		return
+	}
+	idle := time.Since(s.lastActivity)
+	if idle < timeout {
+		s.idleTimer.Reset(timeout - idle)
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+	s.app.QueueUpdate(func() {
+		s.mutex.Lock()
+		stopped := s.idleTimer == nil
+		s.mutex.Unlock()
+		if stopped {
+			return // locked in the meantime
+		}
+		log.Printf("idle for %s, locking", idle)
+		s.lock()
+	})
+}
+
+// stopIdleTimer stops the idle timer.
+func (s *state) stopIdleTimer() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+}
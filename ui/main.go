@@ -2,81 +2,609 @@ package ui
 
 import (
 	"io"
+	"strings"
+	"time"
 
 	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/hook"
+	"github.com/frankbraun/mole/xmpp"
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
 )
 
 // TODO: take care of syncing/mutexes!
-// TODO: check security implications of DynamicColors!
 
-func (s *state) main(send, recv chan string) {
+// receive events from recv and write them to the buffers of their
+// conversations. Messages received while the UI is locked are kept in the
+// buffers until it is unlocked again. If hooks is not nil, they are run for
+// all events.
+func (s *state) receive(recv <-chan xmpp.Event, hooks *hook.Runner) {
+	for ev := range recv {
+		if hooks != nil {
+			hooks.Handle(ev)
+		}
+		s.mutex.Lock()
+		var conversation, line string
+		switch ev.Kind {
+		case xmpp.MessageEvent:
+			s.receiveMessage(ev.Message)
+		case xmpp.ReceiptEvent:
+			s.setState(ev.Receipt.From, ev.Receipt.ID, ev.Receipt.State)
+		case xmpp.ChatStateEvent:
+			s.chatStateEvent(ev.ChatState)
+		case xmpp.RetractEvent:
+			s.retractLine(ev.Retraction)
+		case xmpp.ReactionEvent:
+			s.reactionEvent(ev.Reactions)
+		case xmpp.OccupantEvent, xmpp.SubjectEvent:
+			line = s.roomEvent(&ev)
+			if ev.Kind == xmpp.OccupantEvent {
+				conversation = ev.Occupant.Room
+				if conversation == s.contact {
+					s.updateOccupants()
+				}
+			} else {
+				conversation = ev.Subject.Room
+			}
+		case xmpp.InviteEvent:
+			line = s.inviteLine(ev.Invite)
+		case xmpp.DisconnectEvent:
+			line = "[red]connection lost: " + sanitizeLine(ev.Error) + "[-]"
+		}
+		// TODO: handle presence
+		if line != "" {
+			s.showIn(conversation, line)
+		}
+		s.unlock()
+	}
+}
+
+// receiveMessage shows the received msg in the buffer of its conversation,
+// or corrects the message it replaces. s.mutex must be held.
+func (s *state) receiveMessage(msg *xmpp.Message) {
+	l := s.messageLine(msg)
+	if msg.Replace == "" || !s.correct(l, msg.Replace) {
+		s.showLine(l)
+	}
+	s.acknowledge(msg)
+	if msg.Type != xmpp.Groupchat {
+		s.chatStateEvent(&xmpp.ChatState{From: msg.From, State: msg.ChatState})
+	}
+}
+
+// messageLine returns the line to show in the chat record for msg, with the
+// quote of the message it replies to (if any). Messages in rooms are prefixed
+// with the nickname of the sender. The sender and time are taken from the
+// metadata of msg, never from its text. s.mutex must be held.
+func (s *state) messageLine(msg *xmpp.Message) chatLine {
+	l := chatLine{text: msg.Text, id: msg.ID, stanzaID: msg.StanzaID, from: msg.From,
+		nick: localpart(msg.From), time: msg.Time}
+	if msg.ReplyID != "" {
+		l.quote, l.replyID = s.replyQuote(msg.From, msg), msg.ReplyID
+	}
+	if msg.Type == xmpp.Groupchat {
+		l.from += "/" + msg.Nick
+		l.nick = msg.Nick
+		l.prefix = msg.Nick + ": "
+	}
+	return l
+}
+
+// show shows line in the buffer of the current conversation.
+func (s *state) show(line string) {
+	s.mutex.Lock()
+	s.showLocked(line)
+	s.mutex.Unlock()
+}
+
+// showLocked is show with s.mutex held.
+func (s *state) showLocked(line string) {
+	s.showIn(s.contact, line)
+}
+
+// showIn shows line in the buffer of conversation (the current one, if
+// empty). s.mutex must be held.
+func (s *state) showIn(conversation, line string) {
+	if conversation == "" {
+		conversation = s.contact
+	}
+	s.addLine(conversation, chatLine{text: line, time: time.Now()})
+}
+
+// showLine shows the message line l in the buffer of its conversation.
+// s.mutex must be held.
+func (s *state) showLine(l chatLine) {
+	conversation := lineConversation(&l)
+	s.addLine(conversation, l)
+	s.countUnread(conversation, &l)
+}
+
+// showError shows err in the chat record. The error is sanitized, because
+// it may contain untrusted text.
+func (s *state) showError(err error) {
+	s.show("[red]" + sanitize(err.Error()) + "[-]")
+}
+
+// switchTo makes target the current conversation and shows its buffer. The
+// text of the compose area is kept as draft of the previous conversation
+// and replaced by the draft of target.
+func (s *state) switchTo(target string) {
+	s.mutex.Lock()
+	compose := s.compose
+	var draft string
+	switched := target != s.contact
+	if switched {
+		s.leaveConversation(s.contact, xmpp.Inactive)
+		s.correcting = ""
+		if compose != nil {
+			s.current().draft = compose.text()
+		}
+	}
+	s.contact = target
+	b := s.current()
+	b.unread = 0
+	b.highlight = false
+	draft, b.draft = b.draft, ""
+	if s.chatRecord != nil {
+		s.chatRecord.show(b.view)
+	}
+	s.updateHeader()
+	s.updateOccupants()
+	s.updateContactList()
+	s.markDisplayed(target)
+	s.restoring = true
+	s.unlock()
+	if switched && compose != nil {
+		compose.setText(draft)
+	}
+	s.mutex.Lock()
+	s.restoring = false
+	s.mutex.Unlock()
+}
+
+// updateHeader updates the frame of the main view with the current
+// conversation, the subject of the current room, the selected message, and
+// the chat state of the current contact. s.mutex must be held.
+func (s *state) updateHeader() {
+	if s.frame == nil {
+		return
+	}
+	contact, subject := sanitizeLine(s.contact), ""
+	if r := s.rooms[s.contact]; r != nil {
+		subject = r.subject
+		if r.info != nil {
+			contact += " " + encryptionStatus(r.info)
+		}
+	}
+	s.frame.Clear().
+		AddText(mole, true, tview.AlignCenter, tview.Styles.TertiaryTextColor).
+		AddText(s.username, true, tview.AlignLeft, tview.Styles.SecondaryTextColor).
+		AddText(contact, true, tview.AlignRight, tview.Styles.SecondaryTextColor).
+		AddText(sanitizeLine(subject), false, tview.AlignLeft, tview.Styles.SecondaryTextColor).
+		AddText(s.replyInfo(), false, tview.AlignCenter, tview.Styles.SecondaryTextColor).
+		AddText(s.typingInfo(), false, tview.AlignRight, tview.Styles.SecondaryTextColor)
+}
+
+// sendMessage sends text to the current conversation, as a correction of
+// our message s.correcting (if set) or as a reply to the selected message
+// (if any). The delivery state of chat messages is shown next to them.
+// Messages to rooms which cannot be encrypted are refused (see sendable).
+func (s *state) sendMessage(text string) error {
+	id, err := xmpp.NewID()
+	if err != nil {
+		s.fatal(err)
+	}
+	s.mutex.Lock()
+	if err := s.sendable(s.contact); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	msg := xmpp.Message{To: s.contact, Text: text, ID: id, Replace: s.correcting}
+	s.correcting = ""
+	line := chatLine{text: text, color: "blue", id: id, to: s.contact, state: xmpp.Sent,
+		nick: localpart(s.username), time: time.Now()}
+	if l := s.selectedLine(); l != nil && msg.Replace == "" {
+		msg.ReplyID, msg.ReplyTo = s.messageRef(l, s.contact)
+		if msg.ReplyID != "" {
+			msg.Quote = l.text
+			line.quote, line.replyID = l.text, msg.ReplyID
+		}
+	}
+	s.unselect()
+	if r := s.rooms[s.contact]; r != nil {
+		msg.Type = xmpp.Groupchat
+		line.state = ""
+		if o := r.self(); o != nil {
+			line.nick = o.Nick
+		}
+	} else if s.sendsChatStates(s.contact) {
+		s.stopPauseTimer()
+		s.chatStates[s.contact] = xmpp.Active
+		msg.ChatState = xmpp.Active
+	}
+	s.mutex.Unlock()
+	s.send <- msg
+	s.mutex.Lock()
+	if msg.Replace == "" || !s.correct(line, msg.Replace) {
+		s.addLine(line.to, line)
+	}
+	s.mutex.Unlock()
+	return nil
+}
+
+// chatLine is a line of the chat record: a system line (with neither sender
+// nor recipient) or a message. The texts of messages (including nicknames,
+// quotes, and reactions) are untrusted and sanitized when shown.
+type chatLine struct {
+	prefix    string    // nickname of sender of message received in room
+	nick      string    // nickname of sender (ours for sent messages), shown in actions
+	text      string    // text (with color tags for system lines)
+	time      time.Time // time of message or system line (zero if unknown)
+	color     string    // color of text (if any)
+	id        string    // ID of message (if any)
+	lastID    string    // ID of last correction of message (if any)
+	stanzaID  string    // ID assigned by room to received message (if any)
+	from      string    // sender of received message (nick in room as room/nick)
+	to        string    // recipient of sent message
+	state     string    // delivery state of sent message
+	edits     []string  // previous texts of corrected message
+	retracted string    // shown instead of text of retracted message
+	quote     string    // text of the message replied to (if any)
+	replyID   string    // ID of the message replied to (if any)
+
+	reactions map[string][]string // reactions to message by sender (empty for us)
+}
+
+// stateLabels are shown for the delivery states of sent messages.
+var stateLabels = map[string]string{
+	xmpp.Sent:      "sent",
+	xmpp.Acked:     "acked",
+	xmpp.Delivered: "delivered",
+	xmpp.Displayed: "read",
+}
+
+// mePrefix starts messages which are actions ("/me waves").
+const mePrefix = "/me "
+
+// String returns the line as shown in the chat record (without time).
+func (l chatLine) String() string {
+	if l.from == "" && l.to == "" {
+		return l.text
+	}
+	prefix := sanitizeLine(l.prefix)
+	if l.retracted != "" {
+		return prefix + "[gray](" + sanitize(l.retracted) + ")[-]"
+	}
+	body := l.text
+	if strings.HasPrefix(body, mePrefix) {
+		prefix, body = "* "+sanitizeLine(l.nick)+" ", strings.TrimPrefix(body, mePrefix)
+	}
+	body = sanitize(body)
+	text := prefix + body
+	if l.color != "" {
+		text = prefix + "[" + l.color + "]" + body + "[-]"
+	}
+	if l.quote != "" {
+		text = "[gray]> " + sanitizeLine(summary(l.quote)) + "[-]\n" + text
+	}
+	if len(l.edits) > 0 {
+		text += " [gray](edited)[-]"
+	}
+	if l.state != "" {
+		text += " [gray](" + stateLabels[l.state] + ")[-]"
+	}
+	if len(l.reactions) > 0 {
+		text += "\n    [gray]" + sanitizeLine(reactionsLine(l.reactions)) + "[-]"
+	}
+	return text
+}
+
+// render returns the line as written to the view of the chat record: l with
+// its time. Following lines of l are indented, so that they cannot be
+// mistaken for lines of their own.
+func (l chatLine) render() string {
+	text := l.String()
+	stamp := timestamp(l.time)
+	if stamp == "" {
+		return text
+	}
+	indent := strings.Repeat(" ", len(stamp)+1)
+	return "[gray]" + stamp + "[-] " + strings.Replace(text, "\n", "\n"+indent, -1)
+}
+
+// writeChat writes msg as a new line to the buffer of the current
+// conversation. s.mutex must be held.
+func (s *state) writeChat(msg string) {
+	s.showLocked(msg)
+}
+
+// addLine adds l to the buffer of conversation. s.mutex must be held.
+func (s *state) addLine(conversation string, l chatLine) {
+	b := s.buffer(conversation)
+	b.lines = append(b.lines, l)
+	if b.view == nil {
+		return
+	}
+	text := l.render()
+	if len(b.lines) > 1 {
+		text = "\n" + text
+	}
+	if _, err := io.WriteString(b.view, text); err != nil {
+		s.fatal(err)
+	}
+}
+
+// findLine returns the most recent line of the message with the given ID
+// from sender from (empty for sent messages) in conversation, or nil if
+// there is none. The ID of the last correction of a message refers to it as
+// well. s.mutex must be held.
+func (s *state) findLine(conversation, from, id string) *chatLine {
+	lines := s.buffer(conversation).lines
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := &lines[i]
+		if l.from == from && l.id != "" && (l.id == id || l.lastID == id) {
+			return l
+		}
+	}
+	return nil
+}
+
+// setState sets the delivery state of the message with the given ID sent
+// to contact and redraws its buffer, if the state is later than the current
+// one. s.mutex must be held.
+func (s *state) setState(contact, id, state string) {
+	l := s.findLine(contact, "", id)
+	if l == nil || !xmpp.Later(state, l.state) {
+		return
+	}
+	l.state = state
+	s.redraw(s.buffer(contact))
+}
+
+// acknowledge sends a delivery receipt for the received msg, if requested,
+// and a displayed marker, if its conversation is shown.
+// Otherwise the displayed marker is sent when switching to the
+// conversation. Nothing is sent if disabled in the settings.
+// s.mutex must be held (and released with unlock).
+func (s *state) acknowledge(msg *xmpp.Message) {
+	if msg.ID == "" || s.send == nil || s.hill == nil || s.hill.Settings.NoReceipts {
+		return
+	}
+	// the daemon sends delivery receipts when attached
+	if msg.Receipt && s.backend != nil {
+		s.queue(xmpp.Message{To: msg.From, ID: msg.ID, Marker: xmpp.Delivered})
+	}
+	if msg.Markable {
+		s.unread[msg.From] = msg.ID
+		if s.chatRecord != nil {
+			s.markDisplayed(s.contact)
+		}
+	}
+}
+
+// markDisplayed sends a displayed marker for the last markable message
+// received from contact, if it has not been marked already.
+// s.mutex must be held (and released with unlock).
+func (s *state) markDisplayed(contact string) {
+	id := s.unread[contact]
+	if id == "" || s.send == nil {
+		return
+	}
+	delete(s.unread, contact)
+	s.queue(xmpp.Message{To: contact, ID: id, Marker: xmpp.Displayed})
+}
+
+func (s *state) main() {
 	log.Println("main()")
 	account := s.hill.LastAccount()
 
 	contactList := tview.NewList().
-		ShowSecondaryText(false).
-		AddItem(account.Contact, "", 0, nil)
+		ShowSecondaryText(false)
 	contactList.SetBorder(true)
+	occupantList := tview.NewList().
+		ShowSecondaryText(false)
+	occupantList.SetBorder(true)
+	leftFlex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(contactList, 0, 1, false).
+		AddItem(occupantList, 0, 1, false)
 
-	chatRecord := tview.NewTextView()
-	chatRecord.SetBorder(true)
-	chatRecord.SetDynamicColors(true)
-	chatRecord.SetChangedFunc(func() {
-		s.app.Draw()
-	})
-	first := true
-	go func() {
-		for {
-			msg := <-recv
-			if !first {
-				msg = "\n" + msg
-			} else {
-				first = false
-			}
-			_, err := io.WriteString(chatRecord, msg)
-			if err != nil {
-				s.fatal(err)
-			}
-		}
-	}()
-
+	chatRecord := newRecordView()
 	innerFlex := tview.NewFlex().
-		AddItem(contactList, 0, 2, false).
+		AddItem(leftFlex, 0, 2, false).
 		AddItem(chatRecord, 0, 8, false)
 
 	frame := tview.NewFrame(innerFlex).
-		SetBorders(0, 0, 0, 0, 0, 0).
-		AddText(mole, true, tview.AlignCenter, tview.Styles.TertiaryTextColor).
-		AddText(account.Username, true, tview.AlignLeft, tview.Styles.SecondaryTextColor).
-		AddText("", false, tview.AlignLeft, tview.Styles.SecondaryTextColor)
+		SetBorders(0, 0, 0, 0, 0, 0)
 
-	inputField := tview.NewInputField()
-	inputField.SetDoneFunc(func(key tcell.Key) {
-		if key == tcell.KeyEnter {
-			msg := inputField.GetText()
-			if msg != "" {
-				send <- msg
-				inputField.SetText("")
-				msg = "[blue]" + msg + "[-]"
-				if !first {
-					msg = "\n" + msg
-				} else {
-					first = false
+	compose := newComposeArea()
+	inputField := compose.input
+
+	// contacts and rooms first, followed by other conversations
+	var order []string
+	for _, contact := range s.hill.AccountContacts(account.Username) {
+		order = append(order, contact.Remote)
+	}
+	for _, room := range s.hill.AccountRooms(account.Username) {
+		order = append(order, room.Room)
+	}
+
+	s.mutex.Lock()
+	s.chatRecord = chatRecord
+	s.frame = frame
+	s.occupantList = occupantList
+	s.contactList = contactList
+	s.compose = compose
+	s.username = account.Username
+	for _, c := range s.order {
+		if !contains(order, c) {
+			order = append(order, c)
+		}
+	}
+	s.order = order
+	for _, b := range s.buffers {
+		b.view = s.newView()
+		s.redraw(b)
+	}
+	b := s.current()
+	b.unread = 0
+	b.highlight = false
+	chatRecord.show(b.view)
+	s.updateHeader()
+	s.updateOccupants()
+	s.updateContactList()
+	s.markDisplayed(s.contact)
+	s.unlock()
+
+	contactList.SetSelectedFunc(func(i int, _, _ string, _ rune) {
+		s.switchToNumber(i)
+		s.app.SetFocus(inputField)
+	})
+	contactList.SetDoneFunc(func() {
+		s.app.SetFocus(inputField)
+	})
+	inputField.SetChangedFunc(func(string) {
+		s.typed(compose.text())
+	})
+	inputField.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch key := event.Key(); key {
+		case tcell.KeyCtrlL:
+			s.lock()
+			return nil
+		case tcell.KeyCtrlO:
+			// the hill cannot be changed when attached to a daemon
+			if s.backend != nil {
+				s.settings()
+			}
+			return nil
+		case tcell.KeyCtrlN, tcell.KeyCtrlP:
+			s.cycle(key == tcell.KeyCtrlP)
+			return nil
+		case tcell.KeyF1, tcell.KeyF2, tcell.KeyF3, tcell.KeyF4, tcell.KeyF5,
+			tcell.KeyF6, tcell.KeyF7, tcell.KeyF8, tcell.KeyF9:
+			s.switchToNumber(int(key - tcell.KeyF1))
+			return nil
+		case tcell.KeyRune:
+			// Alt-1 to Alt-9 switch like F1 to F9
+			if r := event.Rune(); event.Modifiers()&tcell.ModAlt != 0 && r >= '1' && r <= '9' {
+				s.switchToNumber(int(r - '1'))
+				return nil
+			}
+		case tcell.KeyPgUp, tcell.KeyPgDn:
+			chatRecord.scroll(event)
+			return nil
+		case tcell.KeyTab:
+			// complete (the contact list is focused on empty input)
+			text := inputField.GetText()
+			if text == "" {
+				return event
+			}
+			completed, candidates := s.complete(text)
+			if completed != text {
+				inputField.SetText(completed)
+			} else if len(candidates) > 0 {
+				s.show("[gray]" + sanitizeLine(strings.Join(candidates, " ")) + "[-]")
+			}
+			return nil
+		case tcell.KeyCtrlR:
+			// select message to reply to
+			s.mutex.Lock()
+			s.selectPrevious()
+			s.mutex.Unlock()
+			return nil
+		case tcell.KeyCtrlE:
+			// edit last message
+			if text := s.editLast(); text != "" {
+				compose.setText(text)
+			}
+			return nil
+		case tcell.KeyUp:
+			// edit last message on empty input, recall the history otherwise
+			if compose.text() == "" && !s.recalling() {
+				if text := s.editLast(); text != "" {
+					compose.setText(text)
+					return nil
 				}
-				_, err := io.WriteString(chatRecord, msg)
+			}
+			s.recall(false)
+			return nil
+		case tcell.KeyDown:
+			s.recall(true)
+			return nil
+		case tcell.KeyEnter:
+			// Alt-Enter starts a new line, Enter sends
+			if event.Modifiers()&tcell.ModAlt != 0 {
+				compose.newline()
+				return nil
+			}
+		case tcell.KeyCtrlJ:
+			compose.newline()
+			return nil
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if compose.joinLine() {
+				return nil
+			}
+		case tcell.KeyCtrlX:
+			s.editCompose()
+			return nil
+		}
+		return event
+	})
+	inputField.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEscape:
+			compose.setText("") // cancels correction
+			s.mutex.Lock()
+			s.unselect()
+			s.forgetRecall()
+			s.mutex.Unlock()
+		case tcell.KeyTab:
+			s.app.SetFocus(contactList)
+		case tcell.KeyEnter:
+			msg := compose.text()
+			if strings.TrimSpace(msg) == "" {
+				return
+			}
+			var c *command
+			var args []string
+			if isCommand(msg) {
+				var err error
+				c, args, err = parseCommand(msg)
 				if err != nil {
-					s.fatal(err)
+					s.showError(err) // keep the input to fix it
+					return
 				}
 			}
+			s.mutex.Lock()
+			s.remember(historyEntry(msg, c, args))
+			s.mutex.Unlock()
+			if c != nil {
+				// clear before, commands may switch to a conversation with a draft
+				compose.setText("")
+				s.command(c, args)
+				return
+			}
+			// clear after sending, which makes the chat state active
+			if err := s.sendMessage(strings.TrimPrefix(msg, "/")); err != nil {
+				s.showError(err) // keep the input
+				return
+			}
+			compose.setText("")
 		}
 	})
 
-	outerFlex := tview.NewFlex().
-		SetDirection(tview.FlexRow).
-		AddItem(frame, 0, 1, false).
-		AddItem(inputField, 1, 0, true)
+	layout := newMainLayout(frame, compose)
+	s.mainView = layout
+	s.setRoot(layout)
+	s.startIdleTimer()
+}
 
-	s.app.SetRoot(outerFlex, true).Draw()
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,396 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/xmpp"
+	"github.com/rivo/tview"
+)
+
+// rolePrefix returns the prefix of occupants with role in the occupant list.
+func rolePrefix(role string) string {
+	switch role {
+	case "moderator":
+		return "@"
+	case "participant":
+		return "+"
+	}
+	return " "
+}
+
+// roleRank orders the occupant list by role.
+var roleRank = map[string]int{"moderator": 0, "participant": 1, "visitor": 2}
+
+// updateOccupants shows the occupants of the current room in the occupant
+// list (empty if the current conversation is not a room).
+// s.mutex must be held.
+func (s *state) updateOccupants() {
+	if s.occupantList == nil {
+		return
+	}
+	s.occupantList.Clear()
+	r := s.rooms[s.contact]
+	if r == nil {
+		return
+	}
+	var occupants []*xmpp.Occupant
+	for _, o := range r.occupants {
+		occupants = append(occupants, o)
+	}
+	sort.Slice(occupants, func(i, j int) bool {
+		ri, rj := roleRank[occupants[i].Role], roleRank[occupants[j].Role]
+		if ri != rj {
+			return ri < rj
+		}
+		return occupants[i].Nick < occupants[j].Nick
+	})
+	for _, o := range occupants {
+		s.occupantList.AddItem(rolePrefix(o.Role)+sanitizeLine(o.Nick), "", 0, nil)
+	}
+}
+
+// occupantChange returns the line to show for the changed role or
+// affiliation of the known occupant o (previously old).
+func occupantChange(old, o *xmpp.Occupant) string {
+	if old.Role == o.Role && old.Affiliation == o.Affiliation {
+		return ""
+	}
+	line := fmt.Sprintf("%s: %s is now %s (%s)", sanitizeLine(o.Room),
+		sanitizeLine(o.Nick), sanitizeLine(o.Role), sanitizeLine(o.Affiliation))
+	if o.Reason != "" {
+		line += ": " + sanitizeLine(o.Reason)
+	}
+	return line
+}
+
+// removal returns the line to show for the occupant o which has been kicked
+// or banned from the room (empty if it left voluntarily).
+func removal(o *xmpp.Occupant) string {
+	var how string
+	switch {
+	case o.Banned:
+		how = "banned"
+	case o.Kicked:
+		how = "kicked"
+	default:
+		return ""
+	}
+	room := sanitizeLine(o.Room)
+	line := fmt.Sprintf("%s: %s has been %s", room, sanitizeLine(o.Nick), how)
+	if o.Self {
+		line = fmt.Sprintf("%s: you have been %s", room, how)
+	}
+	if o.Reason != "" {
+		line += ": " + sanitizeLine(o.Reason)
+	}
+	return line
+}
+
+// inviteLine remembers the invitation i and returns the line to show for it.
+// s.mutex must be held.
+func (s *state) inviteLine(i *xmpp.Invite) string {
+	if i.Password != "" {
+		s.invites[i.Room] = i.Password
+	}
+	room := sanitizeLine(i.Room)
+	line := fmt.Sprintf("%s invited you to %s", sanitizeLine(i.From), room)
+	if i.Reason != "" {
+		line += ": " + sanitizeLine(i.Reason)
+	}
+	return line + " (type '/join " + room + "' to accept)"
+}
+
+// async runs the request f in the background and shows its error (if any),
+// because requests to the room wait for a response.
+func (s *state) async(f func() error) {
+	go func() {
+		if err := f(); err != nil {
+			s.showError(err)
+		}
+	}()
+}
+
+// currentRoom returns the current conversation, if it is a joined room.
+func (s *state) currentRoom(cmd string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.isRoom(s.contact) {
+		return "", fmt.Errorf("%s: current conversation is not a room", cmd)
+	}
+	return s.contact, nil
+}
+
+// roomPassword returns the password of r. Passwords of a hill received from
+// a daemon are redacted and filled in by the daemon.
+func roomPassword(r *config.Room) []byte {
+	if string(r.Password) == config.Redacted {
+		return nil
+	}
+	return r.Password
+}
+
+// userJID returns the bare JID of user in room, which is either a JID or
+// the nickname of an occupant whose real JID is known.
+func (s *state) userJID(room, user string) (string, error) {
+	if strings.Contains(user, "@") {
+		u, err := jid.Parse(user)
+		if err != nil {
+			return "", err
+		}
+		return u.Bare().String(), nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var o *xmpp.Occupant
+	if r := s.rooms[room]; r != nil {
+		o = r.occupants[user]
+	}
+	if o == nil {
+		return "", fmt.Errorf("no occupant '%s' in room '%s'", user, room)
+	}
+	if o.JID == "" {
+		return "", fmt.Errorf("real JID of '%s' unknown, give JID instead", user)
+	}
+	u, err := jid.Parse(o.JID)
+	if err != nil {
+		return "", err
+	}
+	return u.Bare().String(), nil
+}
+
+// cmdRole implements "/kick", "/voice", "/devoice", and "/moderator" with
+// the arguments "<nick> [<reason>]".
+func (s *state) cmdRole(cmd, role string, args []string) error {
+	room, err := s.currentRoom(cmd)
+	if err != nil {
+		return err
+	}
+	reason := strings.Join(args[1:], " ")
+	s.async(func() error {
+		return s.session.SetRole(room, args[0], role, reason)
+	})
+	return nil
+}
+
+// cmdAffiliation implements "/ban", "/member", "/admin", "/owner", and
+// "/revoke" with the arguments "<nick|jid> [<reason>]".
+func (s *state) cmdAffiliation(cmd, affiliation string, args []string) error {
+	room, err := s.currentRoom(cmd)
+	if err != nil {
+		return err
+	}
+	user, err := s.userJID(room, args[0])
+	if err != nil {
+		return err
+	}
+	reason := strings.Join(args[1:], " ")
+	s.async(func() error {
+		return s.session.SetAffiliation(room, user, affiliation, reason)
+	})
+	return nil
+}
+
+// cmdInvite implements "/invite" (mediated) and "/dinvite" (direct) with
+// the arguments "<jid> [<reason>]". Direct invitations include the room
+// password.
+func (s *state) cmdInvite(cmd string, direct bool, args []string) error {
+	room, err := s.currentRoom(cmd)
+	if err != nil {
+		return err
+	}
+	user, err := jid.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	reason := strings.Join(args[1:], " ")
+	var password []byte
+	if r := s.hill.Room(s.hill.LastAccount().Username, room); r != nil {
+		password = roomPassword(r)
+	}
+	s.async(func() error {
+		var err error
+		if direct {
+			err = s.session.DirectInvite(room, user.String(), reason, password)
+		} else {
+			err = s.session.Invite(room, user.String(), reason)
+		}
+		if err == nil {
+			s.show(fmt.Sprintf("%s: invited %s", room, user))
+		}
+		return err
+	})
+	return nil
+}
+
+// cmdConfig implements "/config", which shows the configuration form of
+// the current room.
+func (s *state) cmdConfig(args []string) error {
+	room, err := s.currentRoom("/config")
+	if err != nil {
+		return err
+	}
+	s.async(func() error {
+		form, err := s.session.RoomConfig(room)
+		if err != nil {
+			return err
+		}
+		s.configForm(room, form)
+		return nil
+	})
+	return nil
+}
+
+// multiValued reports whether fields of type typ can have multiple values.
+func multiValued(typ string) bool {
+	return typ == "list-multi" || typ == "jid-multi" || typ == "text-multi"
+}
+
+// formValue returns the text representation of the values of field.
+func formValue(field *xmpp.FormField) string {
+	if field.Type == "boolean" {
+		return yesNo(len(field.Values) > 0 &&
+			(field.Values[0] == "1" || field.Values[0] == "true"))
+	}
+	if multiValued(field.Type) {
+		return strings.Join(field.Values, ", ")
+	}
+	if len(field.Values) > 0 {
+		return field.Values[0]
+	}
+	return ""
+}
+
+// setFormValue parses text as the values of field.
+func setFormValue(field *xmpp.FormField, text string) error {
+	text = strings.TrimSpace(text)
+	label := field.Label
+	if label == "" {
+		label = field.Var
+	}
+	field.Values = nil
+	switch {
+	case field.Type == "boolean":
+		switch text {
+		case "yes":
+			field.Values = []string{"1"}
+		case "no":
+			field.Values = []string{"0"}
+		default:
+			return fmt.Errorf("%s: must be yes or no", label)
+		}
+	case multiValued(field.Type):
+		for _, v := range strings.Split(text, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				field.Values = append(field.Values, v)
+			}
+		}
+	case text != "":
+		field.Values = []string{text}
+	}
+	if field.Required != nil && len(field.Values) == 0 {
+		return fmt.Errorf("%s: required", label)
+	}
+	for _, v := range field.Values {
+		if len(field.Options) > 0 && !validOption(field, v) {
+			return fmt.Errorf("%s: invalid option '%s'", label, v)
+		}
+	}
+	return nil
+}
+
+// validOption reports whether v is an option of field.
+func validOption(field *xmpp.FormField, v string) bool {
+	for _, o := range field.Options {
+		if o.Value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// configForm shows form to configure room. Boolean fields are edited as
+// yes/no, multiple values are separated by commas, and the options of list
+// fields are shown in their labels. The texts of the form are untrusted and
+// sanitized.
+func (s *state) configForm(room string, form *xmpp.Form) {
+	log.Println("configForm()")
+	texts := make(map[string]string)
+	tf := tview.NewForm()
+	for _, field := range form.Fields {
+		if field.Var == "" || field.Type == "hidden" || field.Type == "fixed" {
+			continue
+		}
+		name := field.Var
+		label := field.Label
+		if label == "" {
+			label = field.Var
+		}
+		label = sanitizeLine(label)
+		switch {
+		case field.Type == "boolean":
+			label += " (yes/no)"
+		case len(field.Options) > 0:
+			var options []string
+			for _, o := range field.Options {
+				options = append(options, sanitizeLine(o.Value))
+			}
+			label += " [" + strings.Join(options, "|") + "]"
+		}
+		texts[name] = formValue(&field)
+		changed := func(text string) {
+			texts[name] = text
+		}
+		if field.Type == "text-private" {
+			tf.AddPasswordField(label, texts[name], 0, '*', changed)
+		} else {
+			tf.AddInputField(label, texts[name], 0, nil, changed)
+		}
+	}
+
+	formFrame := tview.NewFrame(tf).SetBorders(0, 1, 0, 0, 0, 0)
+	showInfo := func(info string) {
+		formFrame.Clear()
+		formFrame.AddText(mole, true, tview.AlignCenter,
+			tview.Styles.TertiaryTextColor)
+		formFrame.AddText(info, false, tview.AlignLeft,
+			tview.Styles.SecondaryTextColor)
+	}
+	showInfo(sanitize(form.Instructions))
+
+	tf.AddButton("Save", func() {
+		for i := range form.Fields {
+			field := &form.Fields[i]
+			text, ok := texts[field.Var]
+			if !ok {
+				continue
+			}
+			if err := setFormValue(field, text); err != nil {
+				log.Println("invalid room configuration")
+				showInfo(sanitize(err.Error()))
+				s.app.Draw()
+				return
+			}
+		}
+		s.setRoot(s.mainView)
+		s.async(func() error {
+			err := s.session.ConfigureRoom(room, form)
+			if err == nil {
+				s.show(room + ": configuration saved")
+				s.fetchRoomInfo(room)
+			}
+			return err
+		})
+	}).
+		AddButton("Cancel", func() {
+			s.setRoot(s.mainView)
+		}).
+		SetBorder(true).
+		SetTitle("Configure " + sanitizeLine(room)).SetTitleAlign(tview.AlignLeft)
+
+	s.setRoot(formFrame)
+}
@@ -0,0 +1,223 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// isMessage reports whether l is a message which can be referred to (and
+// has not been retracted).
+func isMessage(l *chatLine) bool {
+	return (l.id != "" || l.stanzaID != "") && l.retracted == ""
+}
+
+// findMessage returns the most recent line of the message with the given
+// ID in the conversation with contact, or nil if there is none. Messages in
+// rooms are referred to by their stanza ID, our own ones by their ID.
+// s.mutex must be held.
+func (s *state) findMessage(contact, id string) *chatLine {
+	room := s.isRoom(contact)
+	lines := s.buffer(contact).lines
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := &lines[i]
+		if !isMessage(l) {
+			continue
+		}
+		own := l.from == "" && (l.id == id || l.lastID == id)
+		if own || room && l.stanzaID == id || !room && l.from != "" && l.id == id {
+			return l
+		}
+	}
+	return nil
+}
+
+// messageRef returns the ID by which the message of l is referred to in
+// the conversation with contact, and the JID of its author.
+// s.mutex must be held.
+func (s *state) messageRef(l *chatLine, contact string) (id, author string) {
+	if l.from == "" {
+		if r := s.rooms[contact]; r != nil {
+			if o := r.self(); o != nil {
+				return l.id, contact + "/" + o.Nick
+			}
+			return l.id, contact
+		}
+		return l.id, s.username
+	}
+	if s.isRoom(contact) {
+		return l.stanzaID, l.from
+	}
+	return l.id, l.from
+}
+
+// selectPrevious moves the selection to the previous message of the
+// current conversation, which is replied to when sending the input field.
+// The selection is cleared after the first message. s.mutex must be held.
+func (s *state) selectPrevious() {
+	b := s.current()
+	i := b.selected
+	if i < 0 {
+		i = len(b.lines)
+	}
+	for i--; i >= 0 && !isMessage(&b.lines[i]); i-- {
+	}
+	b.selected = i
+	s.redraw(b)
+	s.updateHeader()
+}
+
+// unselect clears the selection of a message in the current conversation.
+// s.mutex must be held.
+func (s *state) unselect() {
+	b := s.current()
+	if b.selected < 0 {
+		return
+	}
+	b.selected = -1
+	s.redraw(b)
+	s.updateHeader()
+}
+
+// selectedLine returns the selected message of the current conversation,
+// if there is one (and it has not been retracted in the meantime).
+// s.mutex must be held.
+func (s *state) selectedLine() *chatLine {
+	b := s.current()
+	if b.selected < 0 || b.selected >= len(b.lines) || !isMessage(&b.lines[b.selected]) {
+		return nil
+	}
+	return &b.lines[b.selected]
+}
+
+// replyInfo returns the info about the selected message shown in the
+// footer. s.mutex must be held.
+func (s *state) replyInfo() string {
+	l := s.selectedLine()
+	if l == nil {
+		return ""
+	}
+	return "replying to: " + sanitizeLine(summary(l.text))
+}
+
+// summary returns the first line of text, shortened if necessary.
+func summary(text string) string {
+	const maxLength = 40
+	text = strings.SplitN(text, "\n", 2)[0]
+	if r := []rune(text); len(r) > maxLength {
+		text = string(r[:maxLength]) + "…"
+	}
+	return text
+}
+
+// replyQuote returns the quote of the message msg replies to: the text of
+// the message, if it is shown, and the fallback of msg otherwise.
+// s.mutex must be held.
+func (s *state) replyQuote(contact string, msg *xmpp.Message) string {
+	if l := s.findMessage(contact, msg.ReplyID); l != nil {
+		return l.text
+	}
+	return msg.Quote
+}
+
+// reactionsLine returns the reactions to a message aggregated by reaction,
+// ordered by count.
+func reactionsLine(reactions map[string][]string) string {
+	count := make(map[string]int)
+	for _, rs := range reactions {
+		for _, r := range rs {
+			count[r]++
+		}
+	}
+	var keys []string
+	for r := range count {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if count[keys[i]] != count[keys[j]] {
+			return count[keys[i]] > count[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	var parts []string
+	for _, r := range keys {
+		parts = append(parts, fmt.Sprintf("%s %d", r, count[r]))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// setReactions sets the reactions of sender (empty for us) to l.
+func setReactions(l *chatLine, sender string, reactions []string) {
+	if len(reactions) == 0 {
+		delete(l.reactions, sender)
+		return
+	}
+	if l.reactions == nil {
+		l.reactions = make(map[string][]string)
+	}
+	l.reactions[sender] = reactions
+}
+
+// reactionEvent shows the reactions r under the message they refer to.
+// s.mutex must be held.
+func (s *state) reactionEvent(r *xmpp.Reactions) {
+	l := s.findMessage(r.From, r.ID)
+	if l == nil {
+		return
+	}
+	sender := r.From
+	switch {
+	case r.Own:
+		sender = ""
+	case r.Nick != "":
+		sender = r.Nick
+	}
+	setReactions(l, sender, r.Reactions)
+	s.redraw(s.buffer(r.From))
+}
+
+// cmdReact implements "/react <reaction>", which adds the reaction to the
+// selected message (or the last message of the current conversation), or
+// removes it if we already reacted with it.
+func (s *state) cmdReact(args []string) error {
+	s.mutex.Lock()
+	contact := s.contact
+	groupchat := s.isRoom(contact)
+	l := s.selectedLine()
+	lines := s.current().lines
+	for i := len(lines) - 1; l == nil && i >= 0; i-- {
+		if isMessage(&lines[i]) {
+			l = &lines[i]
+		}
+	}
+	var id string
+	var reactions []string
+	if l != nil {
+		id, _ = s.messageRef(l, contact)
+		removed := false
+		for _, r := range l.reactions[""] {
+			if r == args[0] {
+				removed = true
+			} else {
+				reactions = append(reactions, r)
+			}
+		}
+		if !removed {
+			reactions = append(reactions, args[0])
+		}
+	}
+	s.mutex.Unlock()
+	if id == "" {
+		return errors.New("/react: no message to react to")
+	}
+	if err := s.session.React(contact, id, groupchat, reactions); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.reactionEvent(&xmpp.Reactions{From: contact, ID: id, Own: true, Reactions: reactions})
+	s.mutex.Unlock()
+	return nil
+}
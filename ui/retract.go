@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// tombstone removes the content of the retracted line l of conversation
+// (including its edit history, the quotes of replies to it, and its entries
+// in the input history) and shows info instead. s.mutex must be held.
+func (s *state) tombstone(conversation string, l *chatLine, info string) {
+	b := s.buffer(conversation)
+	for i := range b.lines {
+		r := &b.lines[i]
+		if r.replyID != "" && s.findMessage(conversation, r.replyID) == l {
+			r.quote = ""
+		}
+	}
+	forgetHistory(b, append(l.edits, l.text))
+	l.text = ""
+	l.edits = nil
+	l.retracted = info
+}
+
+// retractLine replaces the line retracted by r with a tombstone.
+// s.mutex must be held.
+func (s *state) retractLine(r *xmpp.Retraction) {
+	var l *chatLine
+	switch {
+	case r.Own:
+		l = s.findLine(r.From, "", r.ID)
+	case s.rooms[r.From] != nil || r.Moderated || r.Nick != "":
+		lines := s.buffer(r.From).lines
+		for i := len(lines) - 1; i >= 0; i-- {
+			line := &lines[i]
+			if line.stanzaID == r.ID && (line.from == r.From+"/"+r.Nick ||
+				r.Moderated && strings.HasPrefix(line.from, r.From+"/")) {
+				l = line
+				break
+			}
+		}
+	default:
+		l = s.findLine(r.From, r.From, r.ID)
+	}
+	if l == nil || l.retracted != "" {
+		return
+	}
+	info := "message retracted"
+	if r.Moderated {
+		info += " by moderator"
+		if r.Reason != "" {
+			info += ": " + r.Reason
+		}
+	}
+	s.tombstone(r.From, l, info)
+	s.redraw(s.buffer(r.From))
+}
+
+// cmdRetract implements "/retract", which retracts our last message in the
+// current conversation.
+func (s *state) cmdRetract(args []string) error {
+	s.mutex.Lock()
+	contact := s.contact
+	groupchat := s.isRoom(contact)
+	var id string
+	if l := s.lastSent(contact); l != nil {
+		id = l.id
+	}
+	s.mutex.Unlock()
+	if id == "" {
+		return errors.New("/retract: no message to retract")
+	}
+	if err := s.session.Retract(contact, id, groupchat); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.retractLine(&xmpp.Retraction{From: contact, ID: id, Own: true})
+	s.mutex.Unlock()
+	return nil
+}
+
+// cmdModerate implements "/moderate <nick> [<reason>]", which retracts the
+// last message of occupant nick from the current room. It requires the
+// moderator role.
+func (s *state) cmdModerate(args []string) error {
+	room, err := s.currentRoom("/moderate")
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	o := s.rooms[room].self()
+	moderator := o != nil && o.Role == "moderator"
+	var id string
+	lines := s.buffer(room).lines
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := &lines[i]
+		if l.from == room+"/"+args[0] && l.stanzaID != "" && l.retracted == "" {
+			id = l.stanzaID
+			break
+		}
+	}
+	s.mutex.Unlock()
+	if !moderator {
+		return errors.New("/moderate: you are not a moderator of the room")
+	}
+	if id == "" {
+		return fmt.Errorf("/moderate: no message of '%s' to retract", args[0])
+	}
+	reason := strings.Join(args[1:], " ")
+	s.async(func() error {
+		return s.session.Moderate(room, id, reason)
+	})
+	return nil
+}
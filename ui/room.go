@@ -0,0 +1,319 @@
+package ui
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/xmpp"
+)
+
+// room is the state of a joined multi-user chat room.
+type room struct {
+	subject   string                    // current subject
+	joined    bool                      // our own presence has been received
+	occupants map[string]*xmpp.Occupant // by nickname
+	info      *xmpp.RoomInfo            // encryption relevant information (nil if unknown)
+}
+
+// self returns our own occupant of r (nil if we have not joined yet).
+func (r *room) self() *xmpp.Occupant {
+	for _, o := range r.occupants {
+		if o.Self {
+			return o
+		}
+	}
+	return nil
+}
+
+// roomEvent updates the room state for an occupant or subject event and
+// returns the line to show in the chat record (empty for none), with the
+// untrusted parts sanitized. s.mutex must be held.
+func (s *state) roomEvent(ev *xmpp.Event) string {
+	switch ev.Kind {
+	case xmpp.OccupantEvent:
+		o := ev.Occupant
+		r := s.rooms[o.Room]
+		if r == nil {
+			return "" // not joined by us (anymore)
+		}
+		room, nick := sanitizeLine(o.Room), sanitizeLine(o.Nick)
+		if o.Error != "" {
+			if o.Left {
+				delete(s.rooms, o.Room)
+			}
+			return fmt.Sprintf("[red]%s: %s[-]", room, sanitizeLine(o.Error))
+		}
+		if o.NewNick != "" {
+			// keep the occupant, the presence with the new nickname follows
+			delete(r.occupants, o.Nick)
+			renamed := *o
+			renamed.Nick, renamed.NewNick, renamed.Left = o.NewNick, "", false
+			r.occupants[o.NewNick] = &renamed
+			newNick := sanitizeLine(o.NewNick)
+			if o.Self {
+				return fmt.Sprintf("%s: you are now known as %s", room, newNick)
+			}
+			return fmt.Sprintf("%s: %s is now known as %s", room, nick, newNick)
+		}
+		if o.Left {
+			delete(r.occupants, o.Nick)
+			line := removal(o)
+			if o.Self {
+				delete(s.rooms, o.Room)
+				if line == "" {
+					line = fmt.Sprintf("%s: you left the room", room)
+				}
+				return line
+			}
+			if r.joined && line == "" {
+				line = fmt.Sprintf("%s: %s left", room, nick)
+			}
+			return line
+		}
+		old, known := r.occupants[o.Nick]
+		r.occupants[o.Nick] = o
+		if o.Self && !r.joined {
+			r.joined = true // initial occupant list is complete
+			go s.fetchRoomInfo(o.Room)
+			return fmt.Sprintf("%s: joined as %s (%d occupants)", room, nick,
+				len(r.occupants))
+		}
+		if r.joined && !known {
+			return fmt.Sprintf("%s: %s joined", room, nick)
+		}
+		if known {
+			return occupantChange(old, o)
+		}
+	case xmpp.SubjectEvent:
+		subj := ev.Subject
+		r := s.rooms[subj.Room]
+		if r == nil {
+			return ""
+		}
+		r.subject = subj.Text
+		if subj.Room == s.contact {
+			s.updateHeader()
+		}
+		room, text := sanitizeLine(subj.Room), sanitizeLine(subj.Text)
+		if subj.Nick == "" {
+			return fmt.Sprintf("%s: subject is '%s'", room, text)
+		}
+		return fmt.Sprintf("%s: %s set the subject to '%s'", room,
+			sanitizeLine(subj.Nick), text)
+	}
+	return ""
+}
+
+// joinRoom joins room r and makes it the current conversation.
+func (s *state) joinRoom(r *config.Room) error {
+	s.mutex.Lock()
+	if s.rooms[r.Room] == nil {
+		s.rooms[r.Room] = &room{occupants: make(map[string]*xmpp.Occupant)}
+	}
+	s.mutex.Unlock()
+	err := s.session.JoinRoom(r.Room, r.Nick, roomPassword(r), r.History)
+	if err != nil {
+		s.mutex.Lock()
+		delete(s.rooms, r.Room)
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+// fetchRoomInfo requests the information about room and shows its
+// encryption status in the header.
+func (s *state) fetchRoomInfo(room string) {
+	info, err := s.session.RoomInfo(room)
+	if err != nil {
+		log.Printf("requesting room info of %s failed: %v", room, err)
+		return
+	}
+	s.mutex.Lock()
+	if r := s.rooms[room]; r != nil {
+		r.info = info
+		if room == s.contact {
+			s.updateHeader()
+		}
+	}
+	s.mutex.Unlock()
+	s.app.Draw()
+}
+
+// encryptionStatus returns the encryption status of a room with info, as
+// shown in the header.
+func encryptionStatus(info *xmpp.RoomInfo) string {
+	if !info.Encryptable() {
+		return "(unencrypted: not members-only and non-anonymous)"
+	}
+	return "(unencrypted: OMEMO not supported yet)"
+}
+
+// sendable checks that messages can be sent to contact. Messages to rooms
+// are refused until the information about the room has been received and
+// as long as the room is not members-only and non-anonymous, because they
+// could not be encrypted.
+// s.mutex must be held.
+func (s *state) sendable(contact string) error {
+	r := s.rooms[contact]
+	switch {
+	case r == nil:
+		return nil
+	case r.info == nil:
+		return fmt.Errorf("cannot send to room '%s' before its information has "+
+			"been received", contact)
+	case !r.info.Encryptable():
+		return fmt.Errorf("messages to room '%s' cannot be encrypted, it is not "+
+			"members-only and non-anonymous", contact)
+	}
+	return nil
+}
+
+// autoJoin joins all rooms of account which should be joined automatically.
+func (s *state) autoJoin(account *config.Account) {
+	for _, r := range s.hill.AccountRooms(account.Username) {
+		if !r.AutoJoin {
+			continue
+		}
+		if err := s.joinRoom(&r); err != nil {
+			s.showError(fmt.Errorf("joining room '%s' failed: %v", r.Room, err))
+		}
+	}
+}
+
+// isRoom reports whether the JID target is a joined room.
+// s.mutex must be held.
+func (s *state) isRoom(target string) bool {
+	return s.rooms[target] != nil
+}
+
+// cmdJoin implements "/join <room> [<nick> [<password>]]". The room is added
+// to the hill and bookmarked (to be joined automatically), unless the UI is
+// attached to a daemon.
+func (s *state) cmdJoin(args []string) error {
+	rj, err := jid.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	account := s.hill.LastAccount()
+	r := config.Room{
+		Room:     rj.Bare().String(),
+		Local:    account.Username,
+		History:  config.DefaultRoomHistory,
+		AutoJoin: true,
+	}
+	if aj, err := jid.Parse(account.Username); err == nil {
+		r.Nick = aj.Local
+	}
+	existing := s.hill.Room(r.Local, r.Room)
+	if existing != nil {
+		r = *existing
+	}
+	if len(args) > 1 {
+		r.Nick = args[1]
+	}
+	if len(args) > 2 {
+		r.Password = config.Secret(args[2])
+	} else if len(r.Password) == 0 {
+		s.mutex.Lock()
+		r.Password = config.Secret(s.invites[r.Room])
+		s.mutex.Unlock()
+	}
+	if existing == nil && s.backend != nil {
+		if err := s.hill.AddRoom(r); err != nil {
+			return err
+		}
+		if b, err := s.bookmarker(); err == nil {
+			s.publishBookmark(b, &r)
+		}
+		s.save()
+	}
+	if err := s.joinRoom(&r); err != nil {
+		return err
+	}
+	s.switchTo(r.Room)
+	return nil
+}
+
+// cmdLeave implements "/leave [<room>]". The room is removed from the hill
+// and its bookmarks.
+func (s *state) cmdLeave(args []string) error {
+	s.mutex.Lock()
+	target := s.contact
+	s.mutex.Unlock()
+	if len(args) == 1 {
+		rj, err := jid.Parse(args[0])
+		if err != nil {
+			return err
+		}
+		target = rj.Bare().String()
+	}
+	s.mutex.Lock()
+	joined := s.isRoom(target)
+	s.mutex.Unlock()
+	if !joined {
+		return fmt.Errorf("not in room '%s'", target)
+	}
+	if err := s.session.LeaveRoom(target); err != nil {
+		return err
+	}
+	account := s.hill.LastAccount()
+	if r := s.hill.Room(account.Username, target); s.backend != nil && r != nil {
+		if b, err := s.bookmarker(); err == nil {
+			s.retractBookmark(b, r)
+		}
+		if err := s.hill.RemoveRoom(account.Username, target); err != nil {
+			return err
+		}
+		s.save()
+	}
+	s.mutex.Lock()
+	current := s.contact == target
+	s.mutex.Unlock()
+	if current {
+		var contact string
+		if contacts := s.hill.AccountContacts(account.Username); len(contacts) > 0 {
+			contact = contacts[0].Remote
+		}
+		s.switchTo(contact)
+	}
+	return nil
+}
+
+// cmdTopic implements "/topic <subject>".
+func (s *state) cmdTopic(args []string) error {
+	s.mutex.Lock()
+	target := s.contact
+	joined := s.isRoom(target)
+	s.mutex.Unlock()
+	if !joined {
+		return fmt.Errorf("/topic: current conversation is not a room")
+	}
+	return s.session.SetSubject(target, strings.Join(args, " "))
+}
+
+// cmdWho implements "/who", which lists the occupants of the current room
+// with their roles and affiliations.
+func (s *state) cmdWho(args []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := s.rooms[s.contact]
+	if r == nil {
+		return fmt.Errorf("/who: current conversation is not a room")
+	}
+	var nicks []string
+	for nick := range r.occupants {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	s.writeChat(fmt.Sprintf("%s: %d occupants", sanitizeLine(s.contact), len(nicks)))
+	for _, nick := range nicks {
+		o := r.occupants[nick]
+		s.writeChat(fmt.Sprintf("  %s (%s, %s)", sanitizeLine(nick),
+			sanitizeLine(o.Role), sanitizeLine(o.Affiliation)))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// isUnsafe reports whether r must not be shown: control characters other
+// than newline and tab (which could control the terminal) and bidirectional
+// formatting characters (which could reorder the text around them).
+func isUnsafe(r rune) bool {
+	switch {
+	case r == '\n' || r == '\t':
+		return false
+	case r < 0x20 || r >= 0x7f && r < 0xa0:
+		return true
+	case r == 0x061c || r == 0x200e || r == 0x200f:
+		return true
+	case r >= 0x202a && r <= 0x202e || r >= 0x2066 && r <= 0x2069:
+		return true
+	}
+	return false
+}
+
+// sanitize returns untrusted text (received from the network) such that it
+// is shown verbatim in views with dynamic colors: unsafe characters are
+// removed and color tags are escaped.
+func sanitize(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if isUnsafe(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return tview.Escape(text)
+}
+
+// sanitizeLine is sanitize for untrusted text shown on a single line (like
+// nicknames, JIDs, and subjects), with newlines replaced by spaces.
+func sanitizeLine(text string) string {
+	return sanitize(strings.Replace(text, "\n", " ", -1))
+}
+
+// timestamp returns the time t as shown before lines of the chat record
+// (empty if t is zero). The date is included if t is not today.
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	t = t.Local()
+	format := "15:04"
+	y, m, d := t.Date()
+	if ny, nm, nd := time.Now().Date(); y != ny || m != nm || d != nd {
+		format = "2006-01-02 15:04"
+	}
+	return t.Format(format)
+}
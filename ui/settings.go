@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"strconv"
+
+	"github.com/frankbraun/codechain/util/log"
+	"github.com/rivo/tview"
+)
+
+// yesNo returns the form representation of b.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func (s *state) settings() {
+	log.Println("settings()")
+	settings := s.hill.Settings
+	keepConnected := yesNo(settings.KeepConnected)
+	receipts := yesNo(!settings.NoReceipts)
+	form := tview.NewForm().
+		AddInputField("Idle timeout (minutes, 0 disables)",
+			strconv.Itoa(settings.IdleTimeout), 0, tview.InputFieldInteger,
+			func(text string) {
+				settings.IdleTimeout, _ = strconv.Atoi(text)
+			}).
+		AddInputField("Stay connected when locked (yes/no)", keepConnected, 0,
+			nil, func(text string) {
+				keepConnected = text
+			}).
+		AddInputField("Send delivery receipts and read markers (yes/no)", receipts,
+			0, nil, func(text string) {
+				receipts = text
+			})
+
+	formFrame := tview.NewFrame(form).SetBorders(0, 1, 0, 0, 0, 0)
+	formFrame.AddText(mole, true, tview.AlignCenter,
+		tview.Styles.TertiaryTextColor)
+	formFrame.AddText("", false, tview.AlignLeft,
+		tview.Styles.SecondaryTextColor)
+
+	form.AddButton("Save", func() {
+		if settings.IdleTimeout < 0 ||
+			(keepConnected != "yes" && keepConnected != "no") ||
+			(receipts != "yes" && receipts != "no") {
+			formFrame.Clear()
+			formFrame.AddText(mole, true, tview.AlignCenter,
+				tview.Styles.TertiaryTextColor)
+			log.Println("invalid settings")
+			formFrame.AddText("invalid settings", false,
+				tview.AlignLeft, tview.Styles.SecondaryTextColor)
+			s.app.Draw()
+			return
+		}
+		settings.KeepConnected = keepConnected == "yes"
+		settings.NoReceipts = receipts == "no"
+		s.hill.Settings = settings
+		s.save()
+		s.setRoot(s.mainView)
+		s.startIdleTimer()
+	}).
+		AddButton("Cancel", func() {
+			s.setRoot(s.mainView)
+		}).
+		SetBorder(true).
+		SetTitle("Settings").SetTitleAlign(tview.AlignLeft)
+
+	s.setRoot(formFrame)
+}
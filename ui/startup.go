@@ -1,17 +1,17 @@
 package ui
 
 import (
+	"bytes"
 	"fmt"
-	"os"
 
 	"github.com/frankbraun/codechain/util/log"
 	"github.com/frankbraun/mole/config"
 	"github.com/frankbraun/mole/storage"
-	"github.com/frankbraun/mole/xmpp"
+	"github.com/frankbraun/mole/util"
 	"github.com/rivo/tview"
 )
 
-func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Primitive {
+func (s *state) startup(create bool, dump *DumpOptions) tview.Primitive {
 	logoWidth, logoHeight := logoSize()
 	logoBox := tview.NewTextView().
 		SetTextColor(tview.Styles.TertiaryTextColor)
@@ -30,17 +30,25 @@ func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Pri
 		s.app.Draw()
 	}()
 
+	// Passphrases are kept in byte buffers which are wiped after use. The
+	// password fields still hold a string copy until the form is discarded.
 	var (
-		passphrase  string
-		passphrase2 string
+		passphrase  []byte
+		passphrase2 []byte
 	)
+	wipe := func() {
+		util.Wipe(passphrase)
+		util.Wipe(passphrase2)
+	}
 	form := tview.NewForm().
 		AddPasswordField("Passphrase", "", 0, '*', func(text string) {
-			passphrase = text
+			util.Wipe(passphrase)
+			passphrase = []byte(text)
 		})
 	if create {
 		form.AddPasswordField("Repeat", "", 0, '*', func(text string) {
-			passphrase2 = text
+			util.Wipe(passphrase2)
+			passphrase2 = []byte(text)
 		})
 	}
 
@@ -57,7 +65,7 @@ func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Pri
 		openString = "Create"
 		confirmString = "Save"
 		confirmFunc = func() {
-			if passphrase != passphrase2 {
+			if !bytes.Equal(passphrase, passphrase2) {
 				formFrame.Clear()
 				log.Println("passphrases do not match")
 				formFrame.AddText("passphrases do not match", false,
@@ -69,12 +77,13 @@ func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Pri
 				if err != nil {
 					s.fatal(err)
 				}
-				s.state, err = storage.Create(hillFile, passphrase,
+				s.state, err = storage.Create(s.backend, passphrase,
 					s.hill.Marshal())
+				wipe()
 				if err != nil {
 					s.fatal(err)
 				}
-				s.accountAdd(xmppDebug)
+				s.accountAdd()
 			}
 		}
 	} else {
@@ -85,7 +94,8 @@ func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Pri
 				data []byte
 				err  error
 			)
-			s.state, data, err = storage.Open(hillFile, passphrase)
+			s.state, data, err = storage.Open(s.backend, passphrase)
+			wipe()
 			if err != nil {
 				formFrame.Clear()
 				log.Println(err)
@@ -94,35 +104,30 @@ func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Pri
 				s.app.Draw()
 				return
 			}
-			s.hill, err = config.Unmarshal(data)
+			var warnings []string
+			s.hill, warnings, err = config.Unmarshal(data)
+			util.Wipe(data)
 			if err != nil {
-				s.fatal(err) // should never happen
+				s.fatal(err)
+			}
+			for _, warning := range warnings {
+				log.Println(warning)
 			}
-			if dump {
-				s.app.Suspend(func() {
-					fmt.Println(string(s.hill.MarshalIndent()))
-					os.Exit(0)
-				})
+			if dump != nil {
+				s.dump(dump)
 			}
 			account := s.hill.LastAccount()
 			if account == nil {
-				s.accountAdd(xmppDebug)
+				s.accountAdd()
 				return
 			}
-			// TODO: move somewhere else
-			send := make(chan string)
-			recv := make(chan string)
 			status := fmt.Sprintf("opening XMPP connection for '%s'...", account.Username)
 			formFrame.Clear()
 			log.Println(status)
 			formFrame.AddText(status, false,
 				tview.AlignLeft, tview.Styles.SecondaryTextColor)
 			s.app.Draw()
-			if err := xmpp.Start(account, send, recv, xmppDebug); err != nil {
-				s.fatal(err)
-			}
-			log.Println("established.")
-			s.main(send, recv)
+			s.startXMPP(account)
 		}
 	}
 
@@ -130,7 +135,7 @@ func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Pri
 		AddButton("Abort", func() {
 			s.app.Stop()
 		}).
-		SetBorder(true).SetTitle(openString + " " + hillFile).
+		SetBorder(true).SetTitle(openString + " " + s.backend.Name()).
 		SetTitleAlign(tview.AlignLeft)
 
 	// create a flex layout that centers the logo and subtitle
@@ -147,12 +152,12 @@ func (s *state) startup(hillFile string, create, dump, xmppDebug bool) tview.Pri
 	return flex
 }
 
-func (s *state) setup(hillFile string, xmppDebug bool) tview.Primitive {
+func (s *state) setup() tview.Primitive {
 	log.Println("setup()")
-	return s.startup(hillFile, true, false, xmppDebug)
+	return s.startup(true, nil)
 }
 
-func (s *state) login(hillFile string, dump, xmppDebug bool) tview.Primitive {
+func (s *state) login(dump *DumpOptions) tview.Primitive {
 	log.Println("login()")
-	return s.startup(hillFile, false, dump, xmppDebug)
+	return s.startup(false, dump)
 }
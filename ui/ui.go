@@ -2,24 +2,244 @@
 package ui
 
 import (
-	"os"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/frankbraun/codechain/util/log"
 	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/daemon"
+	"github.com/frankbraun/mole/hook"
 	"github.com/frankbraun/mole/storage"
 	"github.com/frankbraun/mole/util"
+	"github.com/frankbraun/mole/xmpp"
+	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
 )
 
+// session is a running XMPP session.
+type session interface {
+	SetPresence(show, status string) error
+	JoinRoom(room, nick string, password []byte, history int) error
+	LeaveRoom(room string) error
+	ChangeNick(room, nick string) error
+	SetSubject(room, subject string) error
+	SetRole(room, nick, role, reason string) error
+	SetAffiliation(room, user, affiliation, reason string) error
+	Invite(room, user, reason string) error
+	DirectInvite(room, user, reason string, password []byte) error
+	RoomInfo(room string) (*xmpp.RoomInfo, error)
+	RoomConfig(room string) (*xmpp.Form, error)
+	ConfigureRoom(room string, form *xmpp.Form) error
+	Retract(to, id string, groupchat bool) error
+	Moderate(room, id, reason string) error
+	React(to, id string, groupchat bool, reactions []string) error
+	Close() error
+}
+
+// xmppStartFunc starts an XMPP session (see xmpp.Start).
+type xmppStartFunc func(account *config.Account, send <-chan xmpp.Message,
+	recv chan<- xmpp.Event, debug bool) (session, error)
+
+// startSession starts an XMPP session with xmpp.Start.
+func startSession(
+	account *config.Account,
+	send <-chan xmpp.Message,
+	recv chan<- xmpp.Event,
+	debug bool,
+) (session, error) {
+	sess, err := xmpp.Start(account, send, recv, debug)
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// editFunc edits text in an external editor and returns the result.
+type editFunc func(text string) (string, error)
+
 // state of UI.
 type state struct {
-	app   *tview.Application // the "application"
-	state *storage.State     // state of storage backend
-	hill  *config.Hill       // entire date of running Mole instance
+	app       *tview.Application // the "application"
+	root      tview.Primitive    // current root primitive of app (protected by mutex)
+	mainView  tview.Primitive    // root primitive of main view
+	backend   storage.Backend    // storage backend of .hill file (nil if attached)
+	state     *storage.State     // state of storage backend
+	hill      *config.Hill       // entire date of running Mole instance
+	session   session            // running XMPP session
+	send      chan xmpp.Message  // send channel of XMPP session (closed with mutex held)
+	xmppStart xmppStartFunc      // starts XMPP client (replaced in tests)
+	xmppDebug bool               // enable XMPP debugging
+	editor    editFunc           // edits text in an external editor (replaced in tests)
+
+	mutex        sync.Mutex         // protects the following fields
+	chatRecord   *recordView        // shows the buffer of the current conversation (nil if locked)
+	frame        *tview.Frame       // frame of main view (nil if locked)
+	occupantList *tview.List        // occupants of current room (nil if locked)
+	contactList  *tview.List        // conversations (nil if locked)
+	compose      *composeArea       // input area of main view (nil if locked)
+	username     string             // username of account shown in main view
+	contact      string             // bare JID of current conversation (contact or room)
+	rooms        map[string]*room   // joined rooms
+	invites      map[string]string  // passwords of rooms we have been invited to
+	buffers      map[string]*buffer // buffers of conversations ("" for the console)
+	order        []string           // conversations in the order shown in the contact list
+	unread       map[string]string  // ID of last markable message by sender
+	chatStates   map[string]string  // our chat state sent by contact
+	typing       map[string]string  // chat state received by contact
+	pauseTimer   *time.Timer        // changes our chat state from composing to paused
+	correcting   string             // ID of our message corrected by the compose area
+	restoring    bool               // the compose area is set to the draft of a buffer
+	lastActivity time.Time          // time of last key event
+	idleTimer    *time.Timer        // auto-lock timer
+	outbox       []xmpp.Message     // messages queued to be sent by unlock
+}
+
+func newState(backend storage.Backend, xmppDebug bool) *state {
+	s := &state{
+		app:       tview.NewApplication(),
+		backend:   backend,
+		xmppStart: startSession,
+		xmppDebug: xmppDebug,
+	}
+	s.editor = s.editExternal
+	s.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		s.touch()
+		return event
+	})
+	return s
+}
+
+// setRoot sets root as the new root primitive of the application and draws it.
+func (s *state) setRoot(root tview.Primitive) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.root = root
+	s.app.SetRoot(root, true).Draw()
+}
+
+// save the hill to the storage backend.
+func (s *state) save() {
+	data := s.hill.Marshal()
+	defer util.Wipe(data)
+	if err := s.state.Save(data); err != nil {
+		s.fatal(err)
+	}
+}
+
+// startXMPP starts the XMPP session for account and shows the main view.
+// If the session has been kept connected while the UI was locked, it is
+// reused.
+func (s *state) startXMPP(account *config.Account) {
+	if s.send == nil {
+		var contact string
+		if contacts := s.hill.AccountContacts(account.Username); len(contacts) > 0 {
+			contact = contacts[0].Remote
+		}
+		s.mutex.Lock()
+		s.contact = contact
+		s.rooms = make(map[string]*room)
+		s.invites = make(map[string]string)
+		s.unread = make(map[string]string)
+		s.chatStates = make(map[string]string)
+		s.typing = make(map[string]string)
+		s.buffers = make(map[string]*buffer)
+		s.order = nil
+		s.mutex.Unlock()
+		send := make(chan xmpp.Message)
+		recv := make(chan xmpp.Event)
+		session, err := s.xmppStart(account, send, recv, s.xmppDebug)
+		if err != nil {
+			s.fatal(err)
+		}
+		log.Println("established.")
+		var hooks *hook.Runner
+		if s.backend != nil { // the daemon runs the hooks if attached
+			hooks = hook.New(&s.hill.Settings)
+		}
+		s.session = session
+		s.mutex.Lock()
+		s.send = send
+		s.mutex.Unlock()
+		go s.receive(recv, hooks)
+		if s.backend != nil { // the daemon joins the rooms if attached
+			s.syncBookmarks(account)
+			s.autoJoin(account)
+		}
+	}
+	s.main()
 }
 
-func newState() *state {
-	return &state{app: tview.NewApplication()}
+// stopXMPP closes the running XMPP session.
+func (s *state) stopXMPP() {
+	if s.session != nil {
+		if err := s.session.Close(); err != nil {
+			log.Printf("closing XMPP session failed: %v", err)
+		}
+		s.session = nil
+	}
+	s.mutex.Lock()
+	if s.send != nil {
+		close(s.send)
+		s.send = nil
+	}
+	s.mutex.Unlock()
+}
+
+// queue queues msg to be sent once s.mutex is released with unlock, because
+// sending blocks until the XMPP session takes the message.
+// s.mutex must be held.
+func (s *state) queue(msg xmpp.Message) {
+	s.outbox = append(s.outbox, msg)
+}
+
+// unlock releases s.mutex and sends the queued messages.
+func (s *state) unlock() {
+	outbox, send := s.outbox, s.send
+	s.outbox = nil
+	s.mutex.Unlock()
+	for _, msg := range outbox {
+		send <- msg
+	}
+}
+
+// lock wipes all secrets and messages from memory, and returns to the
+// passphrase screen. The XMPP session is closed, unless it should be kept
+// connected according to the settings. If the UI is attached to a daemon,
+// locking detaches from it and stops the UI instead.
+func (s *state) lock() {
+	log.Println("lock()")
+	s.stopIdleTimer()
+	s.mutex.Lock()
+	s.leaveConversations()
+	s.unlock()
+	if s.backend == nil {
+		s.stopXMPP()
+		s.app.Stop()
+		return
+	}
+	if s.hill == nil || !s.hill.Settings.KeepConnected {
+		s.stopXMPP()
+	}
+	s.mutex.Lock()
+	s.chatRecord = nil
+	s.buffers = make(map[string]*buffer)
+	s.order = nil
+	s.frame = nil
+	s.occupantList = nil
+	s.contactList = nil
+	s.compose = nil
+	s.mutex.Unlock()
+	s.mainView = nil
+	if s.hill != nil {
+		s.hill.Wipe()
+		s.hill = nil
+	}
+	if s.state != nil {
+		s.state.Wipe()
+		s.state = nil
+	}
+	s.setRoot(s.login(nil))
 }
 
 // fatal function to abort running application.
@@ -34,14 +254,48 @@ func (s *state) fatal(err error) {
 	}
 }
 
-// Run user interface on hillFile.
-func Run(hillFile string, dump, xmppDebug bool) error {
-	s := newState()
-	var root tview.Primitive
-	if _, err := os.Stat(hillFile); err != nil {
-		root = s.setup(hillFile, xmppDebug) // create .hill file
+// Run user interface on .hill file stored in backend.
+// If dump is not nil, the hill is dumped after decryption.
+func Run(backend storage.Backend, dump *DumpOptions, xmppDebug bool) error {
+	s := newState(backend, xmppDebug)
+	exists, err := backend.Exists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		s.setRoot(s.setup()) // create .hill file
 	} else {
-		root = s.login(hillFile, dump, xmppDebug) // open .hill file
+		s.setRoot(s.login(dump)) // open .hill file
+	}
+	return s.app.Run()
+}
+
+// Attach user interface to the daemon client is connected to. The XMPP
+// session of the last account in the daemon is used, locking the user
+// interface detaches from the daemon.
+func Attach(client *daemon.Client) error {
+	hill, err := client.Hill()
+	if err != nil {
+		return err
+	}
+	account := hill.LastAccount()
+	if account == nil {
+		return errors.New("ui: daemon has no account")
+	}
+	s := newState(nil, false)
+	s.hill = hill
+	s.xmppStart = func(
+		account *config.Account,
+		send <-chan xmpp.Message,
+		recv chan<- xmpp.Event,
+		debug bool,
+	) (session, error) {
+		a, err := client.Start(account, send, recv, debug)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
 	}
-	return s.app.SetRoot(root, true).Run()
+	s.startXMPP(account)
+	return s.app.Run()
 }
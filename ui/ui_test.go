@@ -0,0 +1,1311 @@
+package ui
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/storage"
+	"github.com/frankbraun/mole/xmpp"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+var passphrase = []byte("Staatsgeheimnis")
+
+// driver simulates the event loop of tview.Application without a screen.
+type driver struct {
+	s     *state
+	focus tview.Primitive
+}
+
+func newDriver(s *state, root tview.Primitive) *driver {
+	s.root = root
+	d := &driver{s: s}
+	d.setFocus(root)
+	return d
+}
+
+func (d *driver) setFocus(p tview.Primitive) {
+	d.focus = p
+	p.Focus(d.setFocus)
+}
+
+// key sends a key event to the primitive in focus. If the root primitive
+// changes as a result, the focus is moved to the new root.
+func (d *driver) key(key tcell.Key, r rune) {
+	d.keyMod(key, r, tcell.ModNone)
+}
+
+// keyMod is key with the modifier keys mod pressed.
+func (d *driver) keyMod(key tcell.Key, r rune, mod tcell.ModMask) {
+	root := d.root()
+	d.focus.InputHandler()(tcell.NewEventKey(key, r, mod), d.setFocus)
+	if newRoot := d.root(); newRoot != root {
+		d.setFocus(newRoot)
+	}
+}
+
+// root returns the current root primitive.
+func (d *driver) root() tview.Primitive {
+	d.s.mutex.Lock()
+	defer d.s.mutex.Unlock()
+	return d.s.root
+}
+
+// text types text into the primitive in focus, replacing its content.
+func (d *driver) text(text string) {
+	d.key(tcell.KeyCtrlU, 0)
+	for _, r := range text {
+		d.key(tcell.KeyRune, r)
+	}
+}
+
+// fakeSession records the calls of its methods.
+type fakeSession struct {
+	mutex     sync.Mutex
+	calls     []string
+	sent      []xmpp.Message
+	bookmarks []config.Room
+	open      map[string]bool // rooms which are not members-only
+}
+
+func (f *fakeSession) record(call string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *fakeSession) SetPresence(show, status string) error {
+	return f.record("presence " + show + " " + status)
+}
+
+func (f *fakeSession) JoinRoom(room, nick string, password []byte, history int) error {
+	return f.record(fmt.Sprintf("join %s/%s %s %d", room, nick, password, history))
+}
+
+func (f *fakeSession) LeaveRoom(room string) error {
+	return f.record("leave " + room)
+}
+
+func (f *fakeSession) ChangeNick(room, nick string) error {
+	return f.record("nick " + room + " " + nick)
+}
+
+func (f *fakeSession) SetSubject(room, subject string) error {
+	return f.record("subject " + room + " " + subject)
+}
+
+func (f *fakeSession) SetRole(room, nick, role, reason string) error {
+	return f.record(fmt.Sprintf("role %s %s %s %s", room, nick, role, reason))
+}
+
+func (f *fakeSession) SetAffiliation(room, user, affiliation, reason string) error {
+	return f.record(fmt.Sprintf("affiliation %s %s %s %s", room, user, affiliation, reason))
+}
+
+func (f *fakeSession) Invite(room, user, reason string) error {
+	return f.record(fmt.Sprintf("invite %s %s %s", room, user, reason))
+}
+
+func (f *fakeSession) DirectInvite(room, user, reason string, password []byte) error {
+	return f.record(fmt.Sprintf("direct-invite %s %s %s %s", room, user, reason, password))
+}
+
+func (f *fakeSession) RoomInfo(room string) (*xmpp.RoomInfo, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return &xmpp.RoomInfo{Room: room, MembersOnly: !f.open[room], NonAnonymous: true}, nil
+}
+
+func (f *fakeSession) RoomConfig(room string) (*xmpp.Form, error) {
+	f.record("config " + room)
+	return &xmpp.Form{
+		Type: "form",
+		Fields: []xmpp.FormField{
+			{Var: "FORM_TYPE", Type: "hidden", Values: []string{"muc#roomconfig"}},
+			{Var: "persistent", Type: "boolean", Label: "[red]Persistent\u202e", Values: []string{"0"}},
+			{Var: "whois", Type: "list-single", Values: []string{"moderators"},
+				Options: []xmpp.FormOption{{Value: "moderators"}, {Value: "anyone"}}},
+		},
+	}, nil
+}
+
+func (f *fakeSession) ConfigureRoom(room string, form *xmpp.Form) error {
+	var values []string
+	for _, field := range form.Fields {
+		values = append(values, field.Var+"="+strings.Join(field.Values, ","))
+	}
+	return f.record("configure " + room + " " + strings.Join(values, " "))
+}
+
+func (f *fakeSession) Bookmarks() ([]config.Room, error) {
+	return f.bookmarks, nil
+}
+
+func (f *fakeSession) PublishBookmark(r *config.Room) error {
+	return f.record("bookmark " + r.Room + " " + r.Name)
+}
+
+func (f *fakeSession) RetractBookmark(room string) error {
+	return f.record("unbookmark " + room)
+}
+
+func (f *fakeSession) Retract(to, id string, groupchat bool) error {
+	return f.record(fmt.Sprintf("retract %s %s %v", to, id, groupchat))
+}
+
+func (f *fakeSession) Moderate(room, id, reason string) error {
+	return f.record(fmt.Sprintf("moderate %s %s %s", room, id, reason))
+}
+
+func (f *fakeSession) React(to, id string, groupchat bool, reactions []string) error {
+	return f.record(fmt.Sprintf("react %s %s %v %v", to, id, groupchat, reactions))
+}
+
+func (f *fakeSession) numCalls() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.calls)
+}
+
+func (f *fakeSession) numSent() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.sent)
+}
+
+func (f *fakeSession) Close() error {
+	return nil
+}
+
+// fakeXMPP records the accounts it is started with.
+type fakeXMPP struct {
+	accounts  []config.Account
+	session   *fakeSession
+	recv      chan<- xmpp.Event
+	bookmarks []config.Room // bookmarks of new sessions
+}
+
+func (f *fakeXMPP) start(
+	account *config.Account,
+	send <-chan xmpp.Message,
+	recv chan<- xmpp.Event,
+	debug bool,
+) (session, error) {
+	f.accounts = append(f.accounts, *account)
+	sess := &fakeSession{bookmarks: f.bookmarks}
+	go func() {
+		for msg := range send {
+			sess.mutex.Lock()
+			sess.sent = append(sess.sent, msg)
+			sess.mutex.Unlock()
+		}
+	}()
+	f.session = sess
+	f.recv = recv
+	return sess, nil
+}
+
+// record returns the lines of the buffer of conversation.
+// s.mutex must be held.
+func record(s *state, conversation string) []chatLine {
+	return s.buffer(conversation).lines
+}
+
+// login returns a new state logged in to a new hill (with XMPP sessions
+// faked, which get the given bookmarks) and the driver of its UI.
+func login(t *testing.T, bookmarks ...config.Room) (*state, *driver, *fakeXMPP) {
+	x := &fakeXMPP{bookmarks: bookmarks}
+	s := newState(newHill(t, false), false)
+	s.xmppStart = x.start
+	d := newDriver(s, s.login(nil))
+	d.text(string(passphrase))
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Login
+	return s, d, x
+}
+
+// waitFor waits until cond (called with s.mutex held) is true.
+func waitFor(t *testing.T, s *state, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		s.mutex.Lock()
+		ok := cond()
+		s.mutex.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+func TestSetup(t *testing.T) {
+	backend := storage.NewMemoryBackend("test.hill")
+	var x fakeXMPP
+	s := newState(backend, false)
+	s.xmppStart = x.start
+	d := newDriver(s, s.setup())
+	// passphrases
+	d.text(string(passphrase))
+	d.key(tcell.KeyTab, 0)
+	d.text(string(passphrase))
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Save
+	if exists, _ := backend.Exists(); !exists {
+		t.Fatal("setup did not create hill")
+	}
+	// invalid account
+	d.text("alice")
+	d.key(tcell.KeyTab, 0)
+	d.text("secret")
+	d.key(tcell.KeyTab, 0)
+	d.text("bob@example.com")
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Save
+	if len(x.accounts) != 0 || len(s.hill.Accounts) != 0 {
+		t.Fatal("account with invalid username added")
+	}
+	// account
+	d.key(tcell.KeyTab, 0) // Quit
+	d.key(tcell.KeyTab, 0) // Username
+	d.text("Alice@Example.com")
+	d.key(tcell.KeyTab, 0)
+	d.text("secret")
+	d.key(tcell.KeyTab, 0)
+	d.text("bob@example.com")
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Save
+	if len(x.accounts) != 1 || x.accounts[0].Username != "alice@example.com" {
+		t.Fatalf("XMPP not started for new account: %v", x.accounts)
+	}
+	_, data, err := storage.Open(backend, passphrase)
+	if err != nil {
+		t.Fatalf("storage.Open() failed: %v", err)
+	}
+	hill, _, err := config.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("config.Unmarshal() failed: %v", err)
+	}
+	account := hill.LastAccount()
+	if account == nil || account.Username != "alice@example.com" ||
+		string(account.Password) != "secret" {
+		t.Errorf("account not saved: %v", account)
+	}
+}
+
+func TestSetupPassphraseMismatch(t *testing.T) {
+	backend := storage.NewMemoryBackend("test.hill")
+	s := newState(backend, false)
+	root := s.setup()
+	d := newDriver(s, root)
+	d.text(string(passphrase))
+	d.key(tcell.KeyTab, 0)
+	d.text("something else")
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Save
+	if exists, _ := backend.Exists(); exists {
+		t.Error("setup created hill despite passphrase mismatch")
+	}
+	if s.root != root {
+		t.Error("setup left passphrase screen despite passphrase mismatch")
+	}
+}
+
+// newHill returns a memory backend with a hill containing a single account.
+func newHill(t *testing.T, keepConnected bool) storage.Backend {
+	backend := storage.NewMemoryBackend("test.hill")
+	hill, err := config.NewHill()
+	if err != nil {
+		t.Fatalf("config.NewHill() failed: %v", err)
+	}
+	hill.Settings.KeepConnected = keepConnected
+	hill.Accounts = append(hill.Accounts, config.Account{
+		Username: "alice@example.com",
+		Password: config.Secret("secret"),
+	})
+	hill.Contacts = append(hill.Contacts, config.Contact{
+		Remote: "bob@example.com",
+		Local:  "alice@example.com",
+	})
+	if _, err := storage.Create(backend, passphrase, hill.Marshal()); err != nil {
+		t.Fatalf("storage.Create() failed: %v", err)
+	}
+	return backend
+}
+
+func TestLogin(t *testing.T) {
+	backend := newHill(t, false)
+	var x fakeXMPP
+	s := newState(backend, false)
+	s.xmppStart = x.start
+	root := s.login(nil)
+	d := newDriver(s, root)
+	// wrong passphrase
+	d.text("wrong")
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Login
+	if s.root != root || len(x.accounts) != 0 {
+		t.Fatal("login succeeded with wrong passphrase")
+	}
+	// correct passphrase
+	d.key(tcell.KeyTab, 0) // Abort
+	d.key(tcell.KeyTab, 0) // Passphrase
+	d.text(string(passphrase))
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Login
+	if len(x.accounts) != 1 || x.accounts[0].Username != "alice@example.com" {
+		t.Fatalf("XMPP not started for account: %v", x.accounts)
+	}
+	if s.root == root {
+		t.Fatal("login did not switch to main view")
+	}
+	// lock
+	d.key(tcell.KeyCtrlL, 0)
+	if s.hill != nil || s.state != nil {
+		t.Error("lock did not wipe hill")
+	}
+	// login again
+	d.text(string(passphrase))
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Login
+	if len(x.accounts) != 2 {
+		t.Errorf("XMPP not restarted after lock: %v", x.accounts)
+	}
+}
+
+func TestAutoLock(t *testing.T) {
+	for _, keepConnected := range []bool{false, true} {
+		backend := newHill(t, keepConnected)
+		var x fakeXMPP
+		s := newState(backend, false)
+		s.xmppStart = x.start
+		d := newDriver(s, s.login(nil))
+		d.text(string(passphrase))
+		d.key(tcell.KeyTab, 0)
+		d.key(tcell.KeyEnter, 0) // Login
+		if s.chatRecord == nil || s.idleTimer == nil {
+			t.Fatal("login did not start main view with idle timer")
+		}
+		// not idle long enough
+		s.checkIdle(time.Minute)
+		if s.hill == nil {
+			t.Fatal("UI locked although not idle")
+		}
+		// idle
+		s.mutex.Lock()
+		s.lastActivity = time.Now().Add(-2 * time.Minute)
+		s.mutex.Unlock()
+		s.checkIdle(time.Minute)
+		if s.hill != nil || s.state != nil || s.chatRecord != nil {
+			t.Fatal("auto-lock did not wipe state")
+		}
+		if keepConnected != (s.send != nil) {
+			t.Errorf("keepConnected=%v: XMPP session kept=%v", keepConnected,
+				s.send != nil)
+		}
+		// login again
+		d.setFocus(s.root)
+		d.text(string(passphrase))
+		d.key(tcell.KeyTab, 0)
+		d.key(tcell.KeyEnter, 0) // Login
+		expected := 2
+		if keepConnected {
+			expected = 1
+		}
+		if len(x.accounts) != expected {
+			t.Errorf("keepConnected=%v: XMPP started %d times", keepConnected,
+				len(x.accounts))
+		}
+		s.stopIdleTimer()
+	}
+}
+
+func TestRooms(t *testing.T) {
+	s, d, x := login(t)
+	x.session.open = map[string]bool{"ops@conference.example.com": true}
+	// join
+	d.text("/join Ops@Conference.Example.com al")
+	d.key(tcell.KeyEnter, 0)
+	if fmt.Sprint(x.session.calls) != "[bookmark ops@conference.example.com  join ops@conference.example.com/al  20]" {
+		t.Fatalf("room not joined: %v", x.session.calls)
+	}
+	if s.contact != "ops@conference.example.com" {
+		t.Errorf("current conversation is '%s'", s.contact)
+	}
+	_, data, err := storage.Open(s.backend, passphrase)
+	if err != nil {
+		t.Fatalf("storage.Open() failed: %v", err)
+	}
+	hill, _, err := config.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("config.Unmarshal() failed: %v", err)
+	}
+	if r := hill.Room("alice@example.com", "ops@conference.example.com"); r == nil || !r.AutoJoin {
+		t.Errorf("joined room not saved: %+v", hill.Rooms)
+	}
+	// occupants and subject
+	for _, o := range []xmpp.Occupant{
+		{Nick: "bob", Role: "moderator", Affiliation: "owner"},
+		{Nick: "al", Role: "participant", Affiliation: "none", Self: true},
+	} {
+		o.Room = "ops@conference.example.com"
+		x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &o}
+	}
+	x.recv <- xmpp.Event{
+		Kind:    xmpp.SubjectEvent,
+		Subject: &xmpp.Subject{Room: "ops@conference.example.com", Text: "on call"},
+	}
+	waitFor(t, s, func() bool {
+		r := s.rooms["ops@conference.example.com"]
+		return r != nil && r.joined && len(r.occupants) == 2 && r.subject == "on call" &&
+			r.info != nil
+	})
+	// groupchat messages are refused until the room qualifies for encryption
+	s.mutex.Lock()
+	r := s.rooms["ops@conference.example.com"]
+	info := r.info
+	r.info = nil
+	if err := s.sendable("ops@conference.example.com"); err == nil {
+		t.Error("sending to room without information accepted")
+	}
+	r.info = info
+	s.mutex.Unlock()
+	for i := 0; i < 2; i++ {
+		d.text("hello")
+		d.key(tcell.KeyEnter, 0)
+		s.mutex.Lock()
+		lines := record(s, s.contact)
+		refusal := lines[len(lines)-1].text
+		s.mutex.Unlock()
+		if !strings.Contains(refusal, "cannot be encrypted") {
+			t.Errorf("sending to open room not refused: %q", refusal)
+		}
+		if text := s.compose.text(); text != "hello" {
+			t.Errorf("input not kept: %q", text)
+		}
+		s.compose.setText("")
+	}
+	if x.session.numSent() != 0 {
+		t.Error("message sent to open room")
+	}
+	x.session.mutex.Lock()
+	x.session.open = nil // reconfigured as members-only
+	x.session.mutex.Unlock()
+	s.fetchRoomInfo("ops@conference.example.com")
+	d.text("hello")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool {
+		x.session.mutex.Lock()
+		defer x.session.mutex.Unlock()
+		return len(x.session.sent) == 1
+	})
+	if msg := x.session.sent[0]; msg.Type != xmpp.Groupchat || msg.To != "ops@conference.example.com" {
+		t.Errorf("sent %+v", msg)
+	}
+	// subject and leave
+	d.text("/topic release today")
+	d.key(tcell.KeyEnter, 0)
+	d.text("/leave")
+	d.key(tcell.KeyEnter, 0)
+	want := "[bookmark ops@conference.example.com  join ops@conference.example.com/al  20 " +
+		"subject ops@conference.example.com release today " +
+		"leave ops@conference.example.com unbookmark ops@conference.example.com]"
+	if fmt.Sprint(x.session.calls) != want {
+		t.Errorf("calls: %v", x.session.calls)
+	}
+	if s.contact != "bob@example.com" {
+		t.Errorf("current conversation after leave is '%s'", s.contact)
+	}
+	if s.hill.Room("alice@example.com", "ops@conference.example.com") != nil {
+		t.Error("left room not removed from hill")
+	}
+	s.stopIdleTimer()
+}
+
+func TestModeration(t *testing.T) {
+	s, d, x := login(t)
+	room := "ops@conference.example.com"
+	// invitation with password
+	x.recv <- xmpp.Event{
+		Kind:   xmpp.InviteEvent,
+		Invite: &xmpp.Invite{Room: room, From: "bob@example.com", Password: "pw"},
+	}
+	waitFor(t, s, func() bool { return s.invites[room] == "pw" })
+	d.text("/join " + room)
+	d.key(tcell.KeyEnter, 0)
+	for _, o := range []xmpp.Occupant{
+		{Nick: "bob", Role: "participant", Affiliation: "none", JID: "bob@example.com/phone"},
+		{Nick: "alice", Role: "moderator", Affiliation: "owner", Self: true},
+	} {
+		o.Room = room
+		x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &o}
+	}
+	waitFor(t, s, func() bool {
+		r := s.rooms[room]
+		return r != nil && r.joined && len(r.occupants) == 2
+	})
+	// commands
+	for _, line := range []string{
+		"/kick bob too loud",
+		"/ban bob",
+		"/member carol@example.com",
+		"/dinvite carol@example.com come in",
+	} {
+		d.text(line)
+		d.key(tcell.KeyEnter, 0)
+		n := x.session.numCalls()
+		waitFor(t, s, func() bool { return x.session.numCalls() == n+1 })
+	}
+	// live update
+	o := xmpp.Occupant{Room: room, Nick: "bob", Role: "none", Affiliation: "none", Left: true, Kicked: true}
+	x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &o}
+	waitFor(t, s, func() bool {
+		return len(s.rooms[room].occupants) == 1
+	})
+	// configuration
+	d.text("/config")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return s.root != s.mainView })
+	d.setFocus(d.root())
+	if label := d.focus.(*tview.InputField).GetLabel(); label != "[red[]Persistent (yes/no)" {
+		t.Errorf("label not sanitized: %q", label)
+	}
+	d.text("yes") // persistent
+	d.key(tcell.KeyTab, 0)
+	d.text("everyone") // invalid option
+	d.key(tcell.KeyTab, 0)
+	d.key(tcell.KeyEnter, 0) // Save
+	if d.root() == s.mainView {
+		t.Fatal("invalid configuration accepted")
+	}
+	d.key(tcell.KeyTab, 0) // Cancel
+	d.key(tcell.KeyTab, 0) // persistent
+	d.key(tcell.KeyTab, 0) // whois
+	d.text("anyone")
+	d.key(tcell.KeyTab, 0)
+	n := x.session.numCalls()
+	d.key(tcell.KeyEnter, 0) // Save
+	waitFor(t, s, func() bool { return x.session.numCalls() == n+1 })
+	want := []string{
+		"bookmark ops@conference.example.com ",
+		"join ops@conference.example.com/alice pw 20",
+		"role ops@conference.example.com bob none too loud",
+		"affiliation ops@conference.example.com bob@example.com outcast ",
+		"affiliation ops@conference.example.com carol@example.com member ",
+		"direct-invite ops@conference.example.com carol@example.com come in pw",
+		"config ops@conference.example.com",
+		"configure ops@conference.example.com FORM_TYPE=muc#roomconfig persistent=1 whois=anyone",
+	}
+	x.session.mutex.Lock()
+	calls := append([]string(nil), x.session.calls...)
+	x.session.mutex.Unlock()
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+	s.stopIdleTimer()
+}
+
+func TestBookmarks(t *testing.T) {
+	s, d, x := login(t, config.Room{
+		Room:     "ops@conference.example.com",
+		Local:    "alice@example.com",
+		History:  config.DefaultRoomHistory,
+		AutoJoin: true,
+	})
+	if fmt.Sprint(x.session.calls) != "[join ops@conference.example.com/alice  20]" {
+		t.Fatalf("bookmarked room not joined: %v", x.session.calls)
+	}
+	if r := s.hill.Room("alice@example.com", "ops@conference.example.com"); r == nil || !r.Bookmarked {
+		t.Fatalf("bookmarked room not added: %+v", r)
+	}
+	x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &xmpp.Occupant{
+		Room: "ops@conference.example.com", Nick: "alice", Self: true,
+	}}
+	waitFor(t, s, func() bool { return s.rooms["ops@conference.example.com"].joined })
+	s.switchTo("ops@conference.example.com")
+	// rename
+	d.text("/bookmark Operations")
+	d.key(tcell.KeyEnter, 0)
+	if r := s.hill.Room("alice@example.com", "ops@conference.example.com"); r.Name != "Operations" {
+		t.Errorf("bookmark not renamed: %+v", r)
+	}
+	// remove
+	d.text("/unbookmark")
+	d.key(tcell.KeyEnter, 0)
+	if s.hill.Room("alice@example.com", "ops@conference.example.com") != nil {
+		t.Error("bookmark not removed")
+	}
+	want := "[join ops@conference.example.com/alice  20 " +
+		"bookmark ops@conference.example.com Operations " +
+		"unbookmark ops@conference.example.com]"
+	if fmt.Sprint(x.session.calls) != want {
+		t.Errorf("calls: %v", x.session.calls)
+	}
+	_, data, err := storage.Open(s.backend, passphrase)
+	if err != nil {
+		t.Fatalf("storage.Open() failed: %v", err)
+	}
+	hill, _, err := config.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("config.Unmarshal() failed: %v", err)
+	}
+	if len(hill.Rooms) != 0 {
+		t.Errorf("removed bookmark saved: %+v", hill.Rooms)
+	}
+	s.stopIdleTimer()
+}
+
+func TestReceipts(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	sent := func() []xmpp.Message {
+		x.session.mutex.Lock()
+		defer x.session.mutex.Unlock()
+		return append([]xmpp.Message(nil), x.session.sent...)
+	}
+	// sent message
+	d.text("hi")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return len(record(s, "bob@example.com")) == 1 })
+	msgs := sent()
+	if len(msgs) != 1 || msgs[0].ID == "" || msgs[0].To != "bob@example.com" {
+		t.Fatalf("sent %+v", msgs)
+	}
+	id := msgs[0].ID
+	if l := record(s, "bob@example.com")[0]; l.String() != "[blue]hi[-] [gray](sent)[-]" {
+		t.Errorf("line: %s", l)
+	}
+	// delivery state only advances
+	for _, state := range []string{xmpp.Delivered, xmpp.Acked} {
+		x.recv <- xmpp.Event{Kind: xmpp.ReceiptEvent, Receipt: &xmpp.Receipt{
+			ID: id, From: "bob@example.com", State: state,
+		}}
+	}
+	waitFor(t, s, func() bool { return record(s, "bob@example.com")[0].state == xmpp.Delivered })
+	// received messages in current and other conversation
+	for _, from := range []string{"bob@example.com", "carol@example.com"} {
+		x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &xmpp.Message{
+			From: from, Text: "hello", ID: "m-" + from, Receipt: true, Markable: true,
+		}}
+	}
+	waitFor(t, s, func() bool {
+		return len(record(s, "bob@example.com")) == 2 && len(record(s, "carol@example.com")) == 1
+	})
+	want := []xmpp.Message{
+		{To: "bob@example.com", ID: "m-bob@example.com", Marker: xmpp.Delivered},
+		{To: "bob@example.com", ID: "m-bob@example.com", Marker: xmpp.Displayed},
+		{To: "carol@example.com", ID: "m-carol@example.com", Marker: xmpp.Delivered},
+	}
+	waitFor(t, s, func() bool { return len(sent()) == 4 })
+	if msgs := sent()[1:]; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("markers: %+v", msgs)
+	}
+	// displayed when switching to conversation
+	s.switchTo("carol@example.com")
+	waitFor(t, s, func() bool { return len(sent()) == 5 })
+	if msg := sent()[4]; msg.Marker != xmpp.Displayed || msg.ID != "m-carol@example.com" {
+		t.Errorf("sent %+v", msg)
+	}
+	s.stopIdleTimer()
+}
+
+func TestChatStates(t *testing.T) {
+	s, d, x := login(t)
+	states := func() []string {
+		x.session.mutex.Lock()
+		defer x.session.mutex.Unlock()
+		var states []string
+		for _, msg := range x.session.sent {
+			states = append(states, msg.Text+":"+msg.ChatState)
+		}
+		return states
+	}
+	// typing and sending
+	d.text("hi")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return len(states()) == 2 })
+	if fmt.Sprint(states()) != "[:composing hi:active]" {
+		t.Errorf("sent chat states: %v", states())
+	}
+	// received chat states
+	x.recv <- xmpp.Event{Kind: xmpp.ChatStateEvent, ChatState: &xmpp.ChatState{
+		From: "bob@example.com", State: xmpp.Composing,
+	}}
+	waitFor(t, s, func() bool { return s.typingInfo() == "bob@example.com is typing…" })
+	x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &xmpp.Message{
+		From: "bob@example.com", Text: "hello", ChatState: xmpp.Active,
+	}}
+	waitFor(t, s, func() bool { return s.typingInfo() == "" })
+	// disable for contact
+	d.text("/chatstates off")
+	d.key(tcell.KeyEnter, 0)
+	d.text("x")
+	d.key(tcell.KeyEnter, 0)
+	if c := s.hill.Contact("alice@example.com", "bob@example.com"); !c.NoChatStates {
+		t.Error("chat states not disabled")
+	}
+	waitFor(t, s, func() bool { return len(states()) == 3 })
+	if fmt.Sprint(states()) != "[:composing hi:active x:]" {
+		t.Errorf("sent chat states: %v", states())
+	}
+	s.mutex.Lock()
+	s.stopPauseTimer()
+	s.mutex.Unlock()
+	s.stopIdleTimer()
+}
+
+func TestCorrections(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	sent := func() []xmpp.Message {
+		x.session.mutex.Lock()
+		defer x.session.mutex.Unlock()
+		return append([]xmpp.Message(nil), x.session.sent...)
+	}
+	d.text("helo")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return len(sent()) == 1 })
+	id := sent()[0].ID
+	// edit last message
+	d.key(tcell.KeyUp, 0)
+	d.key(tcell.KeyBackspace2, 0)
+	for _, r := range "lo" {
+		d.key(tcell.KeyRune, r)
+	}
+	d.key(tcell.KeyEnter, 0)
+	d.text("/correct hello!")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return len(sent()) == 3 })
+	for _, msg := range sent()[1:] {
+		if msg.Replace != id {
+			t.Errorf("correction %+v does not replace %s", msg, id)
+		}
+	}
+	lines := record(s, "bob@example.com")
+	if len(lines) != 1 || lines[0].text != "hello!" || fmt.Sprint(lines[0].edits) != "[helo hello]" {
+		t.Fatalf("sent message not corrected: %+v", lines)
+	}
+	// received corrections, only by the sender
+	for _, msg := range []xmpp.Message{
+		{From: "bob@example.com", ID: "b1", Text: "teh"},
+		{From: "bob@example.com", ID: "b2", Text: "the", Replace: "b1"},
+		{From: "carol@example.com", ID: "c1", Text: "spoofed", Replace: "b2"},
+	} {
+		msg := msg
+		x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &msg}
+	}
+	waitFor(t, s, func() bool { return len(record(s, "carol@example.com")) == 1 })
+	lines = record(s, "bob@example.com")
+	if len(lines) != 2 || lines[1].String() != "the [gray](edited)[-]" ||
+		record(s, "carol@example.com")[0].text != "spoofed" {
+		t.Errorf("received corrections: %+v", lines)
+	}
+	d.text("/edits")
+	d.key(tcell.KeyEnter, 0)
+	lines = record(s, "bob@example.com")
+	if len(lines) != 4 || lines[2].text != "hello! (was: helo | hello)" {
+		t.Errorf("edits: %+v", lines[2:])
+	}
+	s.stopIdleTimer()
+}
+
+func TestRetraction(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	d.text("my password")
+	d.key(tcell.KeyEnter, 0)
+	d.text("/correct my password!")
+	d.key(tcell.KeyEnter, 0)
+	d.text("/retract")
+	d.key(tcell.KeyEnter, 0)
+	id := record(s, "bob@example.com")[0].id
+	if fmt.Sprint(x.session.calls) != "[retract bob@example.com "+id+" false]" {
+		t.Errorf("calls: %v", x.session.calls)
+	}
+	if l := record(s, "bob@example.com")[0]; l.text != "" || l.edits != nil || l.String() != "[gray](message retracted)[-]" {
+		t.Errorf("sent message not retracted: %+v", l)
+	}
+	if history := s.buffer("bob@example.com").history; fmt.Sprint(history) != "[/retract]" {
+		t.Errorf("retracted message not removed from input history: %q", history)
+	}
+	// retractions by contacts and moderators
+	room := "ops@conference.example.com"
+	d.text("/join " + room)
+	d.key(tcell.KeyEnter, 0)
+	for _, o := range []xmpp.Occupant{
+		{Nick: "mallory", Role: "participant"},
+		{Nick: "alice", Role: "moderator", Self: true},
+	} {
+		o.Room = room
+		x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &o}
+	}
+	for _, msg := range []xmpp.Message{
+		{From: "bob@example.com", ID: "b1", Text: "oops"},
+		{From: "bob@example.com", ID: "b2", Text: "ignore that", ReplyID: "b1", Quote: "oops"},
+		{Type: xmpp.Groupchat, From: room, Nick: "mallory", ID: "m1", StanzaID: "s1", Text: "spam"},
+		{Type: xmpp.Groupchat, From: room, Nick: "mallory", ID: "m2", StanzaID: "s2", Text: "more spam"},
+	} {
+		msg := msg
+		x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &msg}
+	}
+	x.recv <- xmpp.Event{Kind: xmpp.RetractEvent, Retraction: &xmpp.Retraction{
+		From: "bob@example.com", ID: "b1",
+	}}
+	x.recv <- xmpp.Event{Kind: xmpp.RetractEvent, Retraction: &xmpp.Retraction{
+		From: room, ID: "s1", Moderated: true, Reason: "spam",
+	}}
+	waitFor(t, s, func() bool {
+		lines := record(s, room)
+		n := len(lines)
+		return n > 1 && lines[n-2].retracted != "" && len(record(s, "bob@example.com")) == 3 && record(s, "bob@example.com")[1].retracted != ""
+	})
+	lines := record(s, room)
+	n := len(lines)
+	if reply := record(s, "bob@example.com")[2]; reply.quote != "" {
+		t.Errorf("quote of retracted message kept: %+v", reply)
+	}
+	if l := record(s, "bob@example.com")[1]; l.text != "" || lines[n-2].String() != "mallory: [gray](message retracted by moderator: spam)[-]" {
+		t.Errorf("retracted lines: %+v %+v", l, lines[n-2:])
+	}
+	d.text("/moderate mallory flooding")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return x.session.numCalls() == 4 })
+	if call := x.session.calls[3]; call != "moderate "+room+" s2 flooding" {
+		t.Errorf("call: %s", call)
+	}
+	s.stopIdleTimer()
+}
+
+func TestReactionsAndReplies(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &xmpp.Message{
+		From: "bob@example.com", ID: "b1", Text: "cake?",
+	}}
+	waitFor(t, s, func() bool { return len(record(s, "bob@example.com")) == 1 })
+	// reply to selected message
+	d.key(tcell.KeyCtrlR, 0)
+	if info := s.replyInfo(); s.current().selected != 0 || info != "replying to: cake?" {
+		t.Fatalf("message not selected: %d %q", s.current().selected, info)
+	}
+	d.text("yes")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return len(record(s, "bob@example.com")) == 2 })
+	var sent []xmpp.Message
+	waitFor(t, s, func() bool {
+		x.session.mutex.Lock()
+		defer x.session.mutex.Unlock()
+		sent = append([]xmpp.Message(nil), x.session.sent...)
+		return len(sent) > 0
+	})
+	if len(sent) != 1 || sent[0].ReplyID != "b1" || sent[0].ReplyTo != "bob@example.com" ||
+		sent[0].Quote != "cake?" {
+		t.Errorf("sent: %+v", sent)
+	}
+	if l := record(s, "bob@example.com")[1]; s.current().selected != -1 ||
+		l.String() != "[gray]> cake?[-]\n[blue]yes[-] [gray](sent)[-]" {
+		t.Errorf("reply: %q (selected %d)", l.String(), s.current().selected)
+	}
+	// reactions
+	d.text("/react 👍")
+	d.key(tcell.KeyEnter, 0)
+	id := record(s, "bob@example.com")[1].id
+	x.recv <- xmpp.Event{Kind: xmpp.ReactionEvent, Reactions: &xmpp.Reactions{
+		From: "bob@example.com", ID: id, Reactions: []string{"👍", "🐢"},
+	}}
+	x.recv <- xmpp.Event{Kind: xmpp.ReactionEvent, Reactions: &xmpp.Reactions{
+		From: "bob@example.com", ID: "b1", Reactions: []string{"🐢"},
+	}}
+	waitFor(t, s, func() bool { return len(record(s, "bob@example.com")[0].reactions) == 1 })
+	if r := reactionsLine(record(s, "bob@example.com")[1].reactions); r != "👍 2  🐢 1" {
+		t.Errorf("reactions: %s", r)
+	}
+	d.text("/react 👍") // removes reaction
+	d.key(tcell.KeyEnter, 0)
+	if r := reactionsLine(record(s, "bob@example.com")[1].reactions); r != "🐢 1  👍 1" {
+		t.Errorf("reactions: %s", r)
+	}
+	// reply in room quotes the text shown instead of the fallback
+	room := "ops@conference.example.com"
+	d.text("/join " + room)
+	d.key(tcell.KeyEnter, 0)
+	for _, msg := range []xmpp.Message{
+		{Type: xmpp.Groupchat, From: room, Nick: "bob", ID: "m1", StanzaID: "s1", Text: "hi"},
+		{Type: xmpp.Groupchat, From: room, Nick: "carol", ID: "m2", StanzaID: "s2",
+			Text: "hello", ReplyID: "s1", Quote: "forged"},
+	} {
+		msg := msg
+		x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &msg}
+	}
+	waitFor(t, s, func() bool {
+		lines := record(s, room)
+		return len(lines) > 1 && lines[len(lines)-1].id == "m2"
+	})
+	if lines := record(s, room); lines[len(lines)-1].quote != "hi" {
+		t.Errorf("quote: %q", lines[len(lines)-1].quote)
+	}
+	d.key(tcell.KeyCtrlR, 0)
+	d.key(tcell.KeyCtrlR, 0)
+	d.text("/react 🐢")
+	d.key(tcell.KeyEnter, 0)
+	calls := x.session.calls
+	if len(calls) < 2 || calls[0] != "react bob@example.com "+id+" false [👍]" ||
+		calls[len(calls)-1] != "react "+room+" s1 true [🐢]" {
+		t.Errorf("calls: %v", calls)
+	}
+	s.stopIdleTimer()
+}
+
+func TestBuffers(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	current := func() string {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.contact
+	}
+	// unread message in other conversation
+	d.text("draft")
+	x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &xmpp.Message{
+		From: "carol@example.com/phone", Text: "hello",
+	}}
+	waitFor(t, s, func() bool { return s.buffer("carol@example.com").unread == 1 })
+	s.mutex.Lock()
+	if b := s.buffer("carol@example.com"); !b.highlight || fmt.Sprint(s.order) != "[bob@example.com carol@example.com]" {
+		t.Errorf("contact list: %v (highlight %v)", s.order, b.highlight)
+	}
+	s.mutex.Unlock()
+	// switching keeps drafts
+	d.key(tcell.KeyCtrlN, 0)
+	if c := current(); c != "carol@example.com" || s.compose.input.GetText() != "" {
+		t.Fatalf("switched to %s with input %q", c, s.compose.input.GetText())
+	}
+	s.mutex.Lock()
+	if b := s.buffer("carol@example.com"); b.unread != 0 || b.highlight {
+		t.Errorf("unread: %d (highlight %v)", b.unread, b.highlight)
+	}
+	s.mutex.Unlock()
+	d.key(tcell.KeyF1, 0)
+	if c := current(); c != "bob@example.com" || s.compose.input.GetText() != "draft" {
+		t.Errorf("switched to %s with input %q", c, s.compose.input.GetText())
+	}
+	d.keyMod(tcell.KeyRune, '2', tcell.ModAlt)
+	if c := current(); c != "carol@example.com" || s.compose.input.GetText() != "" {
+		t.Errorf("switched to %s with input %q", c, s.compose.input.GetText())
+	}
+	d.keyMod(tcell.KeyRune, '1', tcell.ModAlt)
+	if c := current(); c != "bob@example.com" || s.compose.input.GetText() != "draft" {
+		t.Errorf("switched to %s with input %q", c, s.compose.input.GetText())
+	}
+	// contact list
+	d.setFocus(s.contactList)
+	d.key(tcell.KeyDown, 0)
+	d.key(tcell.KeyEnter, 0)
+	if c := current(); c != "carol@example.com" {
+		t.Errorf("selected %s", c)
+	}
+	d.setFocus(s.compose.input)
+	d.key(tcell.KeyCtrlP, 0)
+	if c := current(); c != "bob@example.com" {
+		t.Errorf("switched to %s", c)
+	}
+	s.stopIdleTimer()
+}
+
+func TestParseCommand(t *testing.T) {
+	c, args, err := parseCommand("/msg bob@example.com  hello  world ")
+	if err != nil || c.name != "/msg" || len(args) != 2 || args[1] != "hello  world" {
+		t.Errorf("parseCommand(): %v %q", err, args)
+	}
+	c, args, err = parseCommand("/join ops@conference.example.com al secret")
+	if err != nil || c.name != "/join" || fmt.Sprint(args) != "[ops@conference.example.com al secret]" {
+		t.Errorf("parseCommand(): %v %q", err, args)
+	}
+	if entry := historyEntry("/join ops@conference.example.com al secret", c, args); entry != "/join ops@conference.example.com al" {
+		t.Errorf("password kept in input history: %q", entry)
+	}
+	if entry := historyEntry("/join ops@conference.example.com", c, args[:1]); entry != "/join ops@conference.example.com" {
+		t.Errorf("input history entry: %q", entry)
+	}
+	if _, _, err := parseCommand("/join"); err == nil || err.Error() != "usage: /join <room> [<nick> [<password>]]" {
+		t.Errorf("missing argument: %v", err)
+	}
+	if _, _, err := parseCommand("/retract now"); err == nil || err.Error() != "usage: /retract" {
+		t.Errorf("extra argument: %v", err)
+	}
+	if _, _, err := parseCommand("/nope"); err == nil {
+		t.Error("unknown command accepted")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	sent := func() []xmpp.Message {
+		x.session.mutex.Lock()
+		defer x.session.mutex.Unlock()
+		return append([]xmpp.Message(nil), x.session.sent...)
+	}
+	// invalid commands are kept in the input field
+	d.text("/join")
+	d.key(tcell.KeyEnter, 0)
+	s.mutex.Lock()
+	lines := record(s, "bob@example.com")
+	if s.compose.input.GetText() != "/join" || len(lines) != 1 ||
+		lines[0].text != "[red]usage: /join <room> [<nick> [<password>]][-]" {
+		t.Errorf("invalid command: %q %+v", s.compose.input.GetText(), lines)
+	}
+	s.mutex.Unlock()
+	// completion
+	d.text("/he")
+	d.key(tcell.KeyTab, 0)
+	if text := s.compose.input.GetText(); text != "/help " {
+		t.Errorf("completed %q", text)
+	}
+	if text, candidates := s.complete("/re"); text != "/re" || len(candidates) != 4 {
+		t.Errorf("completed %q %v", text, candidates)
+	}
+	if text, _ := s.complete("/remove b"); text != "/remove bob@example.com " {
+		t.Errorf("completed %q", text)
+	}
+	// messages and actions
+	d.text("/msg carol@example.com hi  there")
+	d.key(tcell.KeyEnter, 0)
+	d.text("/me waves")
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return len(record(s, "carol@example.com")) == 2 && len(sent()) == 2 })
+	if msgs := sent(); len(msgs) != 2 || msgs[0].To != "carol@example.com" ||
+		msgs[0].Text != "hi  there" || msgs[1].Text != "/me waves" {
+		t.Errorf("sent %+v", msgs)
+	}
+	s.mutex.Lock()
+	if l := record(s, "carol@example.com")[1]; s.contact != "carol@example.com" ||
+		l.String() != "* alice [blue]waves[-] [gray](sent)[-]" {
+		t.Errorf("action: %s", l)
+	}
+	s.mutex.Unlock()
+	d.text("/clear")
+	d.key(tcell.KeyEnter, 0)
+	if n := len(record(s, "carol@example.com")); n != 0 {
+		t.Errorf("%d lines after /clear", n)
+	}
+	// contacts and status
+	d.text("/add carol@example.com")
+	d.key(tcell.KeyEnter, 0)
+	if s.hill.Contact("alice@example.com", "carol@example.com") == nil {
+		t.Error("contact not added")
+	}
+	d.text("/remove carol@example.com")
+	d.key(tcell.KeyEnter, 0)
+	if s.hill.Contact("alice@example.com", "carol@example.com") != nil {
+		t.Error("contact not removed")
+	}
+	d.text("/status away gone fishing")
+	d.key(tcell.KeyEnter, 0)
+	// nicknames
+	room := "ops@conference.example.com"
+	d.text("/join " + room + " al")
+	d.key(tcell.KeyEnter, 0)
+	for _, o := range []xmpp.Occupant{
+		{Nick: "bob", Role: "participant"},
+		{Nick: "al", Role: "participant", Self: true},
+	} {
+		o := o
+		o.Room = room
+		x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &o}
+	}
+	waitFor(t, s, func() bool { return s.rooms[room].joined })
+	d.text("b")
+	d.key(tcell.KeyTab, 0)
+	if text := s.compose.input.GetText(); text != "bob: " {
+		t.Errorf("completed %q", text)
+	}
+	d.text("/nick al2")
+	d.key(tcell.KeyEnter, 0)
+	x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &xmpp.Occupant{
+		Room: room, Nick: "al", Role: "participant", Self: true, Left: true, NewNick: "al2",
+	}}
+	waitFor(t, s, func() bool {
+		o := s.rooms[room].self()
+		return o != nil && o.Nick == "al2"
+	})
+	calls := x.session.calls
+	want := "[presence away gone fishing bookmark " + room + "  join " + room + "/al  20 nick " + room + " al2]"
+	if fmt.Sprint(calls) != want {
+		t.Errorf("calls: %v", calls)
+	}
+	if r := s.hill.Room("alice@example.com", room); r == nil || r.Nick != "al2" {
+		t.Errorf("nickname not saved: %+v", r)
+	}
+	s.stopIdleTimer()
+}
+
+func TestCompose(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	sent := func() []xmpp.Message {
+		x.session.mutex.Lock()
+		defer x.session.mutex.Unlock()
+		return append([]xmpp.Message(nil), x.session.sent...)
+	}
+	// multi-line message
+	d.text("hello")
+	d.keyMod(tcell.KeyEnter, 0, tcell.ModAlt)
+	d.text("world")
+	d.key(tcell.KeyCtrlJ, 0)
+	d.key(tcell.KeyBackspace2, 0) // back to previous line
+	if text := s.compose.text(); text != "hello\nworld" || s.compose.height() != 2 {
+		t.Errorf("composed %q (height %d)", text, s.compose.height())
+	}
+	d.key(tcell.KeyEnter, 0)
+	waitFor(t, s, func() bool { return len(sent()) == 1 })
+	if msg := sent()[0]; msg.Text != "hello\nworld" || s.compose.height() != 1 {
+		t.Errorf("sent %q", msg.Text)
+	}
+	// history
+	d.text("/chatstates")
+	d.key(tcell.KeyEnter, 0)
+	d.text("unsent")
+	for _, step := range []struct {
+		key  tcell.Key
+		want string
+	}{
+		{tcell.KeyUp, "/chatstates"},
+		{tcell.KeyUp, "hello\nworld"},
+		{tcell.KeyUp, "hello\nworld"},
+		{tcell.KeyDown, "/chatstates"},
+		{tcell.KeyDown, "unsent"},
+		{tcell.KeyDown, "unsent"},
+	} {
+		d.key(step.key, 0)
+		if text := s.compose.text(); text != step.want {
+			t.Errorf("recalled %q instead of %q", text, step.want)
+		}
+	}
+	// history and draft per conversation
+	s.switchTo("carol@example.com")
+	d.key(tcell.KeyUp, 0)
+	if text := s.compose.text(); text != "" {
+		t.Errorf("recalled %q in other conversation", text)
+	}
+	// external editor
+	s.editor = func(text string) (string, error) {
+		return text + "edited\ntwice", nil
+	}
+	d.key(tcell.KeyCtrlX, 0)
+	if text := s.compose.text(); text != "edited\ntwice" || s.compose.height() != 2 {
+		t.Errorf("edited %q", text)
+	}
+	s.switchTo("bob@example.com")
+	if text := s.compose.text(); text != "unsent" {
+		t.Errorf("draft %q", text)
+	}
+	s.switchTo("carol@example.com")
+	if text := s.compose.text(); text != "edited\ntwice" {
+		t.Errorf("draft %q", text)
+	}
+	s.stopIdleTimer()
+}
+
+func TestRunEditor(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ui_test")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	defer os.Setenv("EDITOR", os.Getenv("EDITOR"))
+	os.Setenv("TMPDIR", tmp)
+	os.Setenv("EDITOR", "sed -i s/hello/bye/")
+	text, err := runEditor("hello world\n\nagain\n")
+	if err != nil {
+		t.Fatalf("runEditor() failed: %v", err)
+	}
+	if text != "bye world\n\nagain" {
+		t.Errorf("edited %q", text)
+	}
+	if files, err := ioutil.ReadDir(tmp); err != nil || len(files) != 0 {
+		t.Errorf("temporary files left: %v %v", files, err)
+	}
+	os.Setenv("EDITOR", "false")
+	if _, err := runEditor("hello"); err == nil {
+		t.Error("failing editor accepted")
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	for _, test := range []struct {
+		text, clean, line string
+	}{
+		{"hi", "hi", "hi"},
+		{"[red]me[-]", "[red[]me[-[]", "[red[]me[-[]"},
+		{`["region"]x`, `["region"[]x`, `["region"[]x`},
+		{"a\nb\tc", "a\nb\tc", "a b\tc"},
+		{"\x1b[2Jbell\a\r", "[2Jbell", "[2Jbell"},
+		{"\u202eevil\u2066\u200f", "evil", "evil"},
+		{"ä€\U0001f600", "ä€\U0001f600", "ä€\U0001f600"},
+	} {
+		if clean := sanitize(test.text); clean != test.clean {
+			t.Errorf("sanitize(%q) = %q, want %q", test.text, clean, test.clean)
+		}
+		if line := sanitizeLine(test.text); line != test.line {
+			t.Errorf("sanitizeLine(%q) = %q, want %q", test.text, line, test.line)
+		}
+	}
+}
+
+func TestUntrustedText(t *testing.T) {
+	s, d, x := login(t)
+	s.hill.Contact("alice@example.com", "bob@example.com").NoChatStates = true
+	// received text cannot use color tags or fake lines
+	stamp := time.Date(2020, 1, 2, 15, 4, 0, 0, time.Local)
+	x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &xmpp.Message{
+		From: "bob@example.com/phone", Text: "[blue]hi[-] [gray](sent)[-]\n12:00 hello",
+		Time: stamp, Delayed: true,
+	}}
+	waitFor(t, s, func() bool { return len(record(s, "bob@example.com")) == 1 })
+	l := record(s, "bob@example.com")[0]
+	if l.String() != "[blue[]hi[-[] [gray[](sent)[-[]\n12:00 hello" {
+		t.Errorf("line: %q", l.String())
+	}
+	if r := l.render(); r != "[gray]2020-01-02 15:04[-] [blue[]hi[-[] [gray[](sent)[-[]\n"+
+		strings.Repeat(" ", 17)+"12:00 hello" {
+		t.Errorf("rendered: %q", r)
+	}
+	// nicknames and subjects of rooms
+	d.text("/join ops@conference.example.com al")
+	d.key(tcell.KeyEnter, 0)
+	for _, o := range []xmpp.Occupant{
+		{Nick: "[red]bob\u202e", Role: "participant", Affiliation: "none"},
+		{Nick: "al", Role: "participant", Affiliation: "none", Self: true},
+	} {
+		o.Room = "ops@conference.example.com"
+		x.recv <- xmpp.Event{Kind: xmpp.OccupantEvent, Occupant: &o}
+	}
+	x.recv <- xmpp.Event{Kind: xmpp.SubjectEvent, Subject: &xmpp.Subject{
+		Room: "ops@conference.example.com", Nick: "[red]bob\u202e", Text: "[::b]on\ncall",
+	}}
+	x.recv <- xmpp.Event{Kind: xmpp.MessageEvent, Message: &xmpp.Message{
+		From: "ops@conference.example.com", Nick: "[red]bob\u202e", Type: xmpp.Groupchat,
+		Text: "/me [green]waves",
+	}}
+	waitFor(t, s, func() bool { return len(record(s, "ops@conference.example.com")) == 3 })
+	s.mutex.Lock()
+	lines := record(s, "ops@conference.example.com")
+	if l := lines[1].String(); l != "ops@conference.example.com: [red[]bob set the subject to '[::b[]on call'" {
+		t.Errorf("subject: %q", l)
+	}
+	if l := lines[2].String(); l != "* [red[]bob [green[]waves" {
+		t.Errorf("action: %q", l)
+	}
+	s.mutex.Unlock()
+	s.stopIdleTimer()
+}
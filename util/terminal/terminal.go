@@ -0,0 +1,36 @@
+// Package terminal implements passphrase prompts on terminals.
+package terminal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ReadPassphrase shows prompt on stderr and reads a passphrase from the
+// terminal fd without echoing it. If confirm is true the passphrase has to
+// be entered twice and both inputs must match.
+func ReadPassphrase(fd int, prompt string, confirm bool) ([]byte, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	pass, err := readNoEcho(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("terminal: passphrase is empty")
+	}
+	if confirm {
+		fmt.Fprintf(os.Stderr, "confirm %s: ", prompt)
+		pass2, err := readNoEcho(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, pass2) {
+			return nil, errors.New("terminal: passphrases do not match")
+		}
+	}
+	return pass, nil
+}
@@ -0,0 +1,10 @@
+package terminal
+
+import (
+	"syscall"
+)
+
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package terminal
+
+import (
+	"errors"
+)
+
+// readNoEcho is not supported on this platform.
+func readNoEcho(fd int) ([]byte, error) {
+	return nil, errors.New("terminal: reading passphrases not supported on this platform")
+}
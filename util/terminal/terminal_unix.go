@@ -0,0 +1,56 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package terminal
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd int, req uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req,
+		uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// readNoEcho reads a line from terminal fd with echo switched off.
+func readNoEcho(fd int) ([]byte, error) {
+	var old syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+	noEcho := old
+	noEcho.Lflag &^= syscall.ECHO
+	noEcho.Lflag |= syscall.ICANON | syscall.ISIG
+	noEcho.Iflag |= syscall.ICRNL
+	if err := ioctl(fd, ioctlSetTermios, &noEcho); err != nil {
+		return nil, err
+	}
+	defer ioctl(fd, ioctlSetTermios, &old)
+	return readLine(fd)
+}
+
+// readLine reads a single line from fd (without trailing newline).
+func readLine(fd int) ([]byte, error) {
+	var (
+		line []byte
+		buf  [1]byte
+	)
+	for {
+		n, err := syscall.Read(fd, buf[:])
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 || buf[0] == '\n' {
+			break
+		}
+		if buf[0] != '\r' {
+			line = append(line, buf[0])
+		}
+	}
+	return line, nil
+}
@@ -4,6 +4,9 @@ package util
 import (
 	"fmt"
 	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Fatal prints err to stderr and exits the process with exit code 1.
@@ -11,3 +14,39 @@ func Fatal(err error) {
 	fmt.Fprintf(os.Stderr, "%s: error: %s\n", os.Args[0], err)
 	os.Exit(1)
 }
+
+// isWordRune reports whether r can be part of a word.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// Mentions reports whether text contains name as a whole word (ignoring
+// case). For example, "hi bob!" mentions "Bob", but "hi bobby" does not.
+func Mentions(text, name string) bool {
+	if name == "" {
+		return false
+	}
+	text, name = strings.ToLower(text), strings.ToLower(name)
+	for i := 0; i < len(text); {
+		j := strings.Index(text[i:], name)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(name)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		i = start + 1
+	}
+	return false
+}
+
+// Wipe overwrites b with zeros. Use it to remove secrets from memory after
+// use.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
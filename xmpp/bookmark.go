@@ -0,0 +1,292 @@
+package xmpp
+
+import (
+	"encoding/xml"
+
+	"github.com/frankbraun/mole/config"
+	"github.com/frankbraun/mole/jid"
+)
+
+// Bookmark namespaces: PEP Native Bookmarks (XEP-0402) and the older
+// Bookmark Storage (XEP-0048) in Private XML Storage (XEP-0049).
+const (
+	nsPubSub        = "http://jabber.org/protocol/pubsub"
+	nsBookmarks     = "urn:xmpp:bookmarks:1"
+	nsPrivate       = "jabber:iq:private"
+	nsStorage       = "storage:bookmarks"
+	nsPublishOption = "http://jabber.org/protocol/pubsub#publish-options"
+)
+
+// Bookmark storage of a session.
+const (
+	pepStorage     = "pep"
+	privateStorage = "private"
+)
+
+// conference is a bookmarked room in both storages.
+type conference struct {
+	XMLName  xml.Name
+	JID      string `xml:"jid,attr,omitempty"` // private storage only
+	Name     string `xml:"name,attr,omitempty"`
+	AutoJoin string `xml:"autojoin,attr,omitempty"`
+	Nick     string `xml:"nick,omitempty"`
+	Password string `xml:"password,omitempty"`
+}
+
+// pepItems is the response to a request of the bookmarks PEP node.
+type pepItems struct {
+	Items []struct {
+		ID         string     `xml:"id,attr"`
+		Conference conference `xml:"urn:xmpp:bookmarks:1 conference"`
+	} `xml:"pubsub>items>item"`
+}
+
+// storage is the bookmark storage in private XML storage. Other bookmarks
+// (e.g., URLs) are kept unchanged.
+type storage struct {
+	XMLName     xml.Name       `xml:"storage:bookmarks storage"`
+	Conferences []conference   `xml:"conference"`
+	Other       []otherElement `xml:",any"`
+}
+
+// otherElement is an unknown element which is kept unchanged.
+type otherElement struct {
+	XMLName  xml.Name
+	Attr     []xml.Attr `xml:",any,attr"`
+	InnerXML string     `xml:",innerxml"`
+}
+
+// privateQuery is the response of a private XML storage request.
+type privateQuery struct {
+	Query struct {
+		Storage storage `xml:"storage:bookmarks storage"`
+	} `xml:"jabber:iq:private query"`
+}
+
+// room returns c as a room of account local.
+func (c *conference) room(local, room string) (*config.Room, error) {
+	r, err := jid.Parse(room)
+	if err != nil {
+		return nil, err
+	}
+	return &config.Room{
+		Room:     r.Bare().String(),
+		Local:    local,
+		Name:     c.Name,
+		Nick:     c.Nick,
+		Password: config.Secret(c.Password),
+		History:  config.DefaultRoomHistory,
+		AutoJoin: c.AutoJoin == "true" || c.AutoJoin == "1",
+	}, nil
+}
+
+// newConference returns the bookmark of r in the given namespace.
+func newConference(space string, r *config.Room) conference {
+	c := conference{
+		XMLName:  xml.Name{Space: space, Local: "conference"},
+		Name:     r.Name,
+		AutoJoin: "false",
+		Nick:     r.Nick,
+		Password: string(r.Password),
+	}
+	if r.AutoJoin {
+		c.AutoJoin = "true"
+	}
+	if space == nsStorage {
+		c.JID = r.Room
+	}
+	return c
+}
+
+// Bookmarks returns the bookmarked rooms of the account. The bookmarks are
+// taken from PEP, unless it is not supported or only the private XML storage
+// contains bookmarks.
+func (s *Session) Bookmarks() ([]config.Room, error) {
+	rooms, err := s.pepBookmarks()
+	if err != nil {
+		serr, ok := err.(*StanzaError)
+		if !ok {
+			return nil, err
+		}
+		if serr.Condition != "item-not-found" {
+			s.setBookmarkStorage(privateStorage)
+			st, err := s.privateBookmarks()
+			if err != nil {
+				return nil, err
+			}
+			return st.rooms(s.account), nil
+		}
+	}
+	if len(rooms) == 0 {
+		st, err := s.privateBookmarks()
+		if err == nil && len(st.Conferences) > 0 {
+			s.setBookmarkStorage(privateStorage)
+			return st.rooms(s.account), nil
+		}
+	}
+	s.setBookmarkStorage(pepStorage)
+	return rooms, nil
+}
+
+// setBookmarkStorage sets the bookmark storage used by the session.
+func (s *Session) setBookmarkStorage(storage string) {
+	s.mutex.Lock()
+	s.bookmarkStorage = storage
+	s.mutex.Unlock()
+}
+
+// getBookmarkStorage returns the bookmark storage used by the session,
+// which is determined with Bookmarks first (if necessary).
+func (s *Session) getBookmarkStorage() (string, error) {
+	s.mutex.Lock()
+	storage := s.bookmarkStorage
+	s.mutex.Unlock()
+	if storage == "" {
+		if _, err := s.Bookmarks(); err != nil {
+			return "", err
+		}
+		s.mutex.Lock()
+		storage = s.bookmarkStorage
+		s.mutex.Unlock()
+	}
+	return storage, nil
+}
+
+// pepBookmarks returns the bookmarks in PEP.
+func (s *Session) pepBookmarks() ([]config.Room, error) {
+	res, err := s.iq(s.account, "get", "<pubsub xmlns='"+nsPubSub+"'><items node='"+
+		nsBookmarks+"'/></pubsub>")
+	if err != nil {
+		return nil, err
+	}
+	return parsePEPBookmarks(s.account, res)
+}
+
+// parsePEPBookmarks returns the rooms of account local in the PEP response
+// res. Invalid bookmarks are ignored.
+func parsePEPBookmarks(local string, res []byte) ([]config.Room, error) {
+	var p pepItems
+	if err := xml.Unmarshal([]byte("<iq>"+string(res)+"</iq>"), &p); err != nil {
+		return nil, err
+	}
+	var rooms []config.Room
+	for _, item := range p.Items {
+		r, err := item.Conference.room(local, item.ID)
+		if err != nil {
+			continue
+		}
+		rooms = append(rooms, *r)
+	}
+	return rooms, nil
+}
+
+// privateBookmarks returns the bookmark storage in private XML storage.
+func (s *Session) privateBookmarks() (*storage, error) {
+	res, err := s.iq(s.account, "get", "<query xmlns='"+nsPrivate+"'><storage xmlns='"+
+		nsStorage+"'/></query>")
+	if err != nil {
+		return nil, err
+	}
+	return parseStorage(res)
+}
+
+// parseStorage returns the bookmark storage in the private XML storage
+// response res.
+func parseStorage(res []byte) (*storage, error) {
+	var q privateQuery
+	if err := xml.Unmarshal([]byte("<iq>"+string(res)+"</iq>"), &q); err != nil {
+		return nil, err
+	}
+	return &q.Query.Storage, nil
+}
+
+// rooms returns the bookmarked rooms of account local in st. Invalid
+// bookmarks are ignored.
+func (st *storage) rooms(local string) []config.Room {
+	var rooms []config.Room
+	for _, c := range st.Conferences {
+		r, err := c.room(local, c.JID)
+		if err != nil {
+			continue
+		}
+		rooms = append(rooms, *r)
+	}
+	return rooms
+}
+
+// setPrivateBookmarks replaces the bookmarked room with the JID room in the
+// private XML storage with r (removes it, if r is nil).
+func (s *Session) setPrivateBookmarks(room string, r *config.Room) error {
+	st, err := s.privateBookmarks()
+	if err != nil {
+		return err
+	}
+	var conferences []conference
+	for _, c := range st.Conferences {
+		if j, err := jid.Parse(c.JID); err == nil && j.Bare().String() == room {
+			continue
+		}
+		conferences = append(conferences, c)
+	}
+	if r != nil {
+		conferences = append(conferences, newConference(nsStorage, r))
+	}
+	st.Conferences = conferences
+	x, err := xml.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = s.iq(s.account, "set", "<query xmlns='"+nsPrivate+"'>"+string(x)+"</query>")
+	return err
+}
+
+// PublishBookmark adds the bookmark of room r or updates it.
+func (s *Session) PublishBookmark(r *config.Room) error {
+	storage, err := s.getBookmarkStorage()
+	if err != nil {
+		return err
+	}
+	if storage == privateStorage {
+		return s.setPrivateBookmarks(r.Room, r)
+	}
+	c, err := xml.Marshal(newConference(nsBookmarks, r))
+	if err != nil {
+		return err
+	}
+	options, err := xml.Marshal(&Form{
+		Type: "submit",
+		Fields: []FormField{
+			{Var: "FORM_TYPE", Type: "hidden", Values: []string{nsPublishOption}},
+			{Var: "pubsub#persist_items", Values: []string{"true"}},
+			{Var: "pubsub#max_items", Values: []string{"max"}},
+			{Var: "pubsub#send_last_published_item", Values: []string{"never"}},
+			{Var: "pubsub#access_model", Values: []string{"whitelist"}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.iq(s.account, "set", "<pubsub xmlns='"+nsPubSub+"'><publish node='"+
+		nsBookmarks+"'><item id='"+escape(r.Room)+"'>"+string(c)+
+		"</item></publish><publish-options>"+string(options)+
+		"</publish-options></pubsub>")
+	return err
+}
+
+// RetractBookmark removes the bookmark of the room with the given JID.
+func (s *Session) RetractBookmark(room string) error {
+	r, err := roomJID(room)
+	if err != nil {
+		return err
+	}
+	storage, err := s.getBookmarkStorage()
+	if err != nil {
+		return err
+	}
+	if storage == privateStorage {
+		return s.setPrivateBookmarks(r, nil)
+	}
+	_, err = s.iq(s.account, "set", "<pubsub xmlns='"+nsPubSub+"'><retract node='"+
+		nsBookmarks+"' notify='true'><item id='"+escape(r)+"'/></retract></pubsub>")
+	return err
+}
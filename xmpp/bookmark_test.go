@@ -0,0 +1,64 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/frankbraun/mole/config"
+)
+
+func TestPEPBookmarks(t *testing.T) {
+	rooms, err := parsePEPBookmarks("alice@example.com", []byte(
+		`<pubsub xmlns='http://jabber.org/protocol/pubsub'><items node='urn:xmpp:bookmarks:1'>`+
+			`<item id='Ops@Conference.Example.com'><conference xmlns='urn:xmpp:bookmarks:1' name='Ops' autojoin='true'>`+
+			`<nick>al</nick></conference></item>`+
+			`<item id='invalid@'><conference xmlns='urn:xmpp:bookmarks:1'/></item>`+
+			`</items></pubsub>`))
+	if err != nil {
+		t.Fatalf("parsePEPBookmarks() failed: %v", err)
+	}
+	if len(rooms) != 1 {
+		t.Fatalf("unexpected rooms: %+v", rooms)
+	}
+	r := rooms[0]
+	if r.Room != "ops@conference.example.com" || r.Local != "alice@example.com" ||
+		r.Name != "Ops" || r.Nick != "al" || !r.AutoJoin ||
+		r.History != config.DefaultRoomHistory {
+		t.Errorf("unexpected room: %+v", r)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	st, err := parseStorage([]byte(`<query xmlns='jabber:iq:private'><storage xmlns='storage:bookmarks'>` +
+		`<conference jid='ops@conference.example.com' autojoin='1'><password>pw</password></conference>` +
+		`<url name='Mole' url='https://github.com/frankbraun/mole'/>` +
+		`</storage></query>`))
+	if err != nil {
+		t.Fatalf("parseStorage() failed: %v", err)
+	}
+	rooms := st.rooms("alice@example.com")
+	if len(rooms) != 1 || !rooms[0].AutoJoin || string(rooms[0].Password) != "pw" {
+		t.Fatalf("unexpected rooms: %+v", rooms)
+	}
+	st.Conferences = append(st.Conferences, newConference(nsStorage, &config.Room{
+		Room: "dev@conference.example.com",
+		Name: "Dev",
+		Nick: "al",
+	}))
+	x, err := xml.Marshal(st)
+	if err != nil {
+		t.Fatalf("xml.Marshal() failed: %v", err)
+	}
+	if !strings.Contains(string(x), `url="https://github.com/frankbraun/mole"`) {
+		t.Errorf("other bookmarks not kept: %s", x)
+	}
+	st, err = parseStorage([]byte(`<query xmlns='jabber:iq:private'>` + string(x) + `</query>`))
+	if err != nil {
+		t.Fatalf("parseStorage() failed: %v", err)
+	}
+	rooms = st.rooms("alice@example.com")
+	if len(rooms) != 2 || rooms[1].Name != "Dev" || rooms[1].AutoJoin {
+		t.Errorf("unexpected rooms: %+v", rooms)
+	}
+}
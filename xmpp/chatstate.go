@@ -0,0 +1,69 @@
+package xmpp
+
+import (
+	"bytes"
+
+	"github.com/frankbraun/mole/jid"
+	"github.com/mattn/go-xmpp"
+)
+
+// Namespaces of Chat State Notifications (XEP-0085) and Message Processing
+// Hints (XEP-0334).
+const (
+	nsChatStates = "http://jabber.org/protocol/chatstates"
+	nsHints      = "urn:xmpp:hints"
+)
+
+// Chat states of a conversation partner.
+const (
+	Active    = "active"    // paying attention to the conversation
+	Composing = "composing" // typing a message
+	Paused    = "paused"    // stopped typing
+	Inactive  = "inactive"  // not paying attention to the conversation
+	Gone      = "gone"      // ended the conversation
+)
+
+// ChatStates are all chat states.
+var ChatStates = []string{Active, Composing, Paused, Inactive, Gone}
+
+// ChatState is a standalone chat state notification.
+type ChatState struct {
+	From  string `json:"from"`  // bare JID of sender
+	State string `json:"state"` // one of the chat states
+}
+
+// writeChatState writes the element of the chat state of msg to b (if any).
+// Standalone notifications are not stored by the server.
+func writeChatState(b *bytes.Buffer, msg *Message) {
+	if msg.ChatState == "" || msg.Type != Chat {
+		return
+	}
+	writeElem(b, msg.ChatState, nsChatStates, "")
+	if msg.Text == "" {
+		writeElem(b, "no-store", nsHints, "")
+	}
+}
+
+// parseChatState returns the chat state contained in v (empty if none).
+func parseChatState(v *xmpp.Chat) string {
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space == nsChatStates &&
+			containsString(ChatStates, elem.XMLName.Local) {
+			return elem.XMLName.Local
+		}
+	}
+	return ""
+}
+
+// chatStateEvent returns the event for the standalone chat state
+// notification v from from (nil if v is none).
+func chatStateEvent(from jid.JID, v *xmpp.Chat) *Event {
+	state := parseChatState(v)
+	if state == "" || v.Text != "" || v.Type == Groupchat {
+		return nil
+	}
+	return &Event{
+		Kind:      ChatStateEvent,
+		ChatState: &ChatState{From: from.Bare().String(), State: state},
+	}
+}
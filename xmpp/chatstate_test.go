@@ -0,0 +1,42 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/mattn/go-xmpp"
+)
+
+func TestChatStateEvent(t *testing.T) {
+	s := newTestSession()
+	state := func(name string) []xmpp.XMLElement {
+		return []xmpp.XMLElement{{XMLName: xml.Name{Space: nsChatStates, Local: name}}}
+	}
+	ev := s.chatEvent(&xmpp.Chat{
+		Remote:    "bob@example.com/phone",
+		Type:      "chat",
+		OtherElem: state(Composing),
+	})
+	if ev == nil || ev.Kind != ChatStateEvent || ev.ChatState.From != "bob@example.com" ||
+		ev.ChatState.State != Composing {
+		t.Errorf("unexpected chat state event: %+v", ev)
+	}
+	ev = s.chatEvent(&xmpp.Chat{
+		Remote:    "bob@example.com/phone",
+		Type:      "chat",
+		Text:      "hi",
+		OtherElem: state(Active),
+	})
+	if ev == nil || ev.Kind != MessageEvent || ev.Message.ChatState != Active {
+		t.Errorf("unexpected message event: %+v", ev)
+	}
+	// unknown states and chat states in rooms are ignored
+	for _, v := range []*xmpp.Chat{
+		{Remote: "bob@example.com/phone", Type: "chat", OtherElem: state("dancing")},
+		{Remote: "ops@conference.example.com/bob", Type: "groupchat", OtherElem: state(Paused)},
+	} {
+		if ev := s.chatEvent(v); ev != nil {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package xmpp
+
+import (
+	"github.com/mattn/go-xmpp"
+)
+
+// nsCorrect is the namespace of Last Message Correction (XEP-0308).
+const nsCorrect = "urn:xmpp:message-correct:0"
+
+// elemAttr returns the value of the attribute attr of the first element name
+// in namespace space contained in v (empty if there is none).
+func elemAttr(v *xmpp.Chat, space, name, attr string) string {
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space != space || elem.XMLName.Local != name {
+			continue
+		}
+		for _, a := range elem.Attr {
+			if a.Name.Local == attr {
+				return a.Value
+			}
+		}
+	}
+	return ""
+}
+
+// parseReplace returns the ID of the message corrected by v (empty if v is
+// no correction).
+func parseReplace(v *xmpp.Chat) string {
+	return elemAttr(v, nsCorrect, "replace", "id")
+}
@@ -0,0 +1,27 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/mattn/go-xmpp"
+)
+
+func TestCorrection(t *testing.T) {
+	s := newTestSession()
+	replace := []xmpp.XMLElement{{
+		XMLName: xml.Name{Space: nsCorrect, Local: "replace"},
+		Attr:    []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "m1"}},
+	}}
+	tests := []xmpp.Chat{
+		{Remote: "bob@example.com/phone", ID: "m2", Type: "chat", Text: "fixed", OtherElem: replace},
+		{Remote: "ops@conference.example.com/bob", ID: "m2", Type: "groupchat", Text: "fixed", OtherElem: replace},
+	}
+	for _, v := range tests {
+		ev := s.chatEvent(&v)
+		if ev == nil || ev.Kind != MessageEvent || ev.Message.ID != "m2" ||
+			ev.Message.Replace != "m1" || ev.Message.Text != "fixed" {
+			t.Errorf("unexpected correction event: %+v", ev)
+		}
+	}
+}
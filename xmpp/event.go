@@ -0,0 +1,73 @@
+package xmpp
+
+import (
+	"time"
+)
+
+// Event kinds.
+const (
+	MessageEvent    = "message"    // a chat message has been received
+	PresenceEvent   = "presence"   // a presence stanza has been received
+	DisconnectEvent = "disconnect" // the connection to the server was lost
+	OccupantEvent   = "occupant"   // an occupant joined, left, or changed in a room
+	SubjectEvent    = "subject"    // the subject of a room has been set
+	InviteEvent     = "invite"     // an invitation to a room has been received
+	ReceiptEvent    = "receipt"    // the delivery state of a sent message changed
+	ChatStateEvent  = "chatstate"  // a conversation partner changed its chat state
+	RetractEvent    = "retract"    // a message has been retracted
+	ReactionEvent   = "reaction"   // a sender changed its reactions to a message
+)
+
+// Message types.
+const (
+	Chat      = "chat"      // one-to-one chat message (default)
+	Groupchat = "groupchat" // message to or from a multi-user chat room
+)
+
+// Message is a chat message.
+type Message struct {
+	Type    string    `json:"type,omitempty"` // Chat (if empty) or Groupchat
+	From    string    `json:"from,omitempty"` // bare JID of sender (or room)
+	Nick    string    `json:"nick,omitempty"` // nickname of sender in room
+	To      string    `json:"to,omitempty"`   // bare JID of recipient (or room)
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	Delayed bool      `json:"delayed,omitempty"` // delivered from history or offline storage
+
+	ID        string `json:"id,omitempty"`        // stanza ID (generated when sent, if empty)
+	Receipt   bool   `json:"receipt,omitempty"`   // received: sender requests a delivery receipt
+	Markable  bool   `json:"markable,omitempty"`  // received: sender supports chat markers
+	Marker    string `json:"marker,omitempty"`    // to send: Delivered or Displayed marker for ID (without text)
+	State     string `json:"state,omitempty"`     // sent: delivery state
+	ChatState string `json:"chatState,omitempty"` // chat state of sender (standalone notification, if without text)
+	Replace   string `json:"replace,omitempty"`   // ID of the message corrected by this one
+	StanzaID  string `json:"stanzaId,omitempty"`  // received in room: ID assigned by the room
+	Retracted bool   `json:"retracted,omitempty"` // stored: retracted by sender or moderator (text removed)
+	ReplyTo   string `json:"replyTo,omitempty"`   // JID of the author of the message replied to (in room with nick)
+	ReplyID   string `json:"replyId,omitempty"`   // ID of the message replied to (stanza ID in rooms, unless our own)
+	Quote     string `json:"quote,omitempty"`     // text of the message replied to (fallback, if received)
+}
+
+// Presence is a presence update or a subscription request.
+type Presence struct {
+	From   string `json:"from"`             // JID of sender
+	Type   string `json:"type,omitempty"`   // e.g., "unavailable" or "subscribe"
+	Show   string `json:"show,omitempty"`   // e.g., "away" or "dnd"
+	Status string `json:"status,omitempty"` // human readable status
+}
+
+// Event is an event of an XMPP session.
+type Event struct {
+	Kind       string      `json:"kind"`                 // one of the event kinds
+	Account    string      `json:"account"`              // username of the session
+	Message    *Message    `json:"message,omitempty"`    // for MessageEvent
+	Presence   *Presence   `json:"presence,omitempty"`   // for PresenceEvent
+	Occupant   *Occupant   `json:"occupant,omitempty"`   // for OccupantEvent
+	Subject    *Subject    `json:"subject,omitempty"`    // for SubjectEvent
+	Invite     *Invite     `json:"invite,omitempty"`     // for InviteEvent
+	Receipt    *Receipt    `json:"receipt,omitempty"`    // for ReceiptEvent
+	ChatState  *ChatState  `json:"chatState,omitempty"`  // for ChatStateEvent
+	Retraction *Retraction `json:"retraction,omitempty"` // for RetractEvent
+	Reactions  *Reactions  `json:"reactions,omitempty"`  // for ReactionEvent
+	Error      string      `json:"error,omitempty"`      // for DisconnectEvent
+}
@@ -0,0 +1,81 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+)
+
+// nsData is the namespace of data forms (XEP-0004).
+const nsData = "jabber:x:data"
+
+// Form is a data form (XEP-0004).
+type Form struct {
+	XMLName      xml.Name    `xml:"jabber:x:data x" json:"-"`
+	Type         string      `xml:"type,attr" json:"type"` // form, submit, cancel, or result
+	Title        string      `xml:"title,omitempty" json:"title,omitempty"`
+	Instructions string      `xml:"instructions,omitempty" json:"instructions,omitempty"`
+	Fields       []FormField `xml:"field" json:"fields"`
+}
+
+// FormField is a field of a data form.
+type FormField struct {
+	Var      string       `xml:"var,attr,omitempty" json:"var,omitempty"`
+	Type     string       `xml:"type,attr,omitempty" json:"type,omitempty"` // e.g., text-single or boolean
+	Label    string       `xml:"label,attr,omitempty" json:"label,omitempty"`
+	Required *struct{}    `xml:"required" json:"required,omitempty"`
+	Values   []string     `xml:"value" json:"values,omitempty"`
+	Options  []FormOption `xml:"option" json:"options,omitempty"`
+}
+
+// FormOption is an option of a list field.
+type FormOption struct {
+	Label string `xml:"label,attr,omitempty" json:"label,omitempty"`
+	Value string `xml:"value" json:"value"`
+}
+
+// findForm returns the first data form contained in inner XML.
+func findForm(inner []byte) (*Form, error) {
+	d := xml.NewDecoder(bytes.NewReader(inner))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, errors.New("xmpp: no data form found")
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Space != nsData || se.Name.Local != "x" {
+			continue
+		}
+		var f Form
+		if err := d.DecodeElement(&f, &se); err != nil {
+			return nil, err
+		}
+		return &f, nil
+	}
+}
+
+// Submit returns the form to submit with the values of f. Fixed fields are
+// omitted.
+func (f *Form) Submit() *Form {
+	submit := &Form{Type: "submit"}
+	for _, field := range f.Fields {
+		if field.Type == "fixed" || field.Var == "" {
+			continue
+		}
+		submit.Fields = append(submit.Fields, FormField{
+			Var:    field.Var,
+			Values: field.Values,
+		})
+	}
+	return submit
+}
+
+// Field returns the field with the given var or nil.
+func (f *Form) Field(name string) *FormField {
+	for i := range f.Fields {
+		if f.Fields[i].Var == name {
+			return &f.Fields[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package xmpp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+)
+
+// iqTimeout is the time to wait for the response to an IQ request.
+const iqTimeout = 30 * time.Second
+
+// ErrTimeout is returned if the server did not respond in time.
+var ErrTimeout = errors.New("xmpp: request timed out")
+
+// StanzaError is an error returned by the server or a remote entity.
+type StanzaError struct {
+	Condition string // defined condition (e.g., "forbidden")
+	Text      string // optional description
+}
+
+// Error returns the error condition and its description (if any).
+func (e *StanzaError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("xmpp: %s (%s)", e.Condition, e.Text)
+	}
+	return "xmpp: " + e.Condition
+}
+
+// stanzaError is the <error/> element of a stanza.
+type stanzaError struct {
+	Conditions []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+	Text string `xml:"text"`
+}
+
+// parseError returns the error contained in the inner XML of an IQ response.
+func parseError(inner []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(inner))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return &StanzaError{Condition: "undefined-condition"}
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "error" {
+			continue
+		}
+		var e stanzaError
+		if err := d.DecodeElement(&e, &se); err != nil {
+			return &StanzaError{Condition: "undefined-condition"}
+		}
+		serr := &StanzaError{Condition: "undefined-condition", Text: e.Text}
+		for _, c := range e.Conditions {
+			if c.XMLName.Local != "text" {
+				serr.Condition = c.XMLName.Local
+				break
+			}
+		}
+		return serr
+	}
+}
+
+// NewID returns a new random stanza ID.
+func NewID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return "mole-" + hex.EncodeToString(b[:]), nil
+}
+
+// iq sends an IQ request of type typ with the given inner XML to the JID to
+// and waits for the response. It returns the inner XML of the result.
+func (s *Session) iq(to, typ, inner string) ([]byte, error) {
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	res := make(chan xmpp.IQ, 1)
+	s.mutex.Lock()
+	s.pending[id] = res
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pending, id)
+		s.mutex.Unlock()
+	}()
+	var b bytes.Buffer
+	b.WriteString("<iq to='")
+	xml.EscapeText(&b, []byte(to))
+	b.WriteString("' type='" + typ + "' id='" + id + "'>" + inner + "</iq>")
+	if _, err := s.talk.SendOrg(b.String()); err != nil {
+		return nil, err
+	}
+	select {
+	case v := <-res:
+		if v.Type == "error" {
+			return nil, parseError(v.Query)
+		}
+		return v.Query, nil
+	case <-time.After(iqTimeout):
+		return nil, ErrTimeout
+	}
+}
+
+// handleIQ passes the IQ response v to the waiting request (if any). It
+// returns the receipt event if v acknowledges a sent message.
+func (s *Session) handleIQ(v *xmpp.IQ) *Event {
+	if v.Type != "result" && v.Type != "error" {
+		return nil
+	}
+	s.mutex.Lock()
+	res := s.pending[v.ID]
+	ack, isAck := s.acks[v.ID]
+	delete(s.acks, v.ID)
+	s.mutex.Unlock()
+	if res != nil {
+		select {
+		case res <- *v:
+		default: // duplicate response
+		}
+	}
+	if isAck {
+		// even an error response means that the server processed the
+		// preceding message
+		return &Event{Kind: ReceiptEvent, Receipt: &ack}
+	}
+	return nil
+}
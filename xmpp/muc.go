@@ -0,0 +1,248 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/util"
+	"github.com/mattn/go-xmpp"
+)
+
+// Multi-User Chat (XEP-0045) namespaces.
+const (
+	nsMUC     = "http://jabber.org/protocol/muc"
+	nsMUCUser = "http://jabber.org/protocol/muc#user"
+)
+
+// Occupant is an occupant of a multi-user chat room.
+type Occupant struct {
+	Room        string `json:"room"`              // bare JID of room
+	Nick        string `json:"nick"`              // nickname in room
+	JID         string `json:"jid,omitempty"`     // real JID (if known)
+	Role        string `json:"role"`              // moderator, participant, visitor, or none
+	Affiliation string `json:"affiliation"`       // owner, admin, member, outcast, or none
+	Self        bool   `json:"self,omitempty"`    // it is our own occupant
+	Left        bool   `json:"left,omitempty"`    // occupant has left the room
+	Kicked      bool   `json:"kicked,omitempty"`  // occupant has been kicked (if Left)
+	Banned      bool   `json:"banned,omitempty"`  // occupant has been banned (if Left)
+	NewNick     string `json:"newNick,omitempty"` // new nickname (if Left because of a nickname change)
+	Reason      string `json:"reason,omitempty"`  // reason for kick, ban, or change
+	Error       string `json:"error,omitempty"`   // joining or changing the nickname failed (if Self)
+}
+
+// Invite is an invitation to a multi-user chat room.
+type Invite struct {
+	Room     string `json:"room"`               // bare JID of room
+	From     string `json:"from"`               // bare JID of inviter
+	Reason   string `json:"reason,omitempty"`   // optional reason
+	Password string `json:"password,omitempty"` // optional room password
+	Direct   bool   `json:"direct,omitempty"`   // direct invitation (XEP-0249)
+}
+
+// Subject is the subject of a multi-user chat room.
+type Subject struct {
+	Room string `json:"room"`           // bare JID of room
+	Nick string `json:"nick,omitempty"` // nickname of occupant who set it
+	Text string `json:"text"`
+}
+
+// mucUser is the <x/> element of MUC presences.
+type mucUser struct {
+	Item struct {
+		Affiliation string `xml:"affiliation,attr"`
+		Role        string `xml:"role,attr"`
+		JID         string `xml:"jid,attr"`
+		Nick        string `xml:"nick,attr"`
+		Reason      string `xml:"reason"`
+	} `xml:"item"`
+	Invite *struct {
+		From   string `xml:"from,attr"`
+		Reason string `xml:"reason"`
+	} `xml:"invite"`
+	Password string `xml:"password"`
+	Status   []struct {
+		Code int `xml:"code,attr"`
+	} `xml:"status"`
+}
+
+// parseOccupant returns the occupant if p is a MUC presence from room
+// occupant from.
+func parseOccupant(from jid.JID, p *xmpp.Presence) *Occupant {
+	for _, elem := range p.OtherElem {
+		if elem.XMLName.Space != nsMUCUser || elem.XMLName.Local != "x" {
+			continue
+		}
+		var x mucUser
+		err := xml.Unmarshal([]byte("<x>"+elem.InnerXML+"</x>"), &x)
+		if err != nil {
+			return nil
+		}
+		o := &Occupant{
+			Room:        from.Bare().String(),
+			Nick:        from.Resource,
+			JID:         x.Item.JID,
+			Role:        x.Item.Role,
+			Affiliation: x.Item.Affiliation,
+			Left:        p.Type == "unavailable",
+			Reason:      x.Item.Reason,
+		}
+		for _, status := range x.Status {
+			switch status.Code {
+			case 110: // self-presence
+				o.Self = true
+			case 301:
+				o.Banned = true
+			case 303: // nickname change
+				if o.Left {
+					o.NewNick = x.Item.Nick
+				}
+			case 307:
+				o.Kicked = true
+			}
+		}
+		return o
+	}
+	return nil
+}
+
+// parseInvite returns the invitation if the message v from from contains a
+// mediated or direct invitation.
+func parseInvite(from jid.JID, v *xmpp.Chat) *Invite {
+	for _, elem := range v.OtherElem {
+		switch {
+		case elem.XMLName.Space == nsMUCUser && elem.XMLName.Local == "x":
+			var x mucUser
+			err := xml.Unmarshal([]byte("<x>"+elem.InnerXML+"</x>"), &x)
+			if err != nil || x.Invite == nil {
+				continue
+			}
+			inviter, err := jid.Parse(x.Invite.From)
+			if err != nil {
+				continue
+			}
+			return &Invite{
+				Room:     from.Bare().String(),
+				From:     inviter.Bare().String(),
+				Reason:   x.Invite.Reason,
+				Password: x.Password,
+			}
+		case elem.XMLName.Space == nsXConference && elem.XMLName.Local == "x":
+			i := &Invite{From: from.Bare().String(), Direct: true}
+			for _, attr := range elem.Attr {
+				switch attr.Name.Local {
+				case "jid":
+					i.Room = attr.Value
+				case "reason":
+					i.Reason = attr.Value
+				case "password":
+					i.Password = attr.Value
+				}
+			}
+			room, err := jid.Parse(i.Room)
+			if err != nil {
+				continue
+			}
+			i.Room = room.Bare().String()
+			return i
+		}
+	}
+	return nil
+}
+
+// sendPresence sends a presence stanza to the given JID with the given type
+// (if not empty) and inner XML.
+func (s *Session) sendPresence(to, typ, inner string) error {
+	var b bytes.Buffer
+	b.WriteString("<presence to='")
+	xml.EscapeText(&b, []byte(to))
+	b.WriteString("'")
+	if typ != "" {
+		b.WriteString(" type='" + typ + "'")
+	}
+	b.WriteString(">" + inner + "</presence>")
+	_, err := s.talk.SendOrg(b.String())
+	return err
+}
+
+// JoinRoom joins the multi-user chat room with the given nickname and
+// (optional) password. At most history messages are requested from the room
+// history.
+func (s *Session) JoinRoom(room, nick string, password []byte, history int) error {
+	r, err := jid.Parse(room)
+	if err != nil {
+		return err
+	}
+	r.Resource = nick
+	if history < 0 {
+		history = 0
+	}
+	var b bytes.Buffer
+	b.WriteString("<x xmlns='" + nsMUC + "'>")
+	b.WriteString("<history maxstanzas='" + strconv.Itoa(history) + "'/>")
+	if len(password) > 0 {
+		b.WriteString("<password>")
+		xml.EscapeText(&b, password)
+		b.WriteString("</password>")
+	}
+	b.WriteString("</x>")
+	defer util.Wipe(b.Bytes())
+	if err := s.sendPresence(r.String(), "", b.String()); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.rooms[r.Bare().String()] = nick
+	s.mutex.Unlock()
+	return nil
+}
+
+// LeaveRoom leaves the multi-user chat room.
+func (s *Session) LeaveRoom(room string) error {
+	r, err := jid.Parse(room)
+	if err != nil {
+		return err
+	}
+	r.Resource = s.nick(r.Bare().String())
+	if r.Resource == "" {
+		return fmt.Errorf("xmpp: room '%s' not joined", r.Bare())
+	}
+	return s.sendPresence(r.String(), "unavailable", "")
+}
+
+// ChangeNick changes our nickname in the joined multi-user chat room.
+func (s *Session) ChangeNick(room, nick string) error {
+	r, err := jid.Parse(room)
+	if err != nil {
+		return err
+	}
+	if s.nick(r.Bare().String()) == "" {
+		return fmt.Errorf("xmpp: room '%s' not joined", r.Bare())
+	}
+	r.Resource = nick
+	return s.sendPresence(r.String(), "", "")
+}
+
+// SetSubject changes the subject of the multi-user chat room.
+func (s *Session) SetSubject(room, subject string) error {
+	r, err := jid.Parse(room)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString("<message to='")
+	xml.EscapeText(&b, []byte(r.Bare().String()))
+	b.WriteString("' type='groupchat'><subject>")
+	xml.EscapeText(&b, []byte(subject))
+	b.WriteString("</subject></message>")
+	_, err = s.talk.SendOrg(b.String())
+	return err
+}
+
+// nick returns our nickname in room (empty if not joined).
+func (s *Session) nick(room string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rooms[room]
+}
@@ -0,0 +1,216 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+)
+
+func newTestSession() *Session {
+	return &Session{
+		account:   "alice@example.com",
+		rooms:     map[string]string{"ops@conference.example.com": "al"},
+		stanzaIDs: make(map[string]string),
+	}
+}
+
+func mucUserElem(inner string) xmpp.XMLElement {
+	return xmpp.XMLElement{
+		XMLName:  xml.Name{Space: nsMUCUser, Local: "x"},
+		InnerXML: inner,
+	}
+}
+
+func TestPresenceEvent(t *testing.T) {
+	s := newTestSession()
+	ev := s.presenceEvent(&xmpp.Presence{
+		From: "ops@conference.example.com/bob",
+		OtherElem: []xmpp.XMLElement{mucUserElem(
+			`<item affiliation="owner" role="moderator" jid="bob@example.com/x"/>`)},
+	})
+	if ev.Kind != OccupantEvent || ev.Occupant.Nick != "bob" ||
+		ev.Occupant.Role != "moderator" || ev.Occupant.Affiliation != "owner" ||
+		ev.Occupant.JID != "bob@example.com/x" || ev.Occupant.Self {
+		t.Errorf("unexpected occupant event: %+v", ev.Occupant)
+	}
+	// self-presence with changed nickname
+	ev = s.presenceEvent(&xmpp.Presence{
+		From: "ops@conference.example.com/al2",
+		OtherElem: []xmpp.XMLElement{mucUserElem(
+			`<item affiliation="none" role="participant"/><status code="110"/><status code="210"/>`)},
+	})
+	if !ev.Occupant.Self || s.nick("ops@conference.example.com") != "al2" {
+		t.Errorf("self-presence not handled: %+v", ev.Occupant)
+	}
+	// nickname change
+	ev = s.presenceEvent(&xmpp.Presence{
+		From: "ops@conference.example.com/al3",
+		Type: "error",
+	})
+	if ev.Occupant.Error == "" || ev.Occupant.Left || s.nick("ops@conference.example.com") != "al2" {
+		t.Errorf("failed nickname change not handled: %+v", ev.Occupant)
+	}
+	ev = s.presenceEvent(&xmpp.Presence{
+		From: "ops@conference.example.com/al2",
+		Type: "unavailable",
+		OtherElem: []xmpp.XMLElement{mucUserElem(
+			`<item affiliation="none" role="participant" nick="al3"/><status code="110"/><status code="303"/>`)},
+	})
+	if !ev.Occupant.Left || ev.Occupant.NewNick != "al3" || s.nick("ops@conference.example.com") != "al3" {
+		t.Errorf("nickname change not handled: %+v", ev.Occupant)
+	}
+	// kicked occupant
+	ev = s.presenceEvent(&xmpp.Presence{
+		From: "ops@conference.example.com/bob",
+		Type: "unavailable",
+		OtherElem: []xmpp.XMLElement{mucUserElem(
+			`<item affiliation="none" role="none"><reason>spam</reason></item><status code="307"/>`)},
+	})
+	if !ev.Occupant.Left || !ev.Occupant.Kicked || ev.Occupant.Banned ||
+		ev.Occupant.Reason != "spam" {
+		t.Errorf("kick not handled: %+v", ev.Occupant)
+	}
+	// leaving
+	ev = s.presenceEvent(&xmpp.Presence{
+		From: "ops@conference.example.com/al3",
+		Type: "unavailable",
+		OtherElem: []xmpp.XMLElement{mucUserElem(
+			`<item affiliation="none" role="none"/><status code="110"/>`)},
+	})
+	if !ev.Occupant.Left || s.nick("ops@conference.example.com") != "" {
+		t.Errorf("leaving not handled: %+v", ev.Occupant)
+	}
+	// presence from contact
+	ev = s.presenceEvent(&xmpp.Presence{From: "bob@example.com/phone", Type: "subscribe"})
+	if ev.Kind != PresenceEvent || ev.Presence.Type != "subscribe" {
+		t.Errorf("unexpected presence event: %+v", ev)
+	}
+}
+
+func TestChatEvent(t *testing.T) {
+	s := newTestSession()
+	ev := s.chatEvent(&xmpp.Chat{Remote: "bob@example.com/phone", Type: "chat", Text: "hi"})
+	if ev.Kind != MessageEvent || ev.Message.From != "bob@example.com" ||
+		ev.Message.Type != "" || ev.Message.Delayed {
+		t.Errorf("unexpected chat event: %+v", ev.Message)
+	}
+	ev = s.chatEvent(&xmpp.Chat{Remote: "ops@conference.example.com/bob", Type: "groupchat", Text: "hi"})
+	if ev.Message.Type != Groupchat || ev.Message.From != "ops@conference.example.com" ||
+		ev.Message.Nick != "bob" {
+		t.Errorf("unexpected groupchat event: %+v", ev.Message)
+	}
+	// reflected own message is ignored, unless it is from the history
+	if ev := s.chatEvent(&xmpp.Chat{Remote: "ops@conference.example.com/al", Type: "groupchat", Text: "hi"}); ev != nil {
+		t.Errorf("own message not ignored: %+v", ev.Message)
+	}
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ev = s.chatEvent(&xmpp.Chat{Remote: "ops@conference.example.com/al", Type: "groupchat", Text: "hi", Stamp: stamp})
+	if ev == nil || !ev.Message.Delayed || !ev.Message.Time.Equal(stamp) {
+		t.Errorf("own history message not handled: %+v", ev)
+	}
+	ev = s.chatEvent(&xmpp.Chat{Remote: "ops@conference.example.com/bob", Type: "groupchat", Subject: "on call"})
+	if ev.Kind != SubjectEvent || ev.Subject.Text != "on call" || ev.Subject.Nick != "bob" {
+		t.Errorf("unexpected subject event: %+v", ev)
+	}
+}
+
+func TestInvite(t *testing.T) {
+	s := newTestSession()
+	ev := s.chatEvent(&xmpp.Chat{
+		Remote: "dev@conference.example.com",
+		OtherElem: []xmpp.XMLElement{mucUserElem(
+			`<invite from="bob@example.com/phone"><reason>join us</reason></invite><password>pw</password>`)},
+	})
+	if ev.Kind != InviteEvent || ev.Invite.Room != "dev@conference.example.com" ||
+		ev.Invite.From != "bob@example.com" || ev.Invite.Reason != "join us" ||
+		ev.Invite.Password != "pw" || ev.Invite.Direct {
+		t.Errorf("unexpected mediated invite: %+v", ev.Invite)
+	}
+	ev = s.chatEvent(&xmpp.Chat{
+		Remote: "bob@example.com/phone",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsXConference, Local: "x"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "jid"}, Value: "dev@conference.example.com"},
+				{Name: xml.Name{Local: "reason"}, Value: "join us"},
+			},
+		}},
+	})
+	if ev.Kind != InviteEvent || ev.Invite.Room != "dev@conference.example.com" ||
+		ev.Invite.From != "bob@example.com" || ev.Invite.Reason != "join us" ||
+		!ev.Invite.Direct {
+		t.Errorf("unexpected direct invite: %+v", ev.Invite)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	err := parseError([]byte(`<query xmlns='http://jabber.org/protocol/muc#admin'/>` +
+		`<error type='auth'><forbidden xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/>` +
+		`<text xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'>not allowed</text></error>`))
+	serr, ok := err.(*StanzaError)
+	if !ok || serr.Condition != "forbidden" || serr.Text != "not allowed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestForm(t *testing.T) {
+	f, err := findForm([]byte(`<query xmlns='http://jabber.org/protocol/muc#owner'>` +
+		`<x xmlns='jabber:x:data' type='form'><title>Configuration</title>` +
+		`<field type='hidden' var='FORM_TYPE'><value>http://jabber.org/protocol/muc#roomconfig</value></field>` +
+		`<field type='fixed'><value>Room settings</value></field>` +
+		`<field type='boolean' var='muc#roomconfig_persistentroom' label='Persistent'><value>0</value></field>` +
+		`<field type='list-single' var='muc#roomconfig_whois'><value>moderators</value>` +
+		`<option label='Anyone'><value>anyone</value></option></field>` +
+		`</x></query>`))
+	if err != nil {
+		t.Fatalf("findForm() failed: %v", err)
+	}
+	if f.Title != "Configuration" || len(f.Fields) != 4 ||
+		len(f.Field("muc#roomconfig_whois").Options) != 1 {
+		t.Fatalf("unexpected form: %+v", f)
+	}
+	f.Field("muc#roomconfig_persistentroom").Values = []string{"1"}
+	submit := f.Submit()
+	if submit.Type != "submit" || len(submit.Fields) != 3 {
+		t.Fatalf("unexpected submit form: %+v", submit)
+	}
+	b, err := xml.Marshal(submit)
+	if err != nil {
+		t.Fatalf("xml.Marshal() failed: %v", err)
+	}
+	f, err = findForm(b)
+	if err != nil {
+		t.Fatalf("findForm() failed: %v", err)
+	}
+	if f.Field("muc#roomconfig_persistentroom").Values[0] != "1" {
+		t.Errorf("unexpected submitted form: %s", b)
+	}
+	if _, err := findForm([]byte(`<query/>`)); err == nil {
+		t.Error("findForm() should fail without form")
+	}
+}
+
+func TestRoomInfo(t *testing.T) {
+	room := "room@conference.example.com"
+	info, err := parseRoomInfo(room, []byte(`<query xmlns='http://jabber.org/protocol/disco#info'>`+
+		`<identity category='conference' type='text' name='Room'/>`+
+		`<feature var='http://jabber.org/protocol/muc'/>`+
+		`<feature var='muc_membersonly'/><feature var='muc_nonanonymous'/>`+
+		`</query>`))
+	if err != nil {
+		t.Fatalf("parseRoomInfo() failed: %v", err)
+	}
+	if info.Room != room || !info.Encryptable() {
+		t.Errorf("unexpected room info: %+v", info)
+	}
+	info, err = parseRoomInfo(room, []byte(`<query xmlns='http://jabber.org/protocol/disco#info'>`+
+		`<feature var='muc_open'/><feature var='muc_nonanonymous'/></query>`))
+	if err != nil {
+		t.Fatalf("parseRoomInfo() failed: %v", err)
+	}
+	if info.MembersOnly || !info.NonAnonymous || info.Encryptable() {
+		t.Errorf("unexpected room info: %+v", info)
+	}
+}
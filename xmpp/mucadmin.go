@@ -0,0 +1,212 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/frankbraun/mole/jid"
+	"github.com/frankbraun/mole/util"
+)
+
+// More Multi-User Chat (XEP-0045) and Direct MUC Invitations (XEP-0249)
+// namespaces.
+const (
+	nsMUCAdmin    = "http://jabber.org/protocol/muc#admin"
+	nsMUCOwner    = "http://jabber.org/protocol/muc#owner"
+	nsXConference = "jabber:x:conference"
+	nsDiscoInfo   = "http://jabber.org/protocol/disco#info"
+)
+
+// Roles and affiliations of room occupants.
+var (
+	Roles        = []string{"moderator", "participant", "visitor", "none"}
+	Affiliations = []string{"owner", "admin", "member", "outcast", "none"}
+)
+
+// escape returns s with XML special characters escaped.
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// roomJID parses room and returns its bare JID.
+func roomJID(room string) (string, error) {
+	r, err := jid.Parse(room)
+	if err != nil {
+		return "", err
+	}
+	return r.Bare().String(), nil
+}
+
+// adminItem sends a muc#admin item with the given attributes and reason.
+func (s *Session) adminItem(room, attrs, reason string) error {
+	r, err := roomJID(room)
+	if err != nil {
+		return err
+	}
+	item := "<item " + attrs + ">"
+	if reason != "" {
+		item += "<reason>" + escape(reason) + "</reason>"
+	}
+	item += "</item>"
+	_, err = s.iq(r, "set", "<query xmlns='"+nsMUCAdmin+"'>"+item+"</query>")
+	return err
+}
+
+// SetRole sets the role of the occupant with nickname nick in room (e.g.,
+// "none" kicks the occupant, "participant" grants voice).
+func (s *Session) SetRole(room, nick, role, reason string) error {
+	if !containsString(Roles, role) {
+		return fmt.Errorf("xmpp: unknown role '%s'", role)
+	}
+	return s.adminItem(room, "nick='"+escape(nick)+"' role='"+role+"'", reason)
+}
+
+// SetAffiliation sets the affiliation of the user with the bare JID user in
+// room (e.g., "outcast" bans the user, "member" grants membership).
+func (s *Session) SetAffiliation(room, user, affiliation, reason string) error {
+	if !containsString(Affiliations, affiliation) {
+		return fmt.Errorf("xmpp: unknown affiliation '%s'", affiliation)
+	}
+	u, err := jid.Parse(user)
+	if err != nil {
+		return err
+	}
+	return s.adminItem(room, "jid='"+escape(u.Bare().String())+
+		"' affiliation='"+affiliation+"'", reason)
+}
+
+// Invite the user with JID user to room via the room (mediated invitation).
+func (s *Session) Invite(room, user, reason string) error {
+	r, err := roomJID(room)
+	if err != nil {
+		return err
+	}
+	u, err := jid.Parse(user)
+	if err != nil {
+		return err
+	}
+	invite := "<invite to='" + escape(u.String()) + "'>"
+	if reason != "" {
+		invite += "<reason>" + escape(reason) + "</reason>"
+	}
+	invite += "</invite>"
+	_, err = s.talk.SendOrg("<message to='" + escape(r) + "'><x xmlns='" +
+		nsMUCUser + "'>" + invite + "</x></message>")
+	return err
+}
+
+// DirectInvite invites the user with JID user to room directly (XEP-0249).
+// The optional password is included in the invitation.
+func (s *Session) DirectInvite(room, user, reason string, password []byte) error {
+	r, err := roomJID(room)
+	if err != nil {
+		return err
+	}
+	u, err := jid.Parse(user)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString("<message to='" + escape(u.String()) + "'><x xmlns='" +
+		nsXConference + "' jid='" + escape(r) + "'")
+	if reason != "" {
+		b.WriteString(" reason='" + escape(reason) + "'")
+	}
+	if len(password) > 0 {
+		b.WriteString(" password='")
+		xml.EscapeText(&b, password)
+		b.WriteString("'")
+	}
+	b.WriteString("/></message>")
+	defer util.Wipe(b.Bytes())
+	_, err = s.talk.SendOrg(b.String())
+	return err
+}
+
+// RoomConfig requests the configuration form of room (owners only).
+func (s *Session) RoomConfig(room string) (*Form, error) {
+	r, err := roomJID(room)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.iq(r, "get", "<query xmlns='"+nsMUCOwner+"'/>")
+	if err != nil {
+		return nil, err
+	}
+	return findForm(res)
+}
+
+// ConfigureRoom submits the configuration form of room.
+func (s *Session) ConfigureRoom(room string, form *Form) error {
+	r, err := roomJID(room)
+	if err != nil {
+		return err
+	}
+	x, err := xml.Marshal(form.Submit())
+	if err != nil {
+		return err
+	}
+	_, err = s.iq(r, "set", "<query xmlns='"+nsMUCOwner+"'>"+string(x)+"</query>")
+	return err
+}
+
+// RoomInfo is the information about a room relevant to its encryption
+// (discovered via Service Discovery, XEP-0030).
+type RoomInfo struct {
+	Room         string `json:"room"`
+	MembersOnly  bool   `json:"membersOnly,omitempty"`
+	NonAnonymous bool   `json:"nonAnonymous,omitempty"`
+}
+
+// Encryptable reports whether messages to the room could be encrypted: only
+// members-only, non-anonymous rooms let us know the keys of all occupants.
+func (i *RoomInfo) Encryptable() bool {
+	return i.MembersOnly && i.NonAnonymous
+}
+
+// RoomInfo requests the information about room.
+func (s *Session) RoomInfo(room string) (*RoomInfo, error) {
+	r, err := roomJID(room)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.iq(r, "get", "<query xmlns='"+nsDiscoInfo+"'/>")
+	if err != nil {
+		return nil, err
+	}
+	return parseRoomInfo(r, res)
+}
+
+// parseRoomInfo parses the disco#info result res of room.
+func parseRoomInfo(room string, res []byte) (*RoomInfo, error) {
+	var q struct {
+		Features []struct {
+			Var string `xml:"var,attr"`
+		} `xml:"query>feature"`
+	}
+	if err := xml.Unmarshal([]byte("<iq>"+string(res)+"</iq>"), &q); err != nil {
+		return nil, err
+	}
+	info := &RoomInfo{Room: room}
+	for _, f := range q.Features {
+		switch f.Var {
+		case "muc_membersonly":
+			info.MembersOnly = true
+		case "muc_nonanonymous":
+			info.NonAnonymous = true
+		}
+	}
+	return info, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/frankbraun/mole/jid"
+	"github.com/mattn/go-xmpp"
+)
+
+// nsReactions is the namespace of Message Reactions (XEP-0444).
+const nsReactions = "urn:xmpp:reactions:0"
+
+// Limits of the reactions accepted per sender and message (further and
+// longer reactions are ignored).
+const (
+	maxReactions      = 16
+	maxReactionLength = 32
+)
+
+// Reactions are all reactions of a sender to a message.
+type Reactions struct {
+	From      string   `json:"from"`           // bare JID of sender (or room)
+	Nick      string   `json:"nick,omitempty"` // nickname of sender in room
+	ID        string   `json:"id"`             // ID of message reacted to (stanza ID in rooms, unless our own)
+	Own       bool     `json:"own,omitempty"`  // sent by us (via a daemon)
+	Reactions []string `json:"reactions"`      // emojis (empty if all have been removed)
+}
+
+// reactionsStanza returns the message stanza with the given ID and type to
+// to, which sets our reactions to the message target.
+func reactionsStanza(to, typ, id, target string, reactions []string) string {
+	var b bytes.Buffer
+	b.WriteString("<message to='" + escape(to) + "' type='" + typ + "' id='" +
+		escape(id) + "'><reactions xmlns='" + nsReactions + "' id='" +
+		escape(target) + "'>")
+	for _, r := range reactions {
+		b.WriteString("<reaction>")
+		xml.EscapeText(&b, []byte(r))
+		b.WriteString("</reaction>")
+	}
+	b.WriteString("</reactions>")
+	writeElem(&b, "store", nsHints, "")
+	b.WriteString("</message>")
+	return b.String()
+}
+
+// roomStanzaID returns the stanza ID assigned by a room to our message with
+// the given ID.
+func (s *Session) roomStanzaID(id string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sid := s.stanzaIDs[id]
+	if sid == "" {
+		return "", fmt.Errorf("xmpp: message '%s' not confirmed by room", id)
+	}
+	return sid, nil
+}
+
+// ownID returns the ID of our message to which the room assigned the stanza
+// ID sid, if there is one.
+func (s *Session) ownID(sid string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for own, id := range s.stanzaIDs {
+		if id == sid {
+			return own, true
+		}
+	}
+	return "", false
+}
+
+// React sets our reactions to the message with the given ID from to (a
+// room, if groupchat), which replaces all previous ones. Messages in rooms
+// are referred to by their stanza ID, our own ones by their ID.
+func (s *Session) React(to, id string, groupchat bool, reactions []string) error {
+	t, err := jid.Parse(to)
+	if err != nil {
+		return err
+	}
+	typ := Chat
+	if groupchat {
+		typ = Groupchat
+		s.mutex.Lock()
+		if sid := s.stanzaIDs[id]; sid != "" {
+			id = sid
+		}
+		s.mutex.Unlock()
+	}
+	sid, err := NewID()
+	if err != nil {
+		return err
+	}
+	_, err = s.talk.SendOrg(reactionsStanza(t.Bare().String(), typ, sid, id, reactions))
+	return err
+}
+
+// reactionsElem is the content of a reactions element.
+type reactionsElem struct {
+	Reactions []string `xml:"reaction"`
+}
+
+// parseReactions returns the reactions if the message v from from contains
+// them.
+func (s *Session) parseReactions(from jid.JID, v *xmpp.Chat) *Reactions {
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space != nsReactions || elem.XMLName.Local != "reactions" {
+			continue
+		}
+		var x reactionsElem
+		err := xml.Unmarshal([]byte("<reactions>"+elem.InnerXML+"</reactions>"), &x)
+		if err != nil {
+			return nil
+		}
+		r := &Reactions{
+			From: from.Bare().String(),
+			ID:   elemAttr(v, nsReactions, "reactions", "id"),
+		}
+		if r.ID == "" {
+			return nil
+		}
+		seen := make(map[string]bool)
+		for _, reaction := range x.Reactions {
+			if reaction == "" || len(reaction) > maxReactionLength || seen[reaction] ||
+				len(r.Reactions) == maxReactions {
+				continue
+			}
+			seen[reaction] = true
+			r.Reactions = append(r.Reactions, reaction)
+		}
+		if v.Type == Groupchat {
+			if from.Resource == "" {
+				return nil
+			}
+			r.Nick = from.Resource
+			if own, ok := s.ownID(r.ID); ok {
+				r.ID = own
+			}
+		}
+		return r
+	}
+	return nil
+}
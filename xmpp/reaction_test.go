@@ -0,0 +1,82 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"github.com/mattn/go-xmpp"
+)
+
+func TestReactionsStanza(t *testing.T) {
+	stanza := reactionsStanza("bob@example.com", Chat, "x1", "m1", []string{"👍", "<3"})
+	want := `<message to='bob@example.com' type='chat' id='x1'>` +
+		`<reactions xmlns='urn:xmpp:reactions:0' id='m1'><reaction>👍</reaction>` +
+		`<reaction>&lt;3</reaction></reactions><store xmlns='urn:xmpp:hints'/></message>`
+	if stanza != want {
+		t.Errorf("reactionsStanza() = %s, want %s", stanza, want)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	s := newTestSession()
+	room := "ops@conference.example.com"
+	s.stanzaIDs["own1"] = "s1"
+	reactions := func(id, inner string) []xmpp.XMLElement {
+		return []xmpp.XMLElement{{
+			XMLName:  xml.Name{Space: nsReactions, Local: "reactions"},
+			Attr:     []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+			InnerXML: inner,
+		}}
+	}
+	tests := []struct {
+		v    xmpp.Chat
+		want *Reactions
+	}{
+		{
+			xmpp.Chat{Remote: "bob@example.com/phone", Type: "chat",
+				OtherElem: reactions("m1", "<reaction>👍</reaction><reaction>👍</reaction><reaction>🐢</reaction>")},
+			&Reactions{From: "bob@example.com", ID: "m1", Reactions: []string{"👍", "🐢"}},
+		},
+		// all reactions removed
+		{
+			xmpp.Chat{Remote: "bob@example.com/phone", Type: "chat", OtherElem: reactions("m1", "")},
+			&Reactions{From: "bob@example.com", ID: "m1"},
+		},
+		{
+			xmpp.Chat{Remote: room + "/bob", Type: "groupchat",
+				OtherElem: reactions("s2", "<reaction>👍</reaction>")},
+			&Reactions{From: room, Nick: "bob", ID: "s2", Reactions: []string{"👍"}},
+		},
+		// reaction to our own message
+		{
+			xmpp.Chat{Remote: room + "/bob", Type: "groupchat",
+				OtherElem: reactions("s1", "<reaction>👍</reaction>")},
+			&Reactions{From: room, Nick: "bob", ID: "own1", Reactions: []string{"👍"}},
+		},
+		// our own reactions reflected by the room
+		{
+			xmpp.Chat{Remote: room + "/al", Type: "groupchat",
+				OtherElem: reactions("s2", "<reaction>👍</reaction>")},
+			nil,
+		},
+		// the room itself does not react
+		{
+			xmpp.Chat{Remote: room, Type: "groupchat",
+				OtherElem: reactions("s2", "<reaction>👍</reaction>")},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		ev := s.chatEvent(&test.v)
+		if test.want == nil {
+			if ev != nil {
+				t.Errorf("unexpected event: %+v", ev)
+			}
+			continue
+		}
+		if ev == nil || ev.Kind != ReactionEvent || !reflect.DeepEqual(ev.Reactions, test.want) {
+			t.Errorf("chatEvent() = %+v, want %+v", ev, test.want)
+		}
+	}
+}
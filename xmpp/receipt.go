@@ -0,0 +1,177 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+
+	"github.com/frankbraun/mole/jid"
+	"github.com/mattn/go-xmpp"
+)
+
+// Namespaces of Message Delivery Receipts (XEP-0184), Chat Markers
+// (XEP-0333), and XMPP Ping (XEP-0199).
+const (
+	nsReceipts = "urn:xmpp:receipts"
+	nsMarkers  = "urn:xmpp:chat-markers:0"
+	nsPing     = "urn:xmpp:ping"
+)
+
+// Delivery states of sent messages, in ascending order.
+const (
+	Sent      = "sent"      // passed to the session
+	Acked     = "acked"     // processed by our server
+	Delivered = "delivered" // received by a client of the recipient
+	Displayed = "displayed" // displayed to the recipient
+)
+
+// stateRank orders the delivery states.
+var stateRank = map[string]int{Sent: 1, Acked: 2, Delivered: 3, Displayed: 4}
+
+// Later reports whether the delivery state a is later than b.
+func Later(a, b string) bool {
+	return stateRank[a] > stateRank[b]
+}
+
+// Receipt is a change of the delivery state of a sent message.
+type Receipt struct {
+	ID    string `json:"id"`    // ID of sent message
+	From  string `json:"from"`  // bare JID of recipient
+	State string `json:"state"` // Acked, Delivered, or Displayed
+}
+
+// writeElem writes the element <name xmlns='space' id='id'/> to b.
+func writeElem(b *bytes.Buffer, name, space, id string) {
+	b.WriteString("<" + name + " xmlns='" + space + "'")
+	if id != "" {
+		b.WriteString(" id='")
+		xml.EscapeText(b, []byte(id))
+		b.WriteString("'")
+	}
+	b.WriteString("/>")
+}
+
+// messageStanza returns the message stanza for msg to to. Chat messages
+// request a delivery receipt and are markable, markers are sent as a
+// delivery receipt (Delivered) or displayed marker (Displayed) for the
+// message msg.ID. Messages without text are standalone chat state
+// notifications, messages with msg.Replace correct the message with this ID,
+// and messages with msg.ReplyID reply to the message with this ID.
+func messageStanza(msg *Message, to, id string) string {
+	var b bytes.Buffer
+	b.WriteString("<message to='")
+	xml.EscapeText(&b, []byte(to))
+	b.WriteString("' type='" + msg.Type + "' id='")
+	xml.EscapeText(&b, []byte(id))
+	b.WriteString("'>")
+	switch msg.Marker {
+	case Delivered:
+		writeElem(&b, "received", nsReceipts, msg.ID)
+	case Displayed:
+		writeElem(&b, "displayed", nsMarkers, msg.ID)
+	default:
+		if msg.Text == "" {
+			writeChatState(&b, msg) // standalone notification
+			break
+		}
+		if msg.ReplyID != "" {
+			writeReply(&b, msg)
+		} else {
+			b.WriteString("<body>")
+			xml.EscapeText(&b, []byte(msg.Text))
+			b.WriteString("</body>")
+		}
+		if msg.Type == Chat {
+			writeElem(&b, "request", nsReceipts, "")
+			writeElem(&b, "markable", nsMarkers, "")
+		}
+		writeChatState(&b, msg)
+		if msg.Replace != "" {
+			writeElem(&b, "replace", nsCorrect, msg.Replace)
+		}
+	}
+	b.WriteString("</message>")
+	return b.String()
+}
+
+// send msg to its recipient. Chat messages are followed by a ping to our
+// server, whose response acknowledges the message (see handleIQ), because
+// the server processes the stanzas of a stream in order.
+func (s *Session) send(msg *Message) error {
+	to, err := jid.Parse(msg.To)
+	if err != nil {
+		return err
+	}
+	if msg.Type == "" {
+		msg.Type = Chat
+	}
+	id := msg.ID
+	if id == "" || msg.Marker != "" {
+		if id, err = NewID(); err != nil {
+			return err
+		}
+	}
+	if msg.Type == Groupchat && msg.ReplyID != "" {
+		s.mutex.Lock()
+		if sid := s.stanzaIDs[msg.ReplyID]; sid != "" {
+			reply := *msg
+			reply.ReplyID = sid // our own message is referred to by its stanza ID
+			msg = &reply
+		}
+		s.mutex.Unlock()
+	}
+	if _, err := s.talk.SendOrg(messageStanza(msg, to.String(), id)); err != nil {
+		return err
+	}
+	if msg.Type != Chat || msg.Marker != "" || msg.Text == "" {
+		return nil
+	}
+	server, err := jid.Parse(s.account)
+	if err != nil {
+		return err
+	}
+	pingID, err := NewID()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.acks[pingID] = Receipt{ID: id, From: to.Bare().String(), State: Acked}
+	s.mutex.Unlock()
+	_, err = s.talk.SendOrg("<iq to='" + escape(server.Domain) + "' type='get' id='" +
+		pingID + "'><ping xmlns='" + nsPing + "'/></iq>")
+	return err
+}
+
+// parseReceipt returns the receipt if v from from contains a delivery
+// receipt or a chat marker.
+func parseReceipt(from jid.JID, v *xmpp.Chat) *Receipt {
+	for _, elem := range v.OtherElem {
+		var state string
+		switch {
+		case elem.XMLName.Space == nsReceipts && elem.XMLName.Local == "received":
+			state = Delivered
+		case elem.XMLName.Space == nsMarkers && elem.XMLName.Local == "received":
+			state = Delivered
+		case elem.XMLName.Space == nsMarkers &&
+			(elem.XMLName.Local == "displayed" || elem.XMLName.Local == "acknowledged"):
+			state = Displayed
+		default:
+			continue
+		}
+		for _, attr := range elem.Attr {
+			if attr.Name.Local == "id" && attr.Value != "" {
+				return &Receipt{ID: attr.Value, From: from.Bare().String(), State: state}
+			}
+		}
+	}
+	return nil
+}
+
+// hasElem reports whether v contains the element name in namespace space.
+func hasElem(v *xmpp.Chat, space, name string) bool {
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space == space && elem.XMLName.Local == name {
+			return true
+		}
+	}
+	return false
+}
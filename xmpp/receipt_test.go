@@ -0,0 +1,92 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/mattn/go-xmpp"
+)
+
+func TestMessageStanza(t *testing.T) {
+	tests := []struct {
+		msg    Message
+		stanza string
+	}{
+		{
+			Message{Type: Chat, Text: "a < b"},
+			`<message to='bob@example.com' type='chat' id='x1'><body>a &lt; b</body>` +
+				`<request xmlns='urn:xmpp:receipts'/><markable xmlns='urn:xmpp:chat-markers:0'/></message>`,
+		},
+		{
+			Message{Type: Groupchat, Text: "hi"},
+			`<message to='bob@example.com' type='groupchat' id='x1'><body>hi</body></message>`,
+		},
+		{
+			Message{Type: Chat, Marker: Delivered, ID: "m1"},
+			`<message to='bob@example.com' type='chat' id='x1'><received xmlns='urn:xmpp:receipts' id='m1'/></message>`,
+		},
+		{
+			Message{Type: Chat, Marker: Displayed, ID: "m1"},
+			`<message to='bob@example.com' type='chat' id='x1'><displayed xmlns='urn:xmpp:chat-markers:0' id='m1'/></message>`,
+		},
+		{
+			Message{Type: Chat, Text: "hi", ChatState: Active},
+			`<message to='bob@example.com' type='chat' id='x1'><body>hi</body>` +
+				`<request xmlns='urn:xmpp:receipts'/><markable xmlns='urn:xmpp:chat-markers:0'/>` +
+				`<active xmlns='http://jabber.org/protocol/chatstates'/></message>`,
+		},
+		{
+			Message{Type: Groupchat, Text: "fixed", Replace: "m1"},
+			`<message to='bob@example.com' type='groupchat' id='x1'><body>fixed</body>` +
+				`<replace xmlns='urn:xmpp:message-correct:0' id='m1'/></message>`,
+		},
+		{
+			Message{Type: Chat, ChatState: Composing},
+			`<message to='bob@example.com' type='chat' id='x1'><composing xmlns='http://jabber.org/protocol/chatstates'/>` +
+				`<no-store xmlns='urn:xmpp:hints'/></message>`,
+		},
+	}
+	for _, test := range tests {
+		if stanza := messageStanza(&test.msg, "bob@example.com", "x1"); stanza != test.stanza {
+			t.Errorf("messageStanza() = %s, want %s", stanza, test.stanza)
+		}
+	}
+}
+
+func TestReceiptEvent(t *testing.T) {
+	s := newTestSession()
+	ev := s.chatEvent(&xmpp.Chat{
+		Remote: "bob@example.com/phone",
+		Type:   "chat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsMarkers, Local: "displayed"},
+			Attr:    []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "m1"}},
+		}},
+	})
+	if ev.Kind != ReceiptEvent || ev.Receipt.ID != "m1" ||
+		ev.Receipt.From != "bob@example.com" || ev.Receipt.State != Displayed {
+		t.Errorf("unexpected receipt event: %+v", ev)
+	}
+	ev = s.chatEvent(&xmpp.Chat{
+		Remote: "bob@example.com/phone",
+		ID:     "m2",
+		Type:   "chat",
+		Text:   "hi",
+		OtherElem: []xmpp.XMLElement{
+			{XMLName: xml.Name{Space: nsReceipts, Local: "request"}},
+		},
+	})
+	if ev.Kind != MessageEvent || ev.Message.ID != "m2" || !ev.Message.Receipt ||
+		ev.Message.Markable {
+		t.Errorf("unexpected message event: %+v", ev.Message)
+	}
+	// acknowledgement by server
+	s.acks = map[string]Receipt{"p1": {ID: "m3", From: "bob@example.com", State: Acked}}
+	ev = s.handleIQ(&xmpp.IQ{ID: "p1", Type: "result"})
+	if ev == nil || ev.Kind != ReceiptEvent || ev.Receipt.ID != "m3" || len(s.acks) != 0 {
+		t.Errorf("unexpected ack event: %+v", ev)
+	}
+	if !Later(Delivered, Acked) || Later(Acked, Displayed) {
+		t.Error("Later() failed")
+	}
+}
@@ -0,0 +1,87 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-xmpp"
+)
+
+// nsReply is the namespace of Message Replies (XEP-0461).
+const nsReply = "urn:xmpp:reply:0"
+
+// quote returns text quoted as fallback for clients which do not support
+// replies.
+func quote(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString("> " + line + "\n")
+	}
+	return b.String()
+}
+
+// unquote returns the quoted text of a fallback.
+func unquote(fallback string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(fallback, "\n"), "\n") {
+		line = strings.TrimPrefix(line, ">")
+		lines = append(lines, strings.TrimPrefix(line, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeReply writes the body of the reply msg, which starts with the quote
+// as fallback, and the reply elements to b.
+func writeReply(b *bytes.Buffer, msg *Message) {
+	fallback := quote(msg.Quote)
+	b.WriteString("<body>")
+	xml.EscapeText(b, []byte(fallback+msg.Text))
+	b.WriteString("</body><reply xmlns='" + nsReply + "' id='" + escape(msg.ReplyID) + "'")
+	if msg.ReplyTo != "" {
+		b.WriteString(" to='" + escape(msg.ReplyTo) + "'")
+	}
+	b.WriteString("/><fallback xmlns='" + nsFallback + "' for='" + nsReply +
+		"'><body start='0' end='" + strconv.Itoa(utf8.RuneCountInString(fallback)) +
+		"'/></fallback>")
+}
+
+// fallbackElem is the content of a fallback element.
+type fallbackElem struct {
+	Bodies []struct {
+		Start *int `xml:"start,attr"`
+		End   *int `xml:"end,attr"`
+	} `xml:"body"`
+}
+
+// parseReply sets the reply fields of msg, if v is a reply. The fallback
+// quote is removed from the text of msg.
+func parseReply(v *xmpp.Chat, msg *Message) {
+	msg.ReplyID = elemAttr(v, nsReply, "reply", "id")
+	if msg.ReplyID == "" {
+		return
+	}
+	msg.ReplyTo = elemAttr(v, nsReply, "reply", "to")
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space != nsFallback || elem.XMLName.Local != "fallback" ||
+			elemAttr(v, nsFallback, "fallback", "for") != nsReply {
+			continue
+		}
+		var x fallbackElem
+		err := xml.Unmarshal([]byte("<fallback>"+elem.InnerXML+"</fallback>"), &x)
+		if err != nil || len(x.Bodies) == 0 {
+			return
+		}
+		body := x.Bodies[0]
+		text := []rune(msg.Text)
+		if body.Start == nil || body.End == nil || *body.Start < 0 ||
+			*body.Start > *body.End || *body.End > len(text) {
+			return
+		}
+		msg.Quote = unquote(string(text[*body.Start:*body.End]))
+		msg.Text = string(text[:*body.Start]) + string(text[*body.End:])
+		return
+	}
+}
@@ -0,0 +1,57 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/mattn/go-xmpp"
+)
+
+func TestReply(t *testing.T) {
+	msg := &Message{Type: Chat, Text: "me too", ReplyTo: "bob@example.com",
+		ReplyID: "m1", Quote: "ünïcode\nrocks"}
+	stanza := messageStanza(msg, "bob@example.com", "x1")
+	want := `<message to='bob@example.com' type='chat' id='x1'>` +
+		`<body>&gt; ünïcode&#xA;&gt; rocks&#xA;me too</body>` +
+		`<reply xmlns='urn:xmpp:reply:0' id='m1' to='bob@example.com'/>` +
+		`<fallback xmlns='urn:xmpp:fallback:0' for='urn:xmpp:reply:0'><body start='0' end='18'/></fallback>` +
+		`<request xmlns='urn:xmpp:receipts'/><markable xmlns='urn:xmpp:chat-markers:0'/></message>`
+	if stanza != want {
+		t.Fatalf("messageStanza() = %s, want %s", stanza, want)
+	}
+
+	var v struct {
+		Body  string `xml:"body"`
+		Other []struct {
+			XMLName  xml.Name
+			Attr     []xml.Attr `xml:",any,attr"`
+			InnerXML string     `xml:",innerxml"`
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal([]byte(stanza), &v); err != nil {
+		t.Fatalf("xml.Unmarshal() failed: %v", err)
+	}
+	chat := xmpp.Chat{Remote: "alice@example.com/laptop", ID: "x1", Type: "chat", Text: v.Body}
+	for _, elem := range v.Other {
+		chat.OtherElem = append(chat.OtherElem, xmpp.XMLElement{
+			XMLName: elem.XMLName, Attr: elem.Attr, InnerXML: elem.InnerXML,
+		})
+	}
+	s := newTestSession()
+	ev := s.chatEvent(&chat)
+	if ev == nil || ev.Kind != MessageEvent {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	got := ev.Message
+	if got.Text != msg.Text || got.Quote != msg.Quote || got.ReplyID != "m1" ||
+		got.ReplyTo != "bob@example.com" {
+		t.Errorf("parsed reply = %+v, want %+v", got, msg)
+	}
+
+	// invalid fallback ranges are ignored
+	chat.OtherElem[1].InnerXML = "<body start='0' end='99'/>"
+	if ev := s.chatEvent(&chat); ev == nil || ev.Message.Text != v.Body ||
+		ev.Message.Quote != "" || ev.Message.ReplyID != "m1" {
+		t.Errorf("unexpected event for invalid fallback: %+v", ev)
+	}
+}
@@ -0,0 +1,177 @@
+package xmpp
+
+import (
+	"encoding/xml"
+
+	"github.com/frankbraun/mole/jid"
+	"github.com/mattn/go-xmpp"
+)
+
+// Namespaces of Message Retraction (XEP-0424), Message Moderation
+// (XEP-0425), Unique and Stable Stanza IDs (XEP-0359), and Fallback
+// Indication (XEP-0428).
+const (
+	nsRetract  = "urn:xmpp:message-retract:1"
+	nsModerate = "urn:xmpp:message-moderate:1"
+	nsSID      = "urn:xmpp:sid:0"
+	nsFallback = "urn:xmpp:fallback:0"
+)
+
+// retractFallback is the body of retractions for clients which do not
+// support them.
+const retractFallback = "This person attempted to retract a previous message, " +
+	"but it's unsupported by your client."
+
+// Retraction is the retraction of a message by its sender or by a moderator
+// of the room.
+type Retraction struct {
+	From      string `json:"from"`                // bare JID of sender (or room)
+	Nick      string `json:"nick,omitempty"`      // nickname of sender in room (empty if moderated)
+	ID        string `json:"id"`                  // ID of retracted message (stanza ID in rooms, unless Own)
+	Own       bool   `json:"own,omitempty"`       // retracted message has been sent by us
+	Moderated bool   `json:"moderated,omitempty"` // retracted by a moderator
+	By        string `json:"by,omitempty"`        // moderator (if known)
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Matches reports whether msg in the conversation of account with r.From
+// is retracted by r.
+func (r *Retraction) Matches(account string, msg *Message) bool {
+	switch {
+	case r.Own:
+		return msg.From == account && msg.ID == r.ID
+	case msg.Type == Groupchat:
+		return msg.From == r.From && msg.StanzaID == r.ID &&
+			(r.Moderated || msg.Nick == r.Nick)
+	default:
+		return msg.From == r.From && msg.ID == r.ID
+	}
+}
+
+// retractStanza returns the message stanza with the given ID and type to
+// to, which retracts the message retracted.
+func retractStanza(to, typ, id, retracted string) string {
+	return "<message to='" + escape(to) + "' type='" + typ + "' id='" + escape(id) +
+		"'><retract xmlns='" + nsRetract + "' id='" + escape(retracted) +
+		"'/><fallback xmlns='" + nsFallback + "' for='" + nsRetract +
+		"'/><body>" + retractFallback + "</body><store xmlns='" + nsHints +
+		"'/></message>"
+}
+
+// Retract our message with the given ID sent to to (a room, if groupchat),
+// which asks the recipients to remove it. Messages sent to rooms can only
+// be retracted after the room confirmed them with its stanza ID.
+func (s *Session) Retract(to, id string, groupchat bool) error {
+	t, err := jid.Parse(to)
+	if err != nil {
+		return err
+	}
+	typ := Chat
+	if groupchat {
+		typ = Groupchat
+		if id, err = s.roomStanzaID(id); err != nil {
+			return err
+		}
+	}
+	sid, err := NewID()
+	if err != nil {
+		return err
+	}
+	_, err = s.talk.SendOrg(retractStanza(t.Bare().String(), typ, sid, id))
+	return err
+}
+
+// Moderate retracts the message with the given stanza ID from room, which
+// requires the moderator role.
+func (s *Session) Moderate(room, id, reason string) error {
+	r, err := roomJID(room)
+	if err != nil {
+		return err
+	}
+	inner := "<moderate xmlns='" + nsModerate + "' id='" + escape(id) +
+		"'><retract xmlns='" + nsRetract + "'/>"
+	if reason != "" {
+		inner += "<reason>" + escape(reason) + "</reason>"
+	}
+	inner += "</moderate>"
+	_, err = s.iq(r, "set", inner)
+	return err
+}
+
+// stanzaID returns the stanza ID assigned to v by by (empty if none).
+func stanzaID(v *xmpp.Chat, by string) string {
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space != nsSID || elem.XMLName.Local != "stanza-id" {
+			continue
+		}
+		var id, assigner string
+		for _, attr := range elem.Attr {
+			switch attr.Name.Local {
+			case "id":
+				id = attr.Value
+			case "by":
+				assigner = attr.Value
+			}
+		}
+		if assigner == by {
+			return id
+		}
+	}
+	return ""
+}
+
+// retractElem is the content of the retract element of a retraction.
+type retractElem struct {
+	Moderated *struct {
+		By string `xml:"by,attr"`
+	} `xml:"urn:xmpp:message-moderate:1 moderated"`
+	Reason string `xml:"reason"`
+}
+
+// parseRetraction returns the retraction if the message v from from
+// retracts a message. Only the room itself retracts messages of others.
+func (s *Session) parseRetraction(from jid.JID, v *xmpp.Chat) *Retraction {
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space != nsRetract || elem.XMLName.Local != "retract" {
+			continue
+		}
+		var x retractElem
+		err := xml.Unmarshal([]byte("<retract>"+elem.InnerXML+"</retract>"), &x)
+		if err != nil {
+			return nil
+		}
+		r := &Retraction{
+			From:   from.Bare().String(),
+			ID:     elemAttr(v, nsRetract, "retract", "id"),
+			Reason: x.Reason,
+		}
+		switch {
+		case r.ID == "":
+			return nil
+		case v.Type != Groupchat:
+			if x.Moderated != nil {
+				return nil
+			}
+			return r
+		case x.Moderated != nil:
+			if from.Resource != "" {
+				return nil
+			}
+			r.Moderated = true
+			r.By = x.Moderated.By
+		case from.Resource == "":
+			return nil
+		default:
+			r.Nick = from.Resource
+		}
+		// retraction of our own message
+		if r.Moderated || r.Nick == s.nick(r.From) {
+			if own, ok := s.ownID(r.ID); ok {
+				r.ID = own
+				r.Own = true
+			}
+		}
+		return r
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/mattn/go-xmpp"
+)
+
+func TestRetractStanza(t *testing.T) {
+	stanza := retractStanza("bob@example.com", Chat, "x1", "m1")
+	want := `<message to='bob@example.com' type='chat' id='x1'>` +
+		`<retract xmlns='urn:xmpp:message-retract:1' id='m1'/>` +
+		`<fallback xmlns='urn:xmpp:fallback:0' for='urn:xmpp:message-retract:1'/>` +
+		`<body>` + retractFallback + `</body><store xmlns='urn:xmpp:hints'/></message>`
+	if stanza != want {
+		t.Errorf("retractStanza() = %s, want %s", stanza, want)
+	}
+}
+
+func TestRetraction(t *testing.T) {
+	s := newTestSession()
+	room := "ops@conference.example.com"
+	retract := func(id, inner string) xmpp.XMLElement {
+		return xmpp.XMLElement{
+			XMLName:  xml.Name{Space: nsRetract, Local: "retract"},
+			Attr:     []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+			InnerXML: inner,
+		}
+	}
+	sid := func(id string) xmpp.XMLElement {
+		return xmpp.XMLElement{
+			XMLName: xml.Name{Space: nsSID, Local: "stanza-id"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "id"}, Value: id},
+				{Name: xml.Name{Local: "by"}, Value: room},
+			},
+		}
+	}
+	moderated := `<moderated xmlns='urn:xmpp:message-moderate:1' by='` + room +
+		`/mod'/><reason>spam</reason>`
+	// our own message reflected by the room
+	if ev := s.chatEvent(&xmpp.Chat{
+		Remote: room + "/al", ID: "own1", Type: "groupchat", Text: "secret",
+		OtherElem: []xmpp.XMLElement{sid("s1")},
+	}); ev != nil || s.stanzaIDs["own1"] != "s1" {
+		t.Fatalf("reflected message not recorded: %+v", ev)
+	}
+	ev := s.chatEvent(&xmpp.Chat{
+		Remote: room + "/bob", ID: "m2", Type: "groupchat", Text: "hi",
+		OtherElem: []xmpp.XMLElement{sid("s2")},
+	})
+	if ev == nil || ev.Message.StanzaID != "s2" {
+		t.Fatalf("stanza ID not parsed: %+v", ev)
+	}
+	msg := ev.Message
+	tests := []struct {
+		v    xmpp.Chat
+		want *Retraction
+	}{
+		{
+			xmpp.Chat{Remote: "bob@example.com/phone", Type: "chat", Text: retractFallback,
+				OtherElem: []xmpp.XMLElement{retract("m1", "")}},
+			&Retraction{From: "bob@example.com", ID: "m1"},
+		},
+		{
+			xmpp.Chat{Remote: room + "/bob", Type: "groupchat", Text: retractFallback,
+				OtherElem: []xmpp.XMLElement{retract("s2", "")}},
+			&Retraction{From: room, Nick: "bob", ID: "s2"},
+		},
+		{
+			xmpp.Chat{Remote: room, Type: "groupchat",
+				OtherElem: []xmpp.XMLElement{retract("s2", moderated)}},
+			&Retraction{From: room, ID: "s2", Moderated: true, By: room + "/mod", Reason: "spam"},
+		},
+		{
+			xmpp.Chat{Remote: room, Type: "groupchat",
+				OtherElem: []xmpp.XMLElement{retract("s1", moderated)}},
+			&Retraction{From: room, ID: "own1", Own: true, Moderated: true, By: room + "/mod", Reason: "spam"},
+		},
+		// another occupant cannot retract our message
+		{
+			xmpp.Chat{Remote: room + "/bob", Type: "groupchat", Text: retractFallback,
+				OtherElem: []xmpp.XMLElement{retract("s1", "")}},
+			&Retraction{From: room, Nick: "bob", ID: "s1"},
+		},
+		// only the room moderates
+		{
+			xmpp.Chat{Remote: room + "/bob", Type: "groupchat",
+				OtherElem: []xmpp.XMLElement{retract("s2", moderated)}},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		ev := s.chatEvent(&test.v)
+		if test.want == nil {
+			if ev != nil {
+				t.Errorf("unexpected event: %+v", ev)
+			}
+			continue
+		}
+		if ev == nil || ev.Kind != RetractEvent || *ev.Retraction != *test.want {
+			t.Errorf("chatEvent() = %+v, want %+v", ev, test.want)
+		}
+	}
+	r := tests[2].want
+	if !r.Matches("alice@example.com", msg) || tests[4].want.Matches("alice@example.com", msg) {
+		t.Error("Matches() failed")
+	}
+}
@@ -2,63 +2,330 @@
 package xmpp
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/frankbraun/codechain/util/log"
 	"github.com/frankbraun/mole/config"
-	"github.com/frankbraun/mole/util"
+	"github.com/frankbraun/mole/jid"
 	"github.com/mattn/go-xmpp"
 )
 
-// Start XMPP client for the given account.
-// Messages are read from send channel and sent to server.
-// Messages retrieved from server a written to recv channel.
-func Start(
-	account *config.Account,
-	send <-chan string,
-	recv chan<- string,
-	debug bool,
-) error {
+// Session is a running XMPP client session.
+type Session struct {
+	mutex     sync.Mutex
+	talk      *xmpp.Client
+	account   string                  // username of account
+	closed    bool                    // session has been closed
+	rooms     map[string]string       // our nicknames in joined rooms
+	pending   map[string]chan xmpp.IQ // pending IQ requests by ID
+	acks      map[string]Receipt      // pending acknowledgements by ping ID
+	stanzaIDs map[string]string       // stanza IDs assigned by rooms to our messages
+	sendDone  chan struct{}           // closed when the send channel has been drained
+
+	bookmarkStorage string // "pep" or "private" (empty if not yet known)
+}
+
+// connect to the XMPP server of account. The connection must be encrypted.
+func connect(account *config.Account, debug bool) (*xmpp.Client, error) {
 	xmpp.DefaultConfig = tls.Config{
 		InsecureSkipVerify: true,
 	}
 	options := xmpp.Options{
 		User:     account.Username,
-		Password: account.Password,
+		Password: string(account.Password), // go-xmpp requires a string
 		NoTLS:    true,
 		StartTLS: true,
 		Debug:    debug,
+		// go-xmpp refuses to authenticate without TLS then
+		InsecureAllowUnencryptedAuth: false,
 	}
+	return options.NewClient()
+}
 
-	talk, err := options.NewClient()
+// Send a single message with text to remote from the given account and
+// disconnect again.
+func Send(account *config.Account, remote, text string, debug bool) error {
+	to, err := jid.Parse(remote)
+	if err != nil {
+		return err
+	}
+	talk, err := connect(account, debug)
+	if err != nil {
+		return err
+	}
+	_, err = talk.Send(xmpp.Chat{Remote: to.String(), Type: "chat", Text: text})
 	if err != nil {
+		talk.Close()
 		return err
 	}
+	return talk.Close()
+}
+
+// Start XMPP client for the given account.
+// Messages are read from send channel and sent to their recipient via the
+// server. Messages and presence stanzas retrieved from the server are
+// written as events to the recv channel. The session ends when Close is
+// called or the connection is lost (signaled by a DisconnectEvent), the recv
+// channel is closed then and the caller should close the send channel.
+func Start(
+	account *config.Account,
+	send <-chan Message,
+	recv chan<- Event,
+	debug bool,
+) (*Session, error) {
+	talk, err := connect(account, debug)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		talk:      talk,
+		account:   account.Username,
+		rooms:     make(map[string]string),
+		pending:   make(map[string]chan xmpp.IQ),
+		acks:      make(map[string]Receipt),
+		stanzaIDs: make(map[string]string),
+		sendDone:  make(chan struct{}),
+	}
 
 	go func() {
+		defer close(recv)
 		for {
-			chat, err := talk.Recv()
+			stanza, err := talk.Recv()
 			if err != nil {
-				// TODO: better handling
-				log.Printf("fatal(): %v", err)
-				util.Fatal(err)
+				if s.isClosed() {
+					return
+				}
+				log.Printf("receiving from XMPP server failed: %v", err)
+				recv <- Event{
+					Kind:    DisconnectEvent,
+					Account: account.Username,
+					Error:   err.Error(),
+				}
+				return
 			}
-			switch v := chat.(type) {
+			var ev *Event
+			switch v := stanza.(type) {
 			case xmpp.Chat:
-				recv <- v.Text
+				ev = s.chatEvent(&v)
 			case xmpp.Presence:
-				// TODO: handle presence messages
-				// fmt.Println(v.From, v.Show)
+				ev = s.presenceEvent(&v)
+			case xmpp.IQ:
+				ev = s.handleIQ(&v)
+			}
+			if ev != nil {
+				ev.Account = account.Username
+				recv <- *ev
 			}
 		}
 	}()
 
 	go func() {
-		for {
-			msg := <-send
-			talk.Send(xmpp.Chat{Remote: account.Contact, Type: "chat", Text: msg})
+		defer close(s.sendDone)
+		for msg := range send {
+			if err := s.send(&msg); err != nil {
+				log.Printf("sending message to '%s' failed: %v", msg.To, err)
+			}
 		}
 	}()
 
-	return nil
+	return s, nil
+}
+
+// chatEvent returns the event for the received message v (nil if it should
+// be ignored).
+func (s *Session) chatEvent(v *xmpp.Chat) *Event {
+	from, err := jid.Parse(v.Remote)
+	if err != nil {
+		log.Printf("ignoring message from invalid JID '%s': %v", v.Remote, err)
+		return nil
+	}
+	msg := &Message{
+		From:    from.Bare().String(),
+		To:      s.account,
+		Text:    v.Text,
+		Time:    v.Stamp,
+		Delayed: !v.Stamp.IsZero(),
+	}
+	if !msg.Delayed {
+		msg.Time = time.Now()
+	}
+	if i := parseInvite(from, v); i != nil {
+		return &Event{Kind: InviteEvent, Invite: i}
+	}
+	if r := parseReceipt(from, v); r != nil && v.Type != Groupchat {
+		return &Event{Kind: ReceiptEvent, Receipt: r}
+	}
+	if ev := chatStateEvent(from, v); ev != nil {
+		return ev
+	}
+	if r := s.parseRetraction(from, v); r != nil {
+		return &Event{Kind: RetractEvent, Retraction: r}
+	}
+	if r := s.parseReactions(from, v); r != nil {
+		if r.Nick != "" && r.Nick == s.nick(r.From) {
+			return nil // our own reactions reflected by the room
+		}
+		return &Event{Kind: ReactionEvent, Reactions: r}
+	}
+	if v.Type == Groupchat {
+		if v.Subject != "" && v.Text == "" {
+			return &Event{
+				Kind: SubjectEvent,
+				Subject: &Subject{
+					Room: msg.From,
+					Nick: from.Resource,
+					Text: v.Subject,
+				},
+			}
+		}
+		sid := stanzaID(v, msg.From)
+		if !msg.Delayed && from.Resource == s.nick(msg.From) {
+			// our own message reflected by the room
+			if sid != "" && v.ID != "" {
+				s.mutex.Lock()
+				s.stanzaIDs[v.ID] = sid
+				s.mutex.Unlock()
+			}
+			return nil
+		}
+		msg.Type = Groupchat
+		msg.Nick = from.Resource
+		msg.To = ""
+		msg.ID = v.ID
+		msg.StanzaID = sid
+	} else {
+		msg.ChatState = parseChatState(v)
+		if v.ID != "" {
+			msg.ID = v.ID
+			msg.Receipt = hasElem(v, nsReceipts, "request")
+			msg.Markable = hasElem(v, nsMarkers, "markable")
+		}
+	}
+	if msg.Text == "" {
+		return nil // ignore messages without body
+	}
+	msg.Replace = parseReplace(v)
+	parseReply(v, msg)
+	if msg.Type == Groupchat && msg.ReplyID != "" {
+		if own, ok := s.ownID(msg.ReplyID); ok {
+			msg.ReplyID = own
+		}
+	}
+	return &Event{Kind: MessageEvent, Message: msg}
+}
+
+// presenceEvent returns the event for the received presence v (nil if it
+// should be ignored).
+func (s *Session) presenceEvent(v *xmpp.Presence) *Event {
+	from, err := jid.Parse(v.From)
+	if err != nil {
+		log.Printf("ignoring presence from invalid JID '%s': %v", v.From, err)
+		return nil
+	}
+	room := from.Bare().String()
+	if nick := s.nick(room); nick != "" {
+		if v.Type == "error" && from.Resource != "" && from.Resource != nick {
+			// we are still in the room with our old nickname
+			return &Event{
+				Kind: OccupantEvent,
+				Occupant: &Occupant{
+					Room:  room,
+					Nick:  nick,
+					Self:  true,
+					Error: "changing nickname failed",
+				},
+			}
+		}
+		if v.Type == "error" {
+			s.mutex.Lock()
+			delete(s.rooms, room)
+			s.mutex.Unlock()
+			return &Event{
+				Kind: OccupantEvent,
+				Occupant: &Occupant{
+					Room:  room,
+					Nick:  nick,
+					Self:  true,
+					Left:  true,
+					Error: "joining room failed",
+				},
+			}
+		}
+		if o := parseOccupant(from, v); o != nil {
+			if o.Self {
+				s.mutex.Lock()
+				switch {
+				case o.NewNick != "":
+					s.rooms[room] = o.NewNick
+				case o.Left:
+					delete(s.rooms, room)
+				default:
+					s.rooms[room] = o.Nick // the room might have changed it
+				}
+				s.mutex.Unlock()
+			}
+			return &Event{Kind: OccupantEvent, Occupant: o}
+		}
+	}
+	return &Event{
+		Kind: PresenceEvent,
+		Presence: &Presence{
+			From:   from.String(),
+			Type:   v.Type,
+			Show:   v.Show,
+			Status: v.Status,
+		},
+	}
+}
+
+// SetPresence broadcasts the presence of the session. show must be empty
+// (available) or one of "away", "chat", "dnd", and "xa". status is an
+// optional human readable status message.
+func (s *Session) SetPresence(show, status string) error {
+	switch show {
+	case "", "away", "chat", "dnd", "xa":
+	default:
+		return fmt.Errorf("xmpp: invalid presence show '%s'", show)
+	}
+	var b bytes.Buffer
+	b.WriteString("<presence>")
+	if show != "" {
+		b.WriteString("<show>" + show + "</show>")
+	}
+	if status != "" {
+		b.WriteString("<status>")
+		xml.EscapeText(&b, []byte(status))
+		b.WriteString("</status>")
+	}
+	b.WriteString("</presence>")
+	_, err := s.talk.SendOrg(b.String())
+	return err
+}
+
+// Flush waits until all messages from the send channel have been sent.
+// The send channel must have been closed by the caller.
+func (s *Session) Flush() {
+	<-s.sendDone
+}
+
+func (s *Session) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+// Close the session.
+func (s *Session) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.talk.Close()
 }